@@ -0,0 +1,235 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+)
+
+// Outcomes of a check
+const (
+	checkOK       = "ok"
+	checkFallback = "fallback"
+	checkDenied   = "denied"
+	checkFailed   = "failed"
+	checkSkipped  = "-"
+)
+
+// apiCheck - An API call made by a collector
+type apiCheck struct {
+	collector string
+	name      string
+	call      func(ctx context.Context, t checkTarget) error
+}
+
+// checkTarget - The cluster a check runs against, with the IDs calls
+// about a single node or archive location need
+type checkTarget struct {
+	api        rubrik.Rubrik
+	nodeID     string
+	locationID string
+}
+
+// apiChecks - The calls of every collector, in the order the collectors
+// make them
+var apiChecks = []apiCheck{
+	{"rubrik", "GetStreamCount", func(ctx context.Context, t checkTarget) error {
+		_, err := t.api.GetStreamCount(ctx)
+		return err
+	}},
+	{"rubrik", "GetTaskDetails", func(ctx context.Context, t checkTarget) error {
+		_, err := t.api.GetTaskDetails(ctx)
+		return err
+	}},
+	{"rubrik", "GetRunawayRemaining", func(ctx context.Context, t checkTarget) error {
+		_, err := t.api.GetRunawayRemaining(ctx)
+		return err
+	}},
+	{"rubrik", "GetAverageStorageGrowthPerDay", func(ctx context.Context, t checkTarget) error {
+		_, err := t.api.GetAverageStorageGrowthPerDay(ctx)
+		return err
+	}},
+	{"rubrik", "GetNodes", func(ctx context.Context, t checkTarget) error {
+		_, err := t.api.GetNodes(ctx)
+		return err
+	}},
+	{"rubrik", "GetNodeStats", func(ctx context.Context, t checkTarget) error {
+		if t.nodeID == "" {
+			return nil
+		}
+		_, err := t.api.GetNodeStats(ctx, t.nodeID)
+		return err
+	}},
+	{"rubrik", "GetSystemStorage", func(ctx context.Context, t checkTarget) error {
+		_, err := t.api.GetSystemStorage(ctx)
+		return err
+	}},
+	{"rubrik", "GetDataLocationUsage", func(ctx context.Context, t checkTarget) error {
+		_, err := t.api.GetDataLocationUsage(ctx)
+		return err
+	}},
+	{"rubrik", "GetArchivalBandwith", func(ctx context.Context, t checkTarget) error {
+		if t.locationID == "" {
+			return nil
+		}
+		_, err := t.api.GetArchivalBandwith(ctx, t.locationID, "-10min")
+		return err
+	}},
+	{"rubrik", "GetPhysicalIngest", func(ctx context.Context, t checkTarget) error {
+		_, err := t.api.GetPhysicalIngest(ctx)
+		return err
+	}},
+	{"vm", "GetPerVMStorage", func(ctx context.Context, t checkTarget) error {
+		_, err := t.api.GetPerVMStorage(ctx)
+		return err
+	}},
+	{"vm", "ListAllVM", func(ctx context.Context, t checkTarget) error {
+		_, err := t.api.ListAllVM(ctx)
+		return err
+	}},
+	{"archive", "GetArchiveLocations", func(ctx context.Context, t checkTarget) error {
+		_, err := t.api.GetArchiveLocations(ctx)
+		return err
+	}},
+	{"managed_volume", "GetManagedVolumes", func(ctx context.Context, t checkTarget) error {
+		_, err := t.api.GetManagedVolumes(ctx)
+		return err
+	}},
+}
+
+// checkResult - How a call went on each backend
+type checkResult struct {
+	graphql  string
+	rest     string
+	result   string
+	duration time.Duration
+	err      error
+}
+
+// runCheck - Report the login api made when it was created and make every
+// call of the enabled collectors, first the way the collectors do with
+// GraphQL and the REST fallback, then against REST alone. Prints a table to
+// w, logs out and returns the exit code.
+func runCheck(ctx context.Context, api *rubrik.Rubrik, collectors map[string]Collector, w io.Writer) int {
+	defer api.Logout(context.WithoutCancel(ctx))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLUSTER\tCOLLECTOR\tCALL\tGRAPHQL\tREST\tRESULT\tDURATION\tERROR")
+
+	if s := api.Status(); !s.LoggedIn {
+		fmt.Fprintf(tw, "-\t-\tLogin\t-\t-\t%s\t-\t%s\n", checkFailed, s.LastError)
+		tw.Flush()
+		return 1
+	}
+	fmt.Fprintf(tw, "-\t-\tLogin\t-\t-\t%s\t-\t\n", checkOK)
+
+	clusters, err := api.GetClusters(ctx)
+	if err != nil {
+		fmt.Fprintf(tw, "-\t-\tGetClusters\t-\t-\t%s\t-\t%v\n", checkFailed, err)
+		tw.Flush()
+		return 1
+	}
+
+	counts := make(map[string]int)
+	for _, c := range clusters {
+		t := checkTarget{api: api.ForCluster(c)}
+		if nodes, err := t.api.GetNodes(ctx); err == nil && len(nodes) > 0 {
+			t.nodeID = nodes[0].ID
+		}
+		if locations, err := t.api.GetArchiveLocations(ctx); err == nil && len(locations) > 0 {
+			t.locationID = locations[0].ID
+		}
+
+		for _, check := range apiChecks {
+			if _, ok := collectors[check.collector]; !ok {
+				continue
+			}
+			r := check.run(ctx, t)
+			counts[r.result]++
+
+			errText := ""
+			if r.err != nil {
+				errText = r.err.Error()
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.Name, check.collector, check.name,
+				r.graphql, r.rest, r.result, r.duration.Round(time.Millisecond), errText)
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n%d ok, %d fallback, %d denied, %d failed, %d not available\n",
+		counts[checkOK], counts[checkFallback], counts[checkDenied], counts[checkFailed], counts[checkSkipped])
+	if counts[checkDenied] > 0 || counts[checkFailed] > 0 {
+		return 1
+	}
+	return 0
+}
+
+// run - Make the call and sort out what happened on which backend
+func (c apiCheck) run(ctx context.Context, t checkTarget) checkResult {
+	var trace rubrik.CallTrace
+	start := time.Now()
+	err := c.call(rubrik.WithCallTrace(ctx, &trace), t)
+	r := checkResult{duration: time.Since(start), err: err}
+
+	calls := trace.Calls()
+	r.graphql = outcome(calls, rubrik.BackendGraphQL)
+	r.rest = outcome(calls, rubrik.BackendREST)
+
+	// GraphQL answered, find out whether the REST fallback works too
+	if r.rest == checkSkipped && r.graphql != checkSkipped && !t.api.IsSecurityCloud() {
+		var restTrace rubrik.CallTrace
+		c.call(rubrik.WithCallTrace(rubrik.WithoutGraphQL(ctx), &restTrace), t)
+		r.rest = outcome(restTrace.Calls(), rubrik.BackendREST)
+	}
+
+	switch {
+	case len(calls) == 0:
+		// The call isn't available for this kind of endpoint
+		r.result = checkSkipped
+	case err == nil && r.graphql != checkOK && r.graphql != checkSkipped:
+		r.result = checkFallback
+	case err == nil:
+		r.result = checkOK
+	case r.graphql == checkDenied || r.rest == checkDenied:
+		r.result = checkDenied
+	default:
+		r.result = checkFailed
+	}
+	return r
+}
+
+// outcome - Summarize the calls to one backend, the worst one counts
+func outcome(calls []rubrik.Call, backend rubrik.Backend) string {
+	result := checkSkipped
+	for _, c := range calls {
+		if c.Backend != backend {
+			continue
+		}
+		switch {
+		case c.Denied():
+			result = checkDenied
+		case c.Err != nil:
+			if result != checkDenied {
+				result = checkFailed
+			}
+		case result == checkSkipped:
+			result = checkOK
+		}
+	}
+	return result
+}
@@ -0,0 +1,71 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubriktest"
+)
+
+func TestCheck(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject("graphql/SystemStorage", rubriktest.NotFound)
+
+	api := rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+	var out bytes.Buffer
+	if code := runCheck(context.Background(), api, newCollectors(collectorConfig{}), &out); code != 0 {
+		t.Errorf("runCheck() = %d, want 0:\n%s", code, out.String())
+	}
+	if !regexp.MustCompile(`GetSystemStorage\s+failed\s+ok\s+fallback`).Match(out.Bytes()) {
+		t.Errorf("GetSystemStorage not reported as fallback:\n%s", out.String())
+	}
+	// The session of the API instance is reused and ended
+	if srv.Logins() != 1 || srv.Requests(rubriktest.KeyLogout) != 1 {
+		t.Errorf("%d logins and %d logouts, want one each", srv.Logins(), srv.Requests(rubriktest.KeyLogout))
+	}
+}
+
+func TestCheckLoginFailed(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject(rubriktest.KeyLogin, rubriktest.Unauthorized)
+
+	api := rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+	var out bytes.Buffer
+	if code := runCheck(context.Background(), api, newCollectors(collectorConfig{}), &out); code == 0 {
+		t.Errorf("runCheck() = 0 without a login:\n%s", out.String())
+	}
+	if !regexp.MustCompile(`Login\s+-\s+-\s+failed\s+-\s+.*401`).Match(out.Bytes()) {
+		t.Errorf("Login not reported as failed:\n%s", out.String())
+	}
+}
+
+func TestCheckDenied(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject("graphql/ManagedVolumes", rubriktest.Forbidden)
+	srv.Inject("rest/managed_volume", rubriktest.Forbidden)
+
+	api := rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+	var out bytes.Buffer
+	if code := runCheck(context.Background(), api, newCollectors(collectorConfig{}), &out); code == 0 {
+		t.Errorf("runCheck() = 0 with a denied call:\n%s", out.String())
+	}
+	if !regexp.MustCompile(`GetManagedVolumes\s+denied\s+denied\s+denied`).Match(out.Bytes()) {
+		t.Errorf("GetManagedVolumes not reported as denied:\n%s", out.String())
+	}
+}
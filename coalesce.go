@@ -0,0 +1,174 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+var coalescedScrapes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace, Subsystem: "scrape", Name: "coalesced_total",
+	Help: "Collector runs answered from a concurrent or recent run instead of querying Rubrik",
+}, []string{"collector"})
+
+// maxFlightDuration bounds a collector run no scrape with a deadline waits for
+const maxFlightDuration = 10 * time.Minute
+
+// coalescer - Shares the result of a collector run between scrapes. Scrapes
+// arriving while a run is in flight wait for it, and a successful run is
+// reused for the freshness window.
+type coalescer struct {
+	freshness time.Duration
+
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight - One run of a collector. It runs detached from the scrape that
+// started it until the latest deadline of the scrapes waiting for it, a
+// scrape giving up doesn't fail the others.
+type flight struct {
+	done     chan struct{}
+	metrics  []prometheus.Metric
+	err      error
+	finished time.Time
+
+	// deadline is when timer cancels the run, extended by every scrape
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// newCoalescer ...
+func newCoalescer(freshness time.Duration) *coalescer {
+	return &coalescer{freshness: freshness, flights: make(map[string]*flight)}
+}
+
+// do - Run fn for key unless a run is in flight or fresh, and send its
+// metrics to ch. Returns early with the error of ctx when it is done first,
+// the run goes on for the other scrapes.
+func (c *coalescer) do(ctx context.Context, key string, ch chan<- prometheus.Metric, fn func(ctx context.Context, ch chan<- prometheus.Metric) error) error {
+	c.mu.Lock()
+	f, ok := c.flights[key]
+	if ok && f.usable(c.freshness) {
+		coalescedScrapes.WithLabelValues(key).Inc()
+	} else {
+		f = c.start(ctx, key, fn)
+	}
+	f.extend(ctx)
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for _, m := range f.metrics {
+		ch <- m
+	}
+	return f.err
+}
+
+// start - Run fn in a new flight for key. The run keeps the values of ctx,
+// like the trace span and the priority, but not its cancellation. c.mu must
+// be held.
+func (c *coalescer) start(ctx context.Context, key string, fn func(ctx context.Context, ch chan<- prometheus.Metric) error) *flight {
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	f := &flight{done: make(chan struct{}), timer: time.AfterFunc(maxFlightDuration, cancel)}
+	c.flights[key] = f
+
+	go func() {
+		defer cancel()
+
+		buf := make(chan prometheus.Metric)
+		collected := make(chan struct{})
+		go func() {
+			defer close(collected)
+			for m := range buf {
+				f.metrics = append(f.metrics, snapshot(m))
+			}
+		}()
+		err := fn(runCtx, buf)
+		close(buf)
+		<-collected
+		f.timer.Stop()
+
+		c.mu.Lock()
+		f.err = err
+		f.finished = time.Now()
+		// Failed runs are not reused by later scrapes
+		if err != nil && c.flights[key] == f {
+			delete(c.flights, key)
+		}
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+// extend - Let the flight run until the deadline of ctx, or
+// maxFlightDuration from now if it has none. c.mu must be held.
+func (f *flight) extend(ctx context.Context) {
+	if !f.finished.IsZero() {
+		return
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(maxFlightDuration)
+	}
+	if deadline.After(f.deadline) {
+		f.deadline = deadline
+		f.timer.Reset(time.Until(deadline))
+	}
+}
+
+// usable - Whether the flight is still running or finished recently enough,
+// c.mu must be held
+func (f *flight) usable(freshness time.Duration) bool {
+	if f.finished.IsZero() {
+		return true
+	}
+	return f.err == nil && time.Since(f.finished) < freshness
+}
+
+// frozenMetric - The value of a metric when it was collected
+type frozenMetric struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+}
+
+// snapshot - Freeze the value of m. The children of a vec like the node
+// histograms keep changing with later runs, a replayed run must not show
+// them.
+func snapshot(m prometheus.Metric) prometheus.Metric {
+	metric := &dto.Metric{}
+	if err := m.Write(metric); err != nil {
+		return prometheus.NewInvalidMetric(m.Desc(), err)
+	}
+	return frozenMetric{desc: m.Desc(), metric: metric}
+}
+
+// Desc ...
+func (m frozenMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+// Write ...
+func (m frozenMetric) Write(out *dto.Metric) error {
+	out.Reset()
+	proto.Merge(out, m.metric)
+	return nil
+}
@@ -0,0 +1,194 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// coalescerRun - A collector run counting how often it ran, sending one
+// gauge or failing with err
+type coalescerRun struct {
+	runs atomic.Int32
+	err  error
+	// wait holds the run back until closed, if set
+	wait chan struct{}
+}
+
+var coalescerDesc = prometheus.NewDesc("rubrik_test", "Test metric", nil, nil)
+
+// run ...
+func (r *coalescerRun) run(ctx context.Context, ch chan<- prometheus.Metric) error {
+	r.runs.Add(1)
+	if r.wait != nil {
+		<-r.wait
+	}
+	if r.err != nil {
+		return r.err
+	}
+	ch <- prometheus.MustNewConstMetric(coalescerDesc, prometheus.GaugeValue, 1)
+	return nil
+}
+
+// coalescedScrape - Run c.do for key and return the metrics it sent
+func coalescedScrape(c *coalescer, key string, fn func(context.Context, chan<- prometheus.Metric) error) ([]prometheus.Metric, error) {
+	ch := make(chan prometheus.Metric, 16)
+	err := c.do(context.Background(), key, ch, fn)
+	close(ch)
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics, err
+}
+
+func TestCoalescerConcurrentScrapes(t *testing.T) {
+	c := newCoalescer(0)
+	r := &coalescerRun{wait: make(chan struct{})}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if metrics, err := coalescedScrape(c, "concurrent", r.run); err != nil || len(metrics) != 1 {
+				t.Errorf("scrape: %d metrics, error %v", len(metrics), err)
+			}
+		}()
+	}
+	// Let every scrape join the run before it finishes
+	for testutil.ToFloat64(coalescedScrapes.WithLabelValues("concurrent")) < 7 {
+		time.Sleep(time.Millisecond)
+	}
+	close(r.wait)
+	wg.Wait()
+	if n := r.runs.Load(); n != 1 {
+		t.Errorf("%d runs for 8 concurrent scrapes, want 1", n)
+	}
+}
+
+func TestCoalescerFreshness(t *testing.T) {
+	c := newCoalescer(50 * time.Millisecond)
+	r := &coalescerRun{}
+
+	coalescedScrape(c, "fresh", r.run)
+	coalescedScrape(c, "fresh", r.run)
+	if n := r.runs.Load(); n != 1 {
+		t.Errorf("%d runs within the freshness window, want 1", n)
+	}
+	time.Sleep(60 * time.Millisecond)
+	coalescedScrape(c, "fresh", r.run)
+	if n := r.runs.Load(); n != 2 {
+		t.Errorf("%d runs after the freshness window, want 2", n)
+	}
+}
+
+func TestCoalescerFailedRunNotReused(t *testing.T) {
+	c := newCoalescer(time.Minute)
+	r := &coalescerRun{err: errors.New("rubrik unavailable")}
+
+	if _, err := coalescedScrape(c, "failed", r.run); err == nil {
+		t.Error("failed run returned no error")
+	}
+	r.err = nil
+	if metrics, err := coalescedScrape(c, "failed", r.run); err != nil || len(metrics) != 1 {
+		t.Errorf("scrape after a failed run: %d metrics, error %v", len(metrics), err)
+	}
+	if n := r.runs.Load(); n != 2 {
+		t.Errorf("%d runs, want the failed run to be repeated", n)
+	}
+}
+
+func TestCoalescerSnapshot(t *testing.T) {
+	c := newCoalescer(time.Minute)
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "rubrik_test_seconds", Help: "Test histogram"}, []string{"node"})
+	run := func(ctx context.Context, ch chan<- prometheus.Metric) error {
+		h.WithLabelValues("a").Observe(1)
+		h.Collect(ch)
+		return nil
+	}
+
+	coalescedScrape(c, "snapshot", run)
+	// A later observation must not change the run kept for the freshness
+	// window
+	h.WithLabelValues("a").Observe(2)
+	metrics, err := coalescedScrape(c, "snapshot", run)
+	if err != nil || len(metrics) != 1 {
+		t.Fatalf("replayed %d metrics, error %v", len(metrics), err)
+	}
+	var m dto.Metric
+	if err := metrics[0].Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.GetHistogram().GetSampleCount(); n != 1 {
+		t.Errorf("replayed histogram has %d samples, want the 1 of the run", n)
+	}
+}
+
+func TestCoalescerFirstScrapeCanceled(t *testing.T) {
+	// The second scrape reuses the run even if it only asks after it finished
+	c := newCoalescer(time.Minute)
+	started, release := make(chan struct{}), make(chan struct{})
+	run := func(ctx context.Context, ch chan<- prometheus.Metric) error {
+		close(started)
+		<-release
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ch <- prometheus.MustNewConstMetric(coalescerDesc, prometheus.GaugeValue, 1)
+		return nil
+	}
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error)
+	go func() {
+		firstErr <- c.do(first, "test", make(chan prometheus.Metric, 1), run)
+	}()
+	<-started
+
+	type result struct {
+		metrics int
+		err     error
+	}
+	second := make(chan result)
+	go func() {
+		ch := make(chan prometheus.Metric, 1)
+		err := c.do(context.Background(), "test", ch, func(context.Context, chan<- prometheus.Metric) error {
+			t.Error("the second scrape started a run of its own")
+			return nil
+		})
+		second <- result{len(ch), err}
+	}()
+
+	// The first scrape gives up, the run goes on for the second
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("first scrape: %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	select {
+	case r := <-second:
+		if r.err != nil || r.metrics != 1 {
+			t.Errorf("second scrape: %d metrics, error %v", r.metrics, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second scrape didn't return")
+	}
+}
@@ -0,0 +1,215 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/logging"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector - Exports metrics queried from Rubrik. Update is called once per
+// scrape, ctx ends when the scrape times out or the exporter shuts down.
+type Collector interface {
+	Describe(ch chan<- *prometheus.Desc)
+	Update(ctx context.Context, ch chan<- prometheus.Metric) error
+}
+
+// prioritized - Implemented by collectors whose API requests should go before
+// or after the others when the rate limiter holds requests back
+type prioritized interface {
+	Priority() rubrik.Priority
+}
+
+var (
+	scrapeDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
+		"Duration of a collector scrape",
+		[]string{"collector"}, nil,
+	)
+	scrapeSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_success"),
+		"Whether a collector succeeded",
+		[]string{"collector"}, nil,
+	)
+)
+
+// scrapeCollector - Binds a Collector to the context of a single scrape.
+// Concurrent scrapes share one run of the collector through the coalescer.
+type scrapeCollector struct {
+	ctx       context.Context
+	name      string
+	collector Collector
+	coalescer *coalescer
+}
+
+// Describe ...
+func (s scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	s.collector.Describe(ch)
+}
+
+// Collect ...
+func (s scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := s.ctx
+	if p, ok := s.collector.(prioritized); ok {
+		ctx = rubrik.WithPriority(ctx, p.Priority())
+	}
+
+	ctx = logging.With(ctx, "collector", s.name)
+	ctx, span := tracing.Start(ctx, "collect "+s.name, tracing.KindInternal, tracing.Attribute{Key: "rubrik.collector", Value: s.name})
+	start := time.Now()
+	err := s.coalescer.do(ctx, s.name, ch, func(ctx context.Context, ch chan<- prometheus.Metric) error {
+		return s.collector.Update(ctx, ch)
+	})
+	duration := time.Since(start)
+	span.RecordError(err)
+	span.Finish()
+	recordRun(s.name, duration, err)
+
+	success := 1.0
+	if err != nil {
+		slog.ErrorContext(ctx, "Collector failed", "duration", duration, "err", err)
+		success = 0
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), s.name)
+	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, s.name)
+}
+
+// collectorFlags - Define -collector.<name> and -no-collector.<name> for
+// every collector. All collectors are enabled unless switched off.
+func collectorFlags(fs *flag.FlagSet) map[string]*bool {
+	enabled := make(map[string]*bool, len(collectorHelp))
+	for name := range collectorHelp {
+		on := fs.Bool("collector."+name, true, "Enable the "+name+" collector: "+collectorHelp[name])
+		fs.BoolFunc("no-collector."+name, "Disable the "+name+" collector", func(value string) error {
+			off, err := strconv.ParseBool(value)
+			*on = !off
+			return err
+		})
+		enabled[name] = on
+	}
+	return enabled
+}
+
+// enabledCollectors - The collectors whose flag is set
+func enabledCollectors(collectors map[string]Collector, enabled map[string]*bool) map[string]Collector {
+	list := make(map[string]Collector, len(collectors))
+	for name, c := range collectors {
+		if *enabled[name] {
+			list[name] = c
+		}
+	}
+	return list
+}
+
+// selectCollectors - The collectors named by the collect[] parameters of a
+// scrape, all of them if there are none. Disabled or unknown names are an
+// error.
+func selectCollectors(collectors map[string]Collector, names []string) (map[string]Collector, error) {
+	if len(names) == 0 {
+		return collectors, nil
+	}
+	selected := make(map[string]Collector, len(names))
+	for _, name := range names {
+		c, ok := collectors[name]
+		if !ok {
+			return nil, fmt.Errorf("collector %q doesn't exist or is disabled", name)
+		}
+		selected[name] = c
+	}
+	return selected, nil
+}
+
+// scrapeContext - Derive the context of a scrape from the request. Prometheus
+// sends its scrape timeout, the deadline is set offset before it so there is
+// time left to answer with what has been collected.
+func scrapeContext(r *http.Request, offset time.Duration) (context.Context, context.CancelFunc) {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return context.WithCancel(r.Context())
+	}
+
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil {
+		slog.Warn("Invalid X-Prometheus-Scrape-Timeout-Seconds", "value", header, "err", err)
+		return context.WithCancel(r.Context())
+	}
+
+	timeout := time.Duration(seconds*float64(time.Second)) - offset
+	if timeout <= 0 {
+		slog.Warn("Scrape timeout is shorter than the offset, ignoring the offset", "timeout", header, "offset", offset)
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// scrapeClusters - The cluster list of one scrape, looked up by the first
+// collector that needs it and shared with the others
+type scrapeClusters struct {
+	once     sync.Once
+	clusters []rubrik.Cluster
+	err      error
+}
+
+type scrapeClustersKey struct{}
+
+// withScrapeClusters - Share one lookup of the clusters, and the queries
+// calls have in common, between the collectors run with ctx. A ctx that
+// already shares one is kept.
+func withScrapeClusters(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(scrapeClustersKey{}).(*scrapeClusters); ok {
+		return ctx
+	}
+	ctx = rubrik.WithScrapeCache(ctx)
+	return context.WithValue(ctx, scrapeClustersKey{}, &scrapeClusters{})
+}
+
+// getClusters - The clusters behind the API, looked up once per scrape for
+// a ctx from withScrapeClusters
+func getClusters(ctx context.Context) ([]rubrik.Cluster, error) {
+	s, ok := ctx.Value(scrapeClustersKey{}).(*scrapeClusters)
+	if !ok {
+		return rubrikAPI.GetClusters(ctx)
+	}
+	s.once.Do(func() {
+		s.clusters, s.err = rubrikAPI.GetClusters(ctx)
+	})
+	return s.clusters, s.err
+}
+
+// forEachCluster - Call fn for every cluster behind the API with a context
+// logging the cluster. A failing cluster doesn't stop the others, all errors
+// are returned together.
+func forEachCluster(ctx context.Context, fn func(ctx context.Context, api rubrik.Rubrik, cluster string) error) error {
+	clusters, err := getClusters(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, c := range clusters {
+		if err := fn(logging.With(ctx, "cluster", c.Name), rubrikAPI.ForCluster(c), c.Name); err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", c.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,178 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubriktest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata")
+
+// scrape - Run the collectors once and return the text exposition. The
+// collector durations change with every run and are left out.
+func scrape(t *testing.T, collectors map[string]Collector) []byte {
+	t.Helper()
+
+	ctx := withScrapeClusters(context.Background())
+	registry := prometheus.NewRegistry()
+	for name, c := range collectors {
+		registry.MustRegister(scrapeCollector{ctx: ctx, name: name, collector: c, coalescer: newCoalescer(0)})
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	for _, mf := range families {
+		if mf.GetName() == "rubrik_scrape_collector_duration_seconds" {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// compareGolden ...
+func compareGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("exposition differs from %s, run go test -update to accept:\n%s", path, diff(string(want), string(got)))
+	}
+}
+
+// diff - Lines only in want (-) or only in got (+)
+func diff(want, got string) string {
+	wantLines := make(map[string]bool)
+	for _, l := range strings.Split(want, "\n") {
+		wantLines[l] = true
+	}
+	gotLines := make(map[string]bool)
+	for _, l := range strings.Split(got, "\n") {
+		gotLines[l] = true
+	}
+
+	var b strings.Builder
+	for _, l := range strings.Split(want, "\n") {
+		if !gotLines[l] {
+			b.WriteString("- " + l + "\n")
+		}
+	}
+	for _, l := range strings.Split(got, "\n") {
+		if !wantLines[l] {
+			b.WriteString("+ " + l + "\n")
+		}
+	}
+	return b.String()
+}
+
+func TestCollectorsGraphQL(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+	compareGolden(t, "cdm_graphql", scrape(t, newCollectors(collectorConfig{})))
+}
+
+func TestCollectorsREST(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject("graphql", rubriktest.NotFound)
+
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+	compareGolden(t, "cdm_rest", scrape(t, newCollectors(collectorConfig{})))
+}
+
+func TestCollectorsSecurityCloud(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+
+	rubrikAPI = rubrik.NewRubrikSecurityCloud(context.Background(), srv.URL, rubriktest.ClientID, rubrik.StaticSecret(rubriktest.ClientSecret), rubrik.Options{})
+	compareGolden(t, "security_cloud", scrape(t, newCollectors(collectorConfig{})))
+	// The collectors share the cluster list of the scrape
+	if n := srv.Requests("graphql/RscClusters"); n != 1 {
+		t.Errorf("clusters listed %d times in one scrape, want once", n)
+	}
+	// Storage, runway and growth of a cluster come from one query
+	if n := srv.Requests("graphql/RscClusterStats"); n != 1 {
+		t.Errorf("cluster stats queried %d times in one scrape, want once", n)
+	}
+}
+
+// The names and types before schema version 2, for -compat.legacy-metric-names
+func TestCollectorsLegacyNames(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject("graphql", rubriktest.NotFound)
+
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+	compareGolden(t, "cdm_legacy", scrape(t, newCollectors(collectorConfig{schema: metricSchema{legacy: true}})))
+}
+
+func TestCollectorsPartialFailure(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject("graphql", rubriktest.NotFound)
+	srv.Inject("rest/hyperv_vm", rubriktest.ServerError)
+	srv.Inject("rest/managed_volume", rubriktest.Malformed)
+
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+	compareGolden(t, "cdm_partial_failure", scrape(t, newCollectors(collectorConfig{})))
+}
+
+func TestCollectorFlags(t *testing.T) {
+	fs := flag.NewFlagSet("rubrik-exporter", flag.ContinueOnError)
+	collectors := newCollectors(collectorConfig{})
+	enabled := collectorFlags(fs)
+	if err := fs.Parse([]string{"-no-collector.archive", "-collector.managed_volume=false"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := enabledCollectors(collectors, enabled)
+	if len(got) != 2 || got["rubrik"] == nil || got["vm"] == nil {
+		t.Errorf("enabled collectors %v, want rubrik and vm", got)
+	}
+
+	selected, err := selectCollectors(got, []string{"vm"})
+	if err != nil || len(selected) != 1 || selected["vm"] == nil {
+		t.Errorf("collect[]=vm selected %v, %v", selected, err)
+	}
+	if all, _ := selectCollectors(got, nil); len(all) != 2 {
+		t.Errorf("no collect[] selected %d collectors, want 2", len(all))
+	}
+	if _, err := selectCollectors(got, []string{"archive"}); err == nil {
+		t.Error("collect[]=archive selected a disabled collector")
+	}
+}
@@ -0,0 +1,286 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.yaml.in/yaml/v2"
+)
+
+// Targets of -labels.target
+const (
+	labelsOnSeries = "series"
+	labelsOnInfo   = "info"
+)
+
+// reservedLabels are set by the collectors and can't be added
+var reservedLabels = map[string]bool{
+	"cluster": true, "vmname": true, "vmid": true, "name": true, "id": true, "state": true,
+	"sla": true, "sla_id": true, "hypervisor": true, "folder": true,
+}
+
+// labelRule - Extra labels for the objects with an ID, or whose name matches
+type labelRule struct {
+	id     string
+	name   *regexp.Regexp
+	labels map[string]string
+}
+
+// enricher - Adds the labels of a mapping file and of vSphere tags to the
+// VM and managed volume metrics. A nil *enricher adds nothing.
+type enricher struct {
+	// names are the added label names, sorted
+	names []string
+	rules []labelRule
+	// tags maps vSphere tag categories to label names
+	tags map[string]string
+	// info puts the labels on the _info metrics instead of every series
+	info bool
+}
+
+// newEnricher - Labels from the mapping file, CSV or YAML by its extension,
+// and from the vSphere tags of the categories. Returns nil if neither is
+// given.
+func newEnricher(file string, categories []string, target string) (*enricher, error) {
+	if target != labelsOnSeries && target != labelsOnInfo {
+		return nil, fmt.Errorf("unknown label target %q, use %s or %s", target, labelsOnSeries, labelsOnInfo)
+	}
+	if file == "" && len(categories) == 0 {
+		return nil, nil
+	}
+
+	e := &enricher{info: target == labelsOnInfo, tags: make(map[string]string)}
+	if file != "" {
+		var err error
+		switch ext := strings.ToLower(filepath.Ext(file)); ext {
+		case ".csv":
+			e.rules, err = readLabelCSV(file)
+		case ".yml", ".yaml":
+			e.rules, err = readLabelYAML(file)
+		default:
+			err = fmt.Errorf("unknown file type %q, use .csv, .yml or .yaml", ext)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, r := range e.rules {
+		for name := range r.labels {
+			names[name] = true
+		}
+	}
+	for _, c := range categories {
+		name := tagLabelName(c)
+		e.tags[c] = name
+		names[name] = true
+	}
+	for name := range names {
+		if !validLabelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+		if reservedLabels[name] {
+			return nil, fmt.Errorf("label %q is set by the exporter", name)
+		}
+		e.names = append(e.names, name)
+	}
+	sort.Strings(e.names)
+	return e, nil
+}
+
+var (
+	validLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	notLabelChars  = regexp.MustCompile(`[^a-z0-9_]+`)
+)
+
+// tagLabelName - The label of a tag category, "Cost Center" is cost_center
+func tagLabelName(category string) string {
+	name := strings.Trim(notLabelChars.ReplaceAllString(strings.ToLower(category), "_"), "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "tag_" + name
+	}
+	return name
+}
+
+// newLabelRule - A rule matching id, or name as pattern like the -filter
+// flags
+func newLabelRule(id, name string, labels map[string]string) (labelRule, error) {
+	r := labelRule{id: id, labels: labels}
+	switch {
+	case id != "" && name != "":
+		return r, fmt.Errorf("rule with id %q and name %q, only one of them is allowed", id, name)
+	case id == "" && name == "":
+		return r, fmt.Errorf("rule without id or name")
+	case name != "":
+		re, err := compilePattern(name)
+		if err != nil {
+			return r, err
+		}
+		r.name = re
+	}
+	return r, nil
+}
+
+// readLabelCSV - A header with an id and a name column, the other columns
+// are label names. Each row has an id or a name, empty cells add no label.
+func readLabelCSV(file string) ([]labelRule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	idCol, nameCol := -1, -1
+	for i, h := range header {
+		switch strings.TrimSpace(h) {
+		case "id":
+			idCol = i
+		case "name":
+			nameCol = i
+		}
+	}
+	if idCol < 0 && nameCol < 0 {
+		return nil, fmt.Errorf("header has no id or name column")
+	}
+
+	var rules []labelRule
+	for n, row := range records[1:] {
+		var id, name string
+		labels := make(map[string]string)
+		for i, v := range row {
+			v = strings.TrimSpace(v)
+			switch {
+			case i == idCol:
+				id = v
+			case i == nameCol:
+				name = v
+			case v != "":
+				labels[strings.TrimSpace(header[i])] = v
+			}
+		}
+		rule, err := newLabelRule(id, name, labels)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n+2, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// readLabelYAML - A list of rules with an id or a name and their labels
+func readLabelYAML(file string) ([]labelRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entries []struct {
+		ID     string            `yaml:"id"`
+		Name   string            `yaml:"name"`
+		Labels map[string]string `yaml:"labels"`
+	}
+	if err := yaml.UnmarshalStrict(data, &entries); err != nil {
+		return nil, err
+	}
+
+	rules := make([]labelRule, 0, len(entries))
+	for n, entry := range entries {
+		rule, err := newLabelRule(entry.ID, entry.Name, entry.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %v", n+1, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// labelNames - The added label names, none for a nil enricher
+func (e *enricher) labelNames() []string {
+	if e == nil {
+		return nil
+	}
+	return e.names
+}
+
+// onSeries - Whether the labels go on every series
+func (e *enricher) onSeries() bool {
+	return e != nil && !e.info && len(e.names) > 0
+}
+
+// onInfo - Whether the labels go on the _info metrics
+func (e *enricher) onInfo() bool {
+	return e != nil && e.info && len(e.names) > 0
+}
+
+// wantsTags - Whether vSphere tags have to be read
+func (e *enricher) wantsTags() bool {
+	return e != nil && len(e.tags) > 0
+}
+
+// values - The label values of an object in the order of labelNames. Rules
+// for its ID come first, then name rules in file order, the first value of
+// a label wins. Tags fill the labels still empty.
+func (e *enricher) values(ids []string, name string, tags map[string]string) []string {
+	if e == nil {
+		return nil
+	}
+	labels := make(map[string]string, len(e.names))
+	set := func(from map[string]string) {
+		for k, v := range from {
+			if _, ok := labels[k]; !ok {
+				labels[k] = v
+			}
+		}
+	}
+	for _, r := range e.rules {
+		for _, id := range ids {
+			if r.id != "" && r.id == id {
+				set(r.labels)
+			}
+		}
+	}
+	for _, r := range e.rules {
+		if r.name != nil && r.name.MatchString(name) {
+			set(r.labels)
+		}
+	}
+	for category, tag := range tags {
+		if label, ok := e.tags[category]; ok {
+			if _, ok := labels[label]; !ok {
+				labels[label] = tag
+			}
+		}
+	}
+
+	values := make([]string, len(e.names))
+	for i, n := range e.names {
+		values[i] = labels[n]
+	}
+	return values
+}
@@ -0,0 +1,133 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubriktest"
+)
+
+const labelsCSV = `id,name,team,cost_center
+7b8a9c0d-1e2f-4a3b-9c4d-5e6f7a8b9c0d-vm-101,,frontend,
+,glob:web-*,web,4711
+,db-.*,dba,4712
+ManagedVolume:::0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d,,backup,4713
+`
+
+const labelsYAML = `
+- id: 7b8a9c0d-1e2f-4a3b-9c4d-5e6f7a8b9c0d-vm-101
+  labels: {team: frontend}
+- name: glob:web-*
+  labels: {team: web, cost_center: "4711"}
+- name: db-.*
+  labels: {team: dba, cost_center: "4712"}
+- id: ManagedVolume:::0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d
+  labels: {team: backup, cost_center: "4713"}
+`
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLabelFile(t *testing.T) {
+	for _, file := range []string{writeFile(t, "labels.csv", labelsCSV), writeFile(t, "labels.yml", labelsYAML)} {
+		e, err := newEnricher(file, []string{"Owner"}, labelsOnSeries)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if want := []string{"cost_center", "owner", "team"}; !slices.Equal(e.labelNames(), want) {
+			t.Errorf("%s: labels %v, want %v", file, e.labelNames(), want)
+		}
+
+		for _, tc := range []struct {
+			ids  []string
+			name string
+			tags map[string]string
+			want []string
+		}{
+			// The ID rule comes first, the name rule fills the rest
+			{[]string{"VirtualMachine:::7b8a9c0d-1e2f-4a3b-9c4d-5e6f7a8b9c0d-vm-101", "7b8a9c0d-1e2f-4a3b-9c4d-5e6f7a8b9c0d-vm-101"}, "web-01",
+				map[string]string{"Owner": "alice", "Team": "ignored"}, []string{"4711", "alice", "frontend"}},
+			{[]string{"vm-102"}, "db-01", nil, []string{"4712", "", "dba"}},
+			{[]string{"vm-103"}, "scratch-01", nil, []string{"", "", ""}},
+		} {
+			if got := e.values(tc.ids, tc.name, tc.tags); !slices.Equal(got, tc.want) {
+				t.Errorf("%s: values(%s) = %q, want %q", file, tc.name, got, tc.want)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		name, content string
+	}{
+		{"reserved.csv", "name,vmname\nweb-01,x\n"},
+		{"invalid.csv", "name,bad-label\nweb-01,x\n"},
+		{"both.yml", "- {id: a, name: b, labels: {team: x}}\n"},
+		{"neither.csv", "id,team\n,x\n"},
+		{"labels.txt", "id,team\n"},
+	} {
+		if _, err := newEnricher(writeFile(t, tc.name, tc.content), nil, labelsOnSeries); err == nil {
+			t.Errorf("%s accepted", tc.name)
+		}
+	}
+	if e, err := newEnricher("", nil, labelsOnInfo); e != nil || err != nil {
+		t.Errorf("newEnricher() without file and tags = %v, %v, want nil", e, err)
+	}
+	if tagLabelName("Cost Center") != "cost_center" || tagLabelName("1st") != "tag_1st" {
+		t.Error("tag categories not sanitized")
+	}
+}
+
+func TestLabelTargets(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+	file := writeFile(t, "labels.csv", labelsCSV)
+
+	for _, tc := range []struct {
+		target string
+		want   []string
+	}{
+		{labelsOnSeries, []string{
+			`rubrik_vm_protected{cluster="cdm-lab-01",cost_center="4711",owner="alice",team="frontend",vmid="VirtualMachine:::7b8a9c0d-1e2f-4a3b-9c4d-5e6f7a8b9c0d-vm-101"} 1`,
+			`rubrik_managed_volume_size_bytes{cluster="cdm-lab-01",cost_center="4713",id="ManagedVolume:::0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d",owner="",team="backup"}`,
+			`rubrik_vm_info{cluster="cdm-lab-01",folder="vcenter-01/DC1/Prod/Web",hypervisor="vmware",sla="Gold",`,
+		}},
+		{labelsOnInfo, []string{
+			`rubrik_vm_info{cluster="cdm-lab-01",cost_center="4712",folder="vcenter-01/DC1/Prod/DB",hypervisor="vmware",owner="bob",`,
+			`rubrik_managed_volume_info{cluster="cdm-lab-01",cost_center="4713",id="ManagedVolume:::0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d",`,
+			`rubrik_vm_protected{cluster="cdm-lab-01",vmid="VirtualMachine:::7b8a9c0d-1e2f-4a3b-9c4d-5e6f7a8b9c0d-vm-101"} 1`,
+		}},
+	} {
+		labels, err := newEnricher(file, []string{"Owner"}, tc.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(scrape(t, newCollectors(collectorConfig{labels: labels})))
+		for _, line := range tc.want {
+			if !strings.Contains(got, line) {
+				t.Errorf("-labels.target=%s: %s missing in\n%s", tc.target, line, got)
+			}
+		}
+	}
+}
@@ -11,49 +11,55 @@
 package main
 
 import (
-	"log"
+	"context"
 
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 // ArchiveLocation ...
 type ArchiveLocation struct {
-	ArchiveLocationStatus *prometheus.GaugeVec
+	ArchiveLocationStatus *prometheus.Desc
 }
 
 // Describe ...
 func (e ArchiveLocation) Describe(ch chan<- *prometheus.Desc) {
-	e.ArchiveLocationStatus.Describe(ch)
+	ch <- e.ArchiveLocationStatus
 }
 
-// Collect ...
-func (e *ArchiveLocation) Collect(ch chan<- prometheus.Metric) {
-	locations := rubrikAPI.GetArchiveLocations()
-	log.Printf("ArchiveLocation.Collect: found %d locations", len(locations))
-
-	for _, l := range locations {
+// Update - Export the archive locations of every cluster
+func (e *ArchiveLocation) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return forEachCluster(ctx, func(ctx context.Context, api rubrik.Rubrik, cluster string) error {
+		return e.collectCluster(ctx, ch, api, cluster)
+	})
+}
 
-		var g prometheus.Gauge
+// collectCluster ...
+func (e *ArchiveLocation) collectCluster(ctx context.Context, ch chan<- prometheus.Metric, api rubrik.Rubrik, cluster string) error {
+	locations, err := api.GetArchiveLocations(ctx)
+	if err != nil {
+		return err
+	}
 
-		g = e.ArchiveLocationStatus.WithLabelValues(l.Name, l.Bucket, l.IPAddress)
+	for _, l := range locations {
+		active := 0.0
 		if l.IsActive {
-			g.Set(1)
-		} else {
-			g.Set(0)
+			active = 1
 		}
-
-		g.Collect(ch)
+		ch <- prometheus.MustNewConstMetric(e.ArchiveLocationStatus, prometheus.GaugeValue, active, cluster, l.Name, l.Bucket, l.IPAddress)
 	}
 
+	return nil
 }
 
 // NewArchiveLocation ...
 func NewArchiveLocation() *ArchiveLocation {
 	return &ArchiveLocation{
-		ArchiveLocationStatus: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "archive_location_status",
-			Help: "Archive Loction Status - 1: Active, 0: Inactive",
-		}, []string{"name", "bucket", "target"}),
+		ArchiveLocationStatus: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "archive_location_status"),
+			"Whether the archive location is active, 1 active and 0 inactive",
+			[]string{"cluster", "name", "bucket", "target"}, nil,
+		),
 	}
 
 }
@@ -11,59 +11,99 @@
 package main
 
 import (
+	"context"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 // ArchiveLocation ...
 type ManagedVolume struct {
-	SnapshotCount *prometheus.GaugeVec
-	UsedSize      *prometheus.GaugeVec
-	VolumeSize    *prometheus.GaugeVec
+	SnapshotCount *prometheus.Desc
+	UsedSize      *prometheus.Desc
+	VolumeSize    *prometheus.Desc
+
+	filter objectFilter
+	labels *enricher
+	// info is rubrik_managed_volume_info, the value metrics only have the
+	// volume ID
+	info *prometheus.Desc
 }
 
 // Describe ...
 func (e ManagedVolume) Describe(ch chan<- *prometheus.Desc) {
-	e.SnapshotCount.Describe(ch)
-	e.UsedSize.Describe(ch)
-	e.VolumeSize.Describe(ch)
+	ch <- e.SnapshotCount
+	ch <- e.UsedSize
+	ch <- e.VolumeSize
+	ch <- e.info
 }
 
-// Collect ...
-func (e *ManagedVolume) Collect(ch chan<- prometheus.Metric) {
+// Update - Export the managed volumes of every cluster
+func (e *ManagedVolume) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return forEachCluster(ctx, func(ctx context.Context, api rubrik.Rubrik, cluster string) error {
+		return e.collectCluster(ctx, ch, api, cluster)
+	})
+}
 
-	volumes := rubrikAPI.GetManagedVolumes()
+// collectCluster ...
+func (e *ManagedVolume) collectCluster(ctx context.Context, ch chan<- prometheus.Metric, api rubrik.Rubrik, cluster string) error {
+	volumes, err := api.GetManagedVolumes(ctx)
+	if err != nil {
+		return err
+	}
 	for _, l := range volumes {
+		if !e.filter.matchManagedVolume(l) {
+			continue
+		}
 
-		var g prometheus.Gauge
+		labels := []string{cluster, l.ID}
+		info := []string{cluster, l.ID, l.Name, l.State, l.EffectiveSLADomainName, l.EffectiveSLADomainID}
+		extra := e.labels.values([]string{l.ID}, l.Name, nil)
+		if e.labels.onSeries() {
+			labels = append(labels, extra...)
+		} else if e.labels.onInfo() {
+			info = append(info, extra...)
+		}
+		ch <- prometheus.MustNewConstMetric(e.info, prometheus.GaugeValue, 1, info...)
 
-		g = e.SnapshotCount.WithLabelValues(l.Name, l.ID, l.State)
-		g.Set(float64(l.SnapshotCount))
-		g.Collect(ch)
-		g = e.VolumeSize.WithLabelValues(l.Name, l.ID, l.State)
-		g.Set(l.VolumeSize)
-		g.Collect(ch)
-		g = e.UsedSize.WithLabelValues(l.Name, l.ID, l.State)
-		g.Set(l.UsedSize)
-		g.Collect(ch)
+		ch <- prometheus.MustNewConstMetric(e.SnapshotCount, prometheus.GaugeValue, float64(l.SnapshotCount), labels...)
+		ch <- prometheus.MustNewConstMetric(e.VolumeSize, prometheus.GaugeValue, l.VolumeSize, labels...)
+		ch <- prometheus.MustNewConstMetric(e.UsedSize, prometheus.GaugeValue, l.UsedSize, labels...)
 	}
 
+	return nil
 }
 
-// NewAManagedVolume ...
-func NewManagedVolume() *ManagedVolume {
+// NewAManagedVolume - Exports the volumes matching filter with the labels
+// of labels on the value metrics or rubrik_managed_volume_info, named by
+// schema
+func NewManagedVolume(schema metricSchema, filter objectFilter, labels *enricher) *ManagedVolume {
+	names := []string{"cluster", "id"}
+	info := []string{"cluster", "id", "name", "state", "sla", "sla_id"}
+	if labels.onSeries() {
+		names = append(names, labels.labelNames()...)
+	} else if labels.onInfo() {
+		info = append(info, labels.labelNames()...)
+	}
 	return &ManagedVolume{
-		SnapshotCount: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "managed_volume_snapshot_count",
-			Help: "Snapshot Count on given Volume",
-		}, []string{"name", "id", "state"}),
-		UsedSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "managed_volume_used_size_bytes",
-			Help: "Used size on Volume in bytes",
-		}, []string{"name", "id", "state"}),
-		VolumeSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "managed_volume_size_bytes",
-			Help: "Available size on volume in bytes",
-		}, []string{"name", "id", "state"}),
+		filter: filter,
+		labels: labels,
+		info: prometheus.NewDesc(prometheus.BuildFQName(namespace, "managed_volume", "info"),
+			"Name, state and SLA domain of a managed volume, join on id", info, nil),
+		SnapshotCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("managed_volume_snapshots", "managed_volume_snapshot_count")),
+			"Snapshots of the managed volume",
+			names, nil,
+		),
+		UsedSize: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "managed_volume_used_size_bytes"),
+			"Used size of the managed volume in bytes",
+			names, nil,
+		),
+		VolumeSize: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "managed_volume_size_bytes"),
+			"Size of the managed volume in bytes",
+			names, nil,
+		),
 	}
 
 }
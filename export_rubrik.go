@@ -11,105 +11,145 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 // RubrikStats ...
 type RubrikStats struct {
-	StreamCount          *prometheus.GaugeVec
-	AverageStorageGrowth *prometheus.GaugeVec
-	RunawayRemaining     *prometheus.GaugeVec
-
-	SucceededTask *prometheus.GaugeVec
-	FailedTask    *prometheus.GaugeVec
-	CancledTask   *prometheus.GaugeVec
-
-	NodeCount              *prometheus.GaugeVec
-	NodeNetworkReceived    *prometheus.GaugeVec
-	NodeNetworkTransmitted *prometheus.GaugeVec
-	NodeIOPRead            *prometheus.GaugeVec
-	NodeIOPWrite           *prometheus.GaugeVec
-	NodeThroughputRead     *prometheus.GaugeVec
-	NodeThroughputWrite    *prometheus.GaugeVec
-
-	SystemPhysicalIngest *prometheus.GaugeVec
-
-	SystemStorageSize          *prometheus.GaugeVec
-	SystemStorageUsed          *prometheus.GaugeVec
-	SystemStorageAvailable     *prometheus.GaugeVec
-	SystemStorageSnapshot      *prometheus.GaugeVec
-	SystemStorageLiveMount     *prometheus.GaugeVec
-	SystemStorageMiscellaneous *prometheus.GaugeVec
-
-	ArchiveStorageBandwith        *prometheus.GaugeVec
-	ArchiveStorageArchivedVM      *prometheus.GaugeVec
-	ArchiveStorageArchivedFileSet *prometheus.GaugeVec
-	ArchiveStorageDataDownloaded  *prometheus.GaugeVec
-	ArchiveStorageDataArchived    *prometheus.GaugeVec
+	StreamCount          *prometheus.Desc
+	AverageStorageGrowth *prometheus.Desc
+	RunawayRemaining     *prometheus.Desc
+
+	SucceededTask *prometheus.Desc
+	FailedTask    *prometheus.Desc
+	CancledTask   *prometheus.Desc
+
+	NodeCount *prometheus.Desc
+	// Histograms of the points of the node stats, by op read or write
+	NodeIOPS       *prometheus.HistogramVec
+	NodeThroughput *prometheus.HistogramVec
+	NodeStatsTime  *prometheus.Desc
+	nodeSeries     []nodeSeries
+	nodeStatsSeen  *nodeStatsSeen
+
+	SystemPhysicalIngest *prometheus.Desc
+
+	SystemStorageSize          *prometheus.Desc
+	SystemStorageUsed          *prometheus.Desc
+	SystemStorageAvailable     *prometheus.Desc
+	SystemStorageSnapshot      *prometheus.Desc
+	SystemStorageLiveMount     *prometheus.Desc
+	SystemStorageMiscellaneous *prometheus.Desc
+
+	ArchiveStorageBandwith        *prometheus.Desc
+	ArchiveStorageBandwithMax     *prometheus.Desc
+	ArchiveStorageBandwithAvg     *prometheus.Desc
+	ArchiveStorageArchivedVM      *prometheus.Desc
+	ArchiveStorageArchivedFileSet *prometheus.Desc
+	// Cumulative since the location was added, counters unless legacy
+	ArchiveStorageDataDownloaded *prometheus.Desc
+	ArchiveStorageDataArchived   *prometheus.Desc
+
+	schema metricSchema
 }
 
 // Describe ...
 func (e *RubrikStats) Describe(ch chan<- *prometheus.Desc) {
-	e.StreamCount.Describe(ch)
-	e.AverageStorageGrowth.Describe(ch)
-	e.RunawayRemaining.Describe(ch)
-
-	e.SucceededTask.Describe(ch)
-	e.FailedTask.Describe(ch)
-	e.CancledTask.Describe(ch)
-
-	e.NodeCount.Describe(ch)
-	e.NodeNetworkReceived.Describe(ch)
-	e.NodeNetworkTransmitted.Describe(ch)
-	e.NodeIOPRead.Describe(ch)
-	e.NodeIOPWrite.Describe(ch)
-	e.NodeThroughputRead.Describe(ch)
-	e.NodeThroughputWrite.Describe(ch)
-
-	e.SystemPhysicalIngest.Describe(ch)
-
-	e.SystemStorageSize.Describe(ch)
-	e.SystemStorageUsed.Describe(ch)
-	e.SystemStorageAvailable.Describe(ch)
-	e.SystemStorageSnapshot.Describe(ch)
-	e.SystemStorageLiveMount.Describe(ch)
-	e.SystemStorageMiscellaneous.Describe(ch)
-
-	e.ArchiveStorageBandwith.Describe(ch)
-	e.ArchiveStorageArchivedFileSet.Describe(ch)
-	e.ArchiveStorageArchivedVM.Describe(ch)
-	e.ArchiveStorageDataArchived.Describe(ch)
-	e.ArchiveStorageDataDownloaded.Describe(ch)
+	ch <- e.StreamCount
+	ch <- e.AverageStorageGrowth
+	ch <- e.RunawayRemaining
+
+	ch <- e.SucceededTask
+	ch <- e.FailedTask
+	ch <- e.CancledTask
+
+	ch <- e.NodeCount
+	for _, s := range e.nodeSeries {
+		ch <- s.latest
+		ch <- s.max
+		ch <- s.avg
+	}
+	e.NodeIOPS.Describe(ch)
+	e.NodeThroughput.Describe(ch)
+	ch <- e.NodeStatsTime
+
+	ch <- e.SystemPhysicalIngest
+
+	ch <- e.SystemStorageSize
+	ch <- e.SystemStorageUsed
+	ch <- e.SystemStorageAvailable
+	ch <- e.SystemStorageSnapshot
+	ch <- e.SystemStorageLiveMount
+	ch <- e.SystemStorageMiscellaneous
+
+	ch <- e.ArchiveStorageBandwith
+	ch <- e.ArchiveStorageBandwithMax
+	ch <- e.ArchiveStorageBandwithAvg
+	ch <- e.ArchiveStorageArchivedFileSet
+	ch <- e.ArchiveStorageArchivedVM
+	ch <- e.ArchiveStorageDataArchived
+	ch <- e.ArchiveStorageDataDownloaded
+}
+
+// Update - Export the storage, node and report stats of every cluster
+func (e *RubrikStats) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return forEachCluster(ctx, func(ctx context.Context, api rubrik.Rubrik, cluster string) error {
+		return e.collectCluster(ctx, ch, api, cluster)
+	})
 }
 
-// Collect ...
-func (e *RubrikStats) Collect(ch chan<- prometheus.Metric) {
-	var g prometheus.Gauge
-
-	g = e.StreamCount.WithLabelValues()
-	g.Set(float64(rubrikAPI.GetStreamCount()))
-	g.Collect(ch)
-	g = e.RunawayRemaining.WithLabelValues()
-	g.Set(float64(rubrikAPI.GetRunawayRemaining()))
-	g.Collect(ch)
-	g = e.AverageStorageGrowth.WithLabelValues()
-	g.Set(float64(rubrikAPI.GetAverageStorageGrowthPerDay()))
-	g.Collect(ch)
-
-	taskStat := rubrikAPI.GetTaskDetails()
-
-	g = e.SucceededTask.WithLabelValues()
-	g.Set(taskStat["succeeded"])
-	g.Collect(ch)
-	g = e.FailedTask.WithLabelValues()
-	g.Set(taskStat["failed"])
-	g.Collect(ch)
-	g = e.CancledTask.WithLabelValues()
-	g.Set(taskStat["cancled"])
-	g.Collect(ch)
-
-	nodes := rubrikAPI.GetNodes()
+// Priority - Cluster health goes before the per object collectors
+func (e *RubrikStats) Priority() rubrik.Priority {
+	return rubrik.PriorityHigh
+}
+
+// collectCluster - Export the stats of one cluster. A failing API call only
+// drops the metrics depending on it.
+func (e *RubrikStats) collectCluster(ctx context.Context, ch chan<- prometheus.Metric, api rubrik.Rubrik, cluster string) error {
+	var errs []error
+
+	// Streams and canned reports are only available from the CDM API
+	if !api.IsSecurityCloud() {
+		if streams, err := api.GetStreamCount(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stream count: %w", err))
+		} else {
+			ch <- prometheus.MustNewConstMetric(e.StreamCount, prometheus.GaugeValue, float64(streams), cluster)
+		}
+
+		if taskStat, err := api.GetTaskDetails(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("task details: %w", err))
+		} else {
+			ch <- prometheus.MustNewConstMetric(e.SucceededTask, prometheus.GaugeValue, taskStat["succeeded"], cluster)
+			ch <- prometheus.MustNewConstMetric(e.FailedTask, prometheus.GaugeValue, taskStat["failed"], cluster)
+			ch <- prometheus.MustNewConstMetric(e.CancledTask, prometheus.GaugeValue, taskStat["canceled"], cluster)
+		}
+	}
+
+	if days, err := api.GetRunawayRemaining(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("runway remaining: %w", err))
+	} else {
+		runway := float64(days) * 24 * 60 * 60
+		if e.schema.legacy {
+			runway = float64(days)
+		}
+		ch <- prometheus.MustNewConstMetric(e.RunawayRemaining, prometheus.GaugeValue, runway, cluster)
+	}
+	if growth, err := api.GetAverageStorageGrowthPerDay(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("average storage growth: %w", err))
+	} else {
+		ch <- prometheus.MustNewConstMetric(e.AverageStorageGrowth, prometheus.GaugeValue, float64(growth), cluster)
+	}
+
+	nodes, err := api.GetNodes(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("nodes: %w", err))
+	}
 	{
 		_nodes := make(map[string]int)
 		for _, n := range nodes {
@@ -119,73 +159,45 @@ func (e *RubrikStats) Collect(ch chan<- prometheus.Metric) {
 			_nodes[n.BrikID]++
 		}
 		for bID, c := range _nodes {
-			g := e.NodeCount.WithLabelValues(bID)
-			g.Set(float64(c))
-			g.Collect(ch)
+			ch <- prometheus.MustNewConstMetric(e.NodeCount, prometheus.GaugeValue, float64(c), cluster, bID)
 		}
 	}
 
 	for _, v := range nodes {
-		nodeStat := rubrikAPI.GetNodeStats(v.ID)
-
-		if len(nodeStat.NetworkStat.BytesReceived) > 0 {
-			g = e.NodeNetworkReceived.WithLabelValues(v.ID)
-			g.Set(float64(nodeStat.NetworkStat.BytesReceived[0].Stat))
-			g.Collect(ch)
-		}
-		if len(nodeStat.NetworkStat.BytesTransmitted) > 0 {
-			g = e.NodeNetworkTransmitted.WithLabelValues(v.ID)
-			g.Set(float64(nodeStat.NetworkStat.BytesTransmitted[0].Stat))
-			g.Collect(ch)
-		}
-
-		if len(nodeStat.Iops.ReadsPerSecond) > 0 {
-			g = e.NodeIOPRead.WithLabelValues(v.ID)
-			g.Set(float64(nodeStat.Iops.ReadsPerSecond[0].Stat))
-			g.Collect(ch)
-		}
-		if len(nodeStat.Iops.WritesPerSecond) > 0 {
-			g = e.NodeIOPWrite.WithLabelValues(v.ID)
-			g.Set(float64(nodeStat.Iops.WritesPerSecond[0].Stat))
-			g.Collect(ch)
+		nodeStat, err := api.GetNodeStats(ctx, v.ID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %s stats: %w", v.ID, err))
+			continue
 		}
 
-		if len(nodeStat.IOThroughput.ReadBytePerSecond) > 0 {
-			g = e.NodeThroughputRead.WithLabelValues(v.ID)
-			g.Set(float64(nodeStat.IOThroughput.ReadBytePerSecond[0].Stat))
-			g.Collect(ch)
-		}
-		if len(nodeStat.IOThroughput.WriteBytePerSecond) > 0 {
-			g = e.NodeThroughputWrite.WithLabelValues(v.ID)
-			g.Set(float64(nodeStat.IOThroughput.WriteBytePerSecond[0].Stat))
-			g.Collect(ch)
-		}
+		e.collectNodeStats(ch, cluster, v.ID, nodeStat)
+	}
 
+	if systemStorage, err := api.GetSystemStorage(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("system storage: %w", err))
+	} else {
+		ch <- prometheus.MustNewConstMetric(e.SystemStorageAvailable, prometheus.GaugeValue, float64(systemStorage.Available), cluster)
+		ch <- prometheus.MustNewConstMetric(e.SystemStorageLiveMount, prometheus.GaugeValue, float64(systemStorage.LiveMount), cluster)
+		ch <- prometheus.MustNewConstMetric(e.SystemStorageMiscellaneous, prometheus.GaugeValue, float64(systemStorage.Miscellaneous), cluster)
+		ch <- prometheus.MustNewConstMetric(e.SystemStorageSnapshot, prometheus.GaugeValue, float64(systemStorage.Snapshot), cluster)
+		ch <- prometheus.MustNewConstMetric(e.SystemStorageSize, prometheus.GaugeValue, float64(systemStorage.Total), cluster)
+		ch <- prometheus.MustNewConstMetric(e.SystemStorageUsed, prometheus.GaugeValue, float64(systemStorage.Used), cluster)
 	}
 
-	systemStorage := rubrikAPI.GetSystemStorage()
-
-	g = e.SystemStorageAvailable.WithLabelValues()
-	g.Set(float64(systemStorage.Available))
-	g.Collect(ch)
-	g = e.SystemStorageLiveMount.WithLabelValues()
-	g.Set(float64(systemStorage.LiveMount))
-	g.Collect(ch)
-	g = e.SystemStorageMiscellaneous.WithLabelValues()
-	g.Set(float64(systemStorage.Miscellaneous))
-	g.Collect(ch)
-	g = e.SystemStorageSnapshot.WithLabelValues()
-	g.Set(float64(systemStorage.Snapshot))
-	g.Collect(ch)
-	g = e.SystemStorageSize.WithLabelValues()
-	g.Set(float64(systemStorage.Total))
-	g.Collect(ch)
-	g = e.SystemStorageUsed.WithLabelValues()
-	g.Set(float64(systemStorage.Used))
-	g.Collect(ch)
-
-	locations := rubrikAPI.GetArchiveLocations()
-	usages := rubrikAPI.GetDataLocationUsage()
+	locations, err := api.GetArchiveLocations(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("archive locations: %w", err))
+	}
+	// Usage per location is only available from the CDM API
+	var usages []rubrik.DataLocationUsage
+	skipUsage := api.IsSecurityCloud()
+	if len(locations) > 0 && !api.IsSecurityCloud() {
+		usages, err = api.GetDataLocationUsage(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("data location usage: %w", err))
+			skipUsage = true
+		}
+	}
 	for _, l := range locations {
 		var usage rubrik.DataLocationUsage
 		for _, u := range usages {
@@ -195,161 +207,169 @@ func (e *RubrikStats) Collect(ch chan<- prometheus.Metric) {
 			}
 		}
 
-		bandwidthData := rubrikAPI.GetArchivalBandwith(l.ID, "-10min")
-		if len(bandwidthData) > 0 {
-			g = e.ArchiveStorageBandwith.WithLabelValues(l.Name, l.IPAddress)
-			val := bandwidthData[0].Stat
-			g.Set(float64(val))
-			g.Collect(ch)
+		bandwidthData, err := api.GetArchivalBandwith(ctx, l.ID, "-10min")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("archival bandwidth of %s: %w", l.Name, err))
+		} else if len(bandwidthData) > 0 {
+			w := summarizeWindow(bandwidthData)
+			ch <- prometheus.MustNewConstMetric(e.ArchiveStorageBandwith, prometheus.GaugeValue, w.latest, cluster, l.Name, l.IPAddress)
+			ch <- prometheus.MustNewConstMetric(e.ArchiveStorageBandwithMax, prometheus.GaugeValue, w.max, cluster, l.Name, l.IPAddress)
+			ch <- prometheus.MustNewConstMetric(e.ArchiveStorageBandwithAvg, prometheus.GaugeValue, w.avg, cluster, l.Name, l.IPAddress)
+		}
+
+		if skipUsage {
+			continue
 		}
 
-		g = e.ArchiveStorageDataArchived.WithLabelValues(l.Name, l.IPAddress)
-		g.Set(float64(usage.DataArchived))
-		g.Collect(ch)
-		g = e.ArchiveStorageDataDownloaded.WithLabelValues(l.Name, l.IPAddress)
-		g.Set(float64(usage.DataDownloaded))
-		g.Collect(ch)
-
-		g = e.ArchiveStorageArchivedVM.WithLabelValues(l.Name, l.IPAddress, "vmware")
-		g.Set(float64(usage.NumVMsArchived))
-		g.Collect(ch)
-		g = e.ArchiveStorageArchivedVM.WithLabelValues(l.Name, l.IPAddress, "nutanix")
-		g.Set(float64(usage.NumNutanixVmsArchived))
-		g.Collect(ch)
-		g = e.ArchiveStorageArchivedVM.WithLabelValues(l.Name, l.IPAddress, "hyperv")
-		g.Set(float64(usage.NumHypervVmsArchived))
-		g.Collect(ch)
-
-		g = e.ArchiveStorageArchivedFileSet.WithLabelValues(l.Name, l.IPAddress, "linux")
-		g.Set(float64(usage.NumLinuxFilesetsArchived))
-		g.Collect(ch)
-		g = e.ArchiveStorageArchivedFileSet.WithLabelValues(l.Name, l.IPAddress, "windows")
-		g.Set(float64(usage.NumWindowsFilesetsArchived))
-		g.Collect(ch)
-		g = e.ArchiveStorageArchivedFileSet.WithLabelValues(l.Name, l.IPAddress, "share")
-		g.Set(float64(usage.NumShareFilesetsArchived))
-		g.Collect(ch)
-		g = e.ArchiveStorageArchivedFileSet.WithLabelValues(l.Name, l.IPAddress, "fileset")
-		g.Set(float64(usage.NumFilesetsArchived))
-		g.Collect(ch)
+		ch <- prometheus.MustNewConstMetric(e.ArchiveStorageDataArchived, e.schema.counter(), float64(usage.DataArchived), cluster, l.Name, l.IPAddress)
+		ch <- prometheus.MustNewConstMetric(e.ArchiveStorageDataDownloaded, e.schema.counter(), float64(usage.DataDownloaded), cluster, l.Name, l.IPAddress)
+
+		ch <- prometheus.MustNewConstMetric(e.ArchiveStorageArchivedVM, prometheus.GaugeValue, float64(usage.NumVMsArchived), cluster, l.Name, l.IPAddress, "vmware")
+		ch <- prometheus.MustNewConstMetric(e.ArchiveStorageArchivedVM, prometheus.GaugeValue, float64(usage.NumNutanixVmsArchived), cluster, l.Name, l.IPAddress, "nutanix")
+		ch <- prometheus.MustNewConstMetric(e.ArchiveStorageArchivedVM, prometheus.GaugeValue, float64(usage.NumHypervVmsArchived), cluster, l.Name, l.IPAddress, "hyperv")
+
+		ch <- prometheus.MustNewConstMetric(e.ArchiveStorageArchivedFileSet, prometheus.GaugeValue, float64(usage.NumLinuxFilesetsArchived), cluster, l.Name, l.IPAddress, "linux")
+		ch <- prometheus.MustNewConstMetric(e.ArchiveStorageArchivedFileSet, prometheus.GaugeValue, float64(usage.NumWindowsFilesetsArchived), cluster, l.Name, l.IPAddress, "windows")
+		ch <- prometheus.MustNewConstMetric(e.ArchiveStorageArchivedFileSet, prometheus.GaugeValue, float64(usage.NumShareFilesetsArchived), cluster, l.Name, l.IPAddress, "share")
+		ch <- prometheus.MustNewConstMetric(e.ArchiveStorageArchivedFileSet, prometheus.GaugeValue, float64(usage.NumFilesetsArchived), cluster, l.Name, l.IPAddress, "fileset")
 	}
 
-	ingest := rubrikAPI.GetPhysicalIngest()
-	if len(ingest) > 0 {
-		g = e.SystemPhysicalIngest.WithLabelValues()
-		g.Set(float64(ingest[0].Stat))
-		g.Collect(ch)
+	if ingest, err := api.GetPhysicalIngest(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("physical ingest: %w", err))
+	} else if len(ingest) > 0 {
+		ch <- prometheus.MustNewConstMetric(e.SystemPhysicalIngest, prometheus.GaugeValue, summarizeWindow(ingest).latest, cluster)
 	}
+
+	return errors.Join(errs...)
 }
 
-// NewRubrikStatsExport ...
-func NewRubrikStatsExport() *RubrikStats {
-	return &RubrikStats{
-		StreamCount: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "count_streams",
-			Help: "Count Rubrik Backup Streams",
-		}, []string{}),
-		AverageStorageGrowth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "stat_average_storage_growth_per_day",
-			Help: "Get average storage growth per day in bytes",
-		}, []string{}),
-		RunawayRemaining: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "stat_runaway_remaining",
-			Help: "Get the number of days remaining before the system fills up",
-		}, []string{}),
-
-		SucceededTask: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "report_task_succeded",
-			Help: "...",
-		}, []string{}),
-		FailedTask: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "report_task_failed",
-			Help: "...",
-		}, []string{}),
-		CancledTask: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "report_task_cancled",
-			Help: "...",
-		}, []string{}),
-
-		NodeCount: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "count_nodes",
-			Help: "Count Rubrik Nodes in a Brick",
-		}, []string{"brik"}),
-		NodeNetworkReceived: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "node_network_received",
-			Help: "Node Network Byte received",
-		}, []string{"node"}),
-		NodeNetworkTransmitted: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "node_network_transmitted",
-			Help: "Node Network Byte transmitted",
-		}, []string{"node"}),
-
-		NodeIOPRead: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "node_io_read",
-			Help: "Node Read IO per second",
-		}, []string{"node"}),
-		NodeIOPWrite: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "node_io_write",
-			Help: "Node Write IO per second",
-		}, []string{"node"}),
-		NodeThroughputRead: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "node_throughput_read",
-			Help: "Node Read Throughput per second",
-		}, []string{"node"}),
-		NodeThroughputWrite: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "node_throughput_write",
-			Help: "Node Write Throughput per second",
-		}, []string{"node"}),
-
-		SystemPhysicalIngest: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "system_physical_ingest_bytes",
-			Help: "...",
-		}, []string{}),
-
-		SystemStorageAvailable: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "system_storage_available",
-			Help: "Available Storage Bytes",
-		}, []string{}),
-		SystemStorageLiveMount: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "system_storage_live_mount",
-			Help: "...",
-		}, []string{}),
-		SystemStorageMiscellaneous: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "system_storage_miscellaneous",
-			Help: "...",
-		}, []string{}),
-		SystemStorageSnapshot: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "system_storage_snapshot",
-			Help: "storage bytes used by snapshots",
-		}, []string{}),
-		SystemStorageSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "system_storage_size",
-			Help: "total available bytes",
-		}, []string{}),
-		SystemStorageUsed: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "system_storage_used",
-			Help: "used bytes on storage",
-		}, []string{}),
-
-		ArchiveStorageBandwith: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "archive_storage_bandwidth",
-			Help: "...",
-		}, []string{"name", "target"}),
-		ArchiveStorageArchivedFileSet: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "archive_storage_archived_fileset",
-			Help: "...",
-		}, []string{"name", "target", "type"}),
-		ArchiveStorageArchivedVM: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "archive_storage_archived_vm",
-			Help: "...",
-		}, []string{"name", "target", "type"}),
-		ArchiveStorageDataArchived: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "archive_storage_data_archived",
-			Help: "...",
-		}, []string{"name", "target"}),
-		ArchiveStorageDataDownloaded: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "archive_storage_data_downloaded",
-			Help: "...",
-		}, []string{"name", "target"}),
+// NewRubrikStatsExport - The cluster metrics, named by schema
+func NewRubrikStatsExport(schema metricSchema) *RubrikStats {
+	e := &RubrikStats{
+		schema: schema,
+		StreamCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "count_streams"),
+			"Backup and restore streams running on the cluster",
+			[]string{"cluster"}, nil,
+		),
+		AverageStorageGrowth: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("stat_average_storage_growth_bytes_per_day", "stat_average_storage_growth_per_day")),
+			"Average growth of the used storage per day in bytes",
+			[]string{"cluster"}, nil,
+		),
+		RunawayRemaining: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("stat_runway_remaining_seconds", "stat_runaway_remaining")),
+			schema.name("Estimated time until the storage of the cluster is full", "Estimated days until the storage of the cluster is full"),
+			[]string{"cluster"}, nil,
+		),
+
+		SucceededTask: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("report_tasks_succeeded", "report_task_succeded")),
+			"Succeeded protection tasks in the Protection Tasks Details report",
+			[]string{"cluster"}, nil,
+		),
+		FailedTask: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("report_tasks_failed", "report_task_failed")),
+			"Failed protection tasks in the Protection Tasks Details report",
+			[]string{"cluster"}, nil,
+		),
+		CancledTask: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("report_tasks_canceled", "report_task_cancled")),
+			"Canceled protection tasks in the Protection Tasks Details report",
+			[]string{"cluster"}, nil,
+		),
+
+		NodeCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "count_nodes"),
+			"Nodes in a brik",
+			[]string{"cluster", "brik"}, nil,
+		),
+		NodeIOPS: newNodeHistogram("node_io_operations_per_second",
+			"Read and write operations per second of the node in each interval of the node stats",
+			prometheus.ExponentialBuckets(10, 2, 12)),
+		NodeThroughput: newNodeHistogram("node_io_throughput_bytes_per_second",
+			"Bytes per second read and written by the node in each interval of the node stats",
+			prometheus.ExponentialBuckets(1<<20, 2, 12)),
+		NodeStatsTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "node_stats_last_timestamp_seconds"),
+			"Time of the newest point in the node stats",
+			[]string{"cluster", "node"}, nil,
+		),
+		nodeStatsSeen: &nodeStatsSeen{last: make(map[string]time.Time)},
+
+		SystemPhysicalIngest: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "system_physical_ingest_bytes"),
+			"Physically stored bytes ingested by the cluster in the newest sample",
+			[]string{"cluster"}, nil,
+		),
+
+		SystemStorageAvailable: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("system_storage_available_bytes", "system_storage_available")),
+			"Free storage of the cluster in bytes",
+			[]string{"cluster"}, nil,
+		),
+		SystemStorageLiveMount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("system_storage_live_mount_bytes", "system_storage_live_mount")),
+			"Storage used by live mounts in bytes",
+			[]string{"cluster"}, nil,
+		),
+		SystemStorageMiscellaneous: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("system_storage_miscellaneous_bytes", "system_storage_miscellaneous")),
+			"Storage used by other data than snapshots and live mounts in bytes",
+			[]string{"cluster"}, nil,
+		),
+		SystemStorageSnapshot: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("system_storage_snapshot_bytes", "system_storage_snapshot")),
+			"Storage used by snapshots in bytes",
+			[]string{"cluster"}, nil,
+		),
+		SystemStorageSize: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("system_storage_size_bytes", "system_storage_size")),
+			"Total storage of the cluster in bytes",
+			[]string{"cluster"}, nil,
+		),
+		SystemStorageUsed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("system_storage_used_bytes", "system_storage_used")),
+			"Used storage of the cluster in bytes",
+			[]string{"cluster"}, nil,
+		),
+
+		ArchiveStorageBandwith: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("archive_storage_bandwidth_bytes_per_second", "archive_storage_bandwidth")),
+			"Bytes per second sent to the archive location in the newest point of the last 10 minutes",
+			[]string{"cluster", "name", "target"}, nil,
+		),
+		ArchiveStorageBandwithMax: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.windowName("archive_storage_bandwidth_bytes_per_second", "archive_storage_bandwidth", "max")),
+			"Bytes per second sent to the archive location, maximum over the last 10 minutes",
+			[]string{"cluster", "name", "target"}, nil,
+		),
+		ArchiveStorageBandwithAvg: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.windowName("archive_storage_bandwidth_bytes_per_second", "archive_storage_bandwidth", "avg")),
+			"Bytes per second sent to the archive location, average over the last 10 minutes",
+			[]string{"cluster", "name", "target"}, nil,
+		),
+		ArchiveStorageArchivedFileSet: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "archive_storage_archived_fileset"),
+			"Filesets with snapshots on the archive location by fileset type",
+			[]string{"cluster", "name", "target", "type"}, nil,
+		),
+		ArchiveStorageArchivedVM: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "archive_storage_archived_vm"),
+			"VMs with snapshots on the archive location by hypervisor",
+			[]string{"cluster", "name", "target", "type"}, nil,
+		),
+		ArchiveStorageDataArchived: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("archive_storage_data_archived_bytes_total", "archive_storage_data_archived")),
+			"Bytes archived to the archive location",
+			[]string{"cluster", "name", "target"}, nil,
+		),
+		ArchiveStorageDataDownloaded: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", schema.name("archive_storage_data_downloaded_bytes_total", "archive_storage_data_downloaded")),
+			"Bytes downloaded from the archive location",
+			[]string{"cluster", "name", "target"}, nil,
+		),
 	}
+	e.nodeSeries = newNodeSeries(e)
+	return e
 }
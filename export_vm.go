@@ -11,6 +11,8 @@
 package main
 
 import (
+	"context"
+	"log/slog"
 	"strings"
 
 	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
@@ -19,92 +21,243 @@ import (
 
 // VMStats ...
 type VMStats struct {
-	VMIsProtected         *prometheus.GaugeVec
-	VMLogicalBytes        *prometheus.GaugeVec
-	VMIngestedBytes       *prometheus.GaugeVec
-	VMExclusiveBytes      *prometheus.GaugeVec
-	VMSharedPhysicalbytes *prometheus.GaugeVec
-	VMIndexStorageBytes   *prometheus.GaugeVec
+	VMIsProtected         *prometheus.Desc
+	VMLogicalBytes        *prometheus.Desc
+	VMIngestedBytes       *prometheus.Desc
+	VMExclusiveBytes      *prometheus.Desc
+	VMSharedPhysicalbytes *prometheus.Desc
+	VMIndexStorageBytes   *prometheus.Desc
+
+	filter objectFilter
+	// aggregate sums the VMs by sla or folder instead of exporting each
+	aggregate string
+	groups    vmGroupDescs
+	labels    *enricher
+	// info is rubrik_vm_info, the value metrics only have the VM ID
+	info *prometheus.Desc
+}
+
+// Aggregations of -vm.aggregate
+const (
+	aggregateSLA    = "sla"
+	aggregateFolder = "folder"
+)
+
+// vmGroupDescs - The metrics of the VMs summed by SLA domain or folder
+type vmGroupDescs struct {
+	count, protected                                           *prometheus.Desc
+	exclusive, indexStorage, ingested, logical, sharedPhysical *prometheus.Desc
+}
+
+// vmGroup - The sums of one SLA domain or folder
+type vmGroup struct {
+	count, protected                                           float64
+	exclusive, indexStorage, ingested, logical, sharedPhysical float64
 }
 
 // Describe ...
 func (e VMStats) Describe(ch chan<- *prometheus.Desc) {
-	e.VMIsProtected.Describe(ch)
-	e.VMExclusiveBytes.Describe(ch)
-	e.VMIndexStorageBytes.Describe(ch)
-	e.VMIngestedBytes.Describe(ch)
-	e.VMLogicalBytes.Describe(ch)
-	e.VMSharedPhysicalbytes.Describe(ch)
+	if e.aggregate != "" {
+		for _, d := range []*prometheus.Desc{
+			e.groups.count, e.groups.protected, e.groups.exclusive, e.groups.indexStorage,
+			e.groups.ingested, e.groups.logical, e.groups.sharedPhysical,
+		} {
+			ch <- d
+		}
+		return
+	}
+	ch <- e.VMIsProtected
+	ch <- e.VMExclusiveBytes
+	ch <- e.VMIndexStorageBytes
+	ch <- e.VMIngestedBytes
+	ch <- e.VMLogicalBytes
+	ch <- e.VMSharedPhysicalbytes
+	ch <- e.info
+}
+
+// Update - Export the protection and storage of the VMs of every cluster
+func (e *VMStats) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return forEachCluster(ctx, func(ctx context.Context, api rubrik.Rubrik, cluster string) error {
+		return e.collectCluster(ctx, ch, api, cluster)
+	})
+}
+
+// Priority - Listing every VM is the most expensive collector, it waits for
+// the others
+func (e *VMStats) Priority() rubrik.Priority {
+	return rubrik.PriorityLow
 }
 
-// Collect ...
-func (e *VMStats) Collect(ch chan<- prometheus.Metric) {
+// collectCluster ...
+func (e *VMStats) collectCluster(ctx context.Context, ch chan<- prometheus.Metric, api rubrik.Rubrik, cluster string) error {
 	storages := make(map[string]rubrik.VmStorage)
 
-	for _, s := range rubrikAPI.GetPerVMStorage() {
+	perVMStorage, err := api.GetPerVMStorage(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range perVMStorage {
 		storages[s.ID] = s
 	}
 
-	vms := rubrikAPI.ListAllVM()
+	// Without its tags the VMs are still exported, only the tag labels stay
+	// empty
+	var tags map[string]map[string]string
+	if e.aggregate == "" && e.labels.wantsTags() {
+		if tags, err = api.GetVMTags(ctx); err != nil {
+			slog.WarnContext(ctx, "VMs exported without their vSphere tags", "err", err)
+		}
+	}
+
+	// Export the VMs of the hypervisors that answered, even if one failed
+	vms, err := api.ListAllVM(ctx)
+	groups := make(map[string]*vmGroup)
 	for _, vm := range vms {
-		shortID := strings.Split(vm.ID, ":::")[1]
+		if !e.filter.matchVM(vm) {
+			continue
+		}
+		// REST IDs look like VirtualMachine:::<id>, RSC IDs are plain UUIDs
+		shortID := vm.ID
+		if _, id, found := strings.Cut(vm.ID, ":::"); found {
+			shortID = id
+		}
 		strg := storages[shortID]
 
-		var g prometheus.Gauge
+		if e.aggregate != "" {
+			key := vm.EffectiveSLADomainName
+			if e.aggregate == aggregateFolder {
+				key = vm.Folder
+			}
+			g, ok := groups[key]
+			if !ok {
+				g = &vmGroup{}
+				groups[key] = g
+			}
+			g.count++
+			if vm.EffectiveSLADomainID != "UNPROTECTED" {
+				g.protected++
+			}
+			g.exclusive += strg.ExclusivePhysicalBytes
+			g.indexStorage += strg.IndexStorageBytes
+			g.ingested += strg.IngestedBytes
+			g.logical += strg.Logicalbytes
+			g.sharedPhysical += strg.SharedPhysicalBytes
+			continue
+		}
+
+		labels := []string{cluster, vm.ID}
+		info := []string{cluster, vm.ID, vm.Name, vm.EffectiveSLADomainName, vm.EffectiveSLADomainID, vm.Hypervisor, vm.Folder}
+		extra := e.labels.values([]string{vm.ID, shortID}, vm.Name, tags[vm.ID])
+		if e.labels.onSeries() {
+			labels = append(labels, extra...)
+		} else if e.labels.onInfo() {
+			info = append(info, extra...)
+		}
+		ch <- prometheus.MustNewConstMetric(e.info, prometheus.GaugeValue, 1, info...)
 
-		g = e.VMIsProtected.WithLabelValues(vm.Name, vm.ID)
+		protected := 1.0
 		if vm.EffectiveSLADomainID == "UNPROTECTED" {
-			g.Set(0)
-		} else {
-			g.Set(1)
+			protected = 0
+		}
+		ch <- prometheus.MustNewConstMetric(e.VMIsProtected, prometheus.GaugeValue, protected, labels...)
+
+		ch <- prometheus.MustNewConstMetric(e.VMExclusiveBytes, prometheus.GaugeValue, float64(strg.ExclusivePhysicalBytes), labels...)
+		ch <- prometheus.MustNewConstMetric(e.VMIndexStorageBytes, prometheus.GaugeValue, float64(strg.IndexStorageBytes), labels...)
+		ch <- prometheus.MustNewConstMetric(e.VMIngestedBytes, prometheus.GaugeValue, float64(strg.IngestedBytes), labels...)
+		ch <- prometheus.MustNewConstMetric(e.VMLogicalBytes, prometheus.GaugeValue, float64(strg.Logicalbytes), labels...)
+		ch <- prometheus.MustNewConstMetric(e.VMSharedPhysicalbytes, prometheus.GaugeValue, float64(strg.SharedPhysicalBytes), labels...)
+	}
+
+	for key, g := range groups {
+		for _, m := range []struct {
+			desc  *prometheus.Desc
+			value float64
+		}{
+			{e.groups.count, g.count},
+			{e.groups.protected, g.protected},
+			{e.groups.exclusive, g.exclusive},
+			{e.groups.indexStorage, g.indexStorage},
+			{e.groups.ingested, g.ingested},
+			{e.groups.logical, g.logical},
+			{e.groups.sharedPhysical, g.sharedPhysical},
+		} {
+			ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, m.value, cluster, key)
 		}
-		g.Collect(ch)
-
-		g = e.VMExclusiveBytes.WithLabelValues(vm.Name, vm.ID)
-		g.Set(float64(strg.ExclusivePhysicalBytes))
-		g.Collect(ch)
-		g = e.VMIndexStorageBytes.WithLabelValues(vm.Name, vm.ID)
-		g.Set(float64(strg.IndexStorageBytes))
-		g.Collect(ch)
-		g = e.VMIngestedBytes.WithLabelValues(vm.Name, vm.ID)
-		g.Set(float64(strg.IndexStorageBytes))
-		g.Collect(ch)
-		g = e.VMLogicalBytes.WithLabelValues(vm.Name, vm.ID)
-		g.Set(float64(strg.Logicalbytes))
-		g.Collect(ch)
-		g = e.VMSharedPhysicalbytes.WithLabelValues(vm.Name, vm.ID)
-		g.Set(float64(strg.SharedPhysicalBytes))
-		g.Collect(ch)
 	}
 
+	return err
+}
+
+// newVMGroupDescs - The metrics of -vm.aggregate, labeled with by. None
+// without aggregation.
+func newVMGroupDescs(by string) vmGroupDescs {
+	if by == "" {
+		return vmGroupDescs{}
+	}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "vm_group", name), help, []string{"cluster", by}, nil)
+	}
+	return vmGroupDescs{
+		count:          desc("vms", "Number of VMs by "+by),
+		protected:      desc("protected_vms", "Number of VMs with an SLA domain by "+by),
+		exclusive:      desc("consumed_exclusive_bytes", "Exclusive physical bytes of the VMs by "+by),
+		indexStorage:   desc("consumed_index_storage_bytes", "Index storage bytes of the VMs by "+by),
+		ingested:       desc("consumed_ingested_bytes", "Ingested bytes of the VMs by "+by),
+		logical:        desc("consumed_logical_bytes", "Logical bytes of the VMs by "+by),
+		sharedPhysical: desc("consumed_shared_physical_bytes", "Shared physical bytes of the VMs by "+by),
+	}
 }
 
-// NewVMStatsExport ...
-func NewVMStatsExport() *VMStats {
+// NewVMStatsExport - Exports the VMs matching filter, summed by SLA domain
+// or folder if aggregate is set. Without aggregation labels adds its labels
+// to the value metrics or rubrik_vm_info.
+func NewVMStatsExport(filter objectFilter, aggregate string, labels *enricher) *VMStats {
+	names := []string{"cluster", "vmid"}
+	info := []string{"cluster", "vmid", "vmname", "sla", "sla_id", "hypervisor", "folder"}
+	switch {
+	case aggregate != "":
+		labels = nil
+	case labels.onSeries():
+		names = append(names, labels.labelNames()...)
+	case labels.onInfo():
+		info = append(info, labels.labelNames()...)
+	}
 	return &VMStats{
-		VMIsProtected: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "vm_protected",
-			Help: "...",
-		}, []string{"vmname", "vmid"}),
-		VMExclusiveBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "vm_consumed_exclusive_bytes",
-			Help: "...",
-		}, []string{"vmname", "vmid"}),
-		VMIndexStorageBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "vm_consumed_index_storage_bytes",
-			Help: "...",
-		}, []string{"vmname", "vmid"}),
-		VMIngestedBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "vm_consumed_ingested_bytes",
-			Help: "...",
-		}, []string{"vmname", "vmid"}),
-		VMLogicalBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "vm_consumed_logical_bytes",
-			Help: "...",
-		}, []string{"vmname", "vmid"}),
-		VMSharedPhysicalbytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Namespace: namespace, Name: "vm_consumed_shared_physical_bytes",
-			Help: "...",
-		}, []string{"vmname", "vmid"}),
+		filter:    filter,
+		aggregate: aggregate,
+		groups:    newVMGroupDescs(aggregate),
+		labels:    labels,
+		info: prometheus.NewDesc(prometheus.BuildFQName(namespace, "vm", "info"),
+			"Name, SLA domain, hypervisor and folder of a VM, join on vmid", info, nil),
+		VMIsProtected: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "vm_protected"),
+			"Whether the VM has an SLA domain, 1 protected and 0 unprotected",
+			names, nil,
+		),
+		VMExclusiveBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "vm_consumed_exclusive_bytes"),
+			"Physical bytes only the snapshots of the VM use",
+			names, nil,
+		),
+		VMIndexStorageBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "vm_consumed_index_storage_bytes"),
+			"Bytes of the file index of the VM snapshots",
+			names, nil,
+		),
+		VMIngestedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "vm_consumed_ingested_bytes"),
+			"Bytes ingested from the VM by its snapshots",
+			names, nil,
+		),
+		VMLogicalBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "vm_consumed_logical_bytes"),
+			"Logical size of the VM snapshots in bytes",
+			names, nil,
+		),
+		VMSharedPhysicalbytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "vm_consumed_shared_physical_bytes"),
+			"Physical bytes the VM snapshots share with other snapshots",
+			names, nil,
+		),
 	}
 }
@@ -0,0 +1,173 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// globPrefix marks a pattern as glob instead of regular expression
+const globPrefix = "glob:"
+
+// compilePattern - An anchored regular expression, or a glob with * and ?
+// if s starts with glob:
+func compilePattern(s string) (*regexp.Regexp, error) {
+	if glob, ok := strings.CutPrefix(s, globPrefix); ok {
+		s = regexp.QuoteMeta(glob)
+		s = strings.ReplaceAll(s, `\*`, ".*")
+		s = strings.ReplaceAll(s, `\?`, ".")
+	}
+	return regexp.Compile("^(?:" + s + ")$")
+}
+
+// patterns - Include and exclude patterns of one object property
+type patterns struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// newPatterns ...
+func newPatterns(include, exclude []string) (patterns, error) {
+	var p patterns
+	for _, s := range include {
+		re, err := compilePattern(s)
+		if err != nil {
+			return p, err
+		}
+		p.include = append(p.include, re)
+	}
+	for _, s := range exclude {
+		re, err := compilePattern(s)
+		if err != nil {
+			return p, err
+		}
+		p.exclude = append(p.exclude, re)
+	}
+	return p, nil
+}
+
+// match - Whether one of values matches an include pattern, if there are
+// any, and none matches an exclude pattern
+func (p patterns) match(values ...string) bool {
+	if len(p.include) > 0 && !matchAny(p.include, values) {
+		return false
+	}
+	return !matchAny(p.exclude, values)
+}
+
+func matchAny(res []*regexp.Regexp, values []string) bool {
+	for _, re := range res {
+		for _, v := range values {
+			if re.MatchString(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// objectFilter - Selects the VMs and managed volumes that are exported
+type objectFilter struct {
+	name       patterns
+	sla        patterns
+	hypervisor patterns
+}
+
+// filterConfig - The -filter.* flags
+type filterConfig struct {
+	includeName, excludeName             []string
+	includeSLA, excludeSLA               []string
+	includeHypervisor, excludeHypervisor []string
+}
+
+// newObjectFilter ...
+func newObjectFilter(c filterConfig) (objectFilter, error) {
+	var f objectFilter
+	var err error
+	if f.name, err = newPatterns(c.includeName, c.excludeName); err != nil {
+		return f, fmt.Errorf("name filter: %v", err)
+	}
+	if f.sla, err = newPatterns(c.includeSLA, c.excludeSLA); err != nil {
+		return f, fmt.Errorf("SLA filter: %v", err)
+	}
+	if f.hypervisor, err = newPatterns(c.includeHypervisor, c.excludeHypervisor); err != nil {
+		return f, fmt.Errorf("hypervisor filter: %v", err)
+	}
+	return f, nil
+}
+
+// matchVM - The SLA patterns match the SLA domain name or ID
+func (f objectFilter) matchVM(vm rubrik.VirtualMachine) bool {
+	return f.name.match(vm.Name) &&
+		f.sla.match(vm.EffectiveSLADomainName, vm.EffectiveSLADomainID) &&
+		f.hypervisor.match(vm.Hypervisor)
+}
+
+// matchManagedVolume - Managed volumes have no hypervisor, those patterns
+// don't apply to them
+func (f objectFilter) matchManagedVolume(v rubrik.ManagedVolume) bool {
+	return f.name.match(v.Name) &&
+		f.sla.match(v.EffectiveSLADomainName, v.EffectiveSLADomainID)
+}
+
+var droppedSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace, Subsystem: "scrape", Name: "dropped_series_total",
+	Help: "Series not exported because a collector run exceeded -collector.max-series",
+}, []string{"collector"})
+
+// seriesLimit - Passes at most max series of a collector run on, the rest
+// are dropped, logged and counted
+type seriesLimit struct {
+	Collector
+	name string
+	max  int
+}
+
+// Priority - The priority of the limited collector
+func (l seriesLimit) Priority() rubrik.Priority {
+	if p, ok := l.Collector.(prioritized); ok {
+		return p.Priority()
+	}
+	return rubrik.PriorityNormal
+}
+
+// Update ...
+func (l seriesLimit) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	buf := make(chan prometheus.Metric)
+	dropped := make(chan int)
+	go func() {
+		n, drop := 0, 0
+		for m := range buf {
+			if n < l.max {
+				ch <- m
+				n++
+				continue
+			}
+			drop++
+		}
+		dropped <- drop
+	}()
+	err := l.Collector.Update(ctx, buf)
+	close(buf)
+
+	if n := <-dropped; n > 0 {
+		droppedSeries.WithLabelValues(l.name).Add(float64(n))
+		slog.WarnContext(ctx, "Collector exceeded the series limit, series dropped", "limit", l.max, "dropped", n)
+	}
+	return err
+}
@@ -0,0 +1,115 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubriktest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObjectFilter(t *testing.T) {
+	f, err := newObjectFilter(filterConfig{
+		includeName:       []string{"glob:web-*", "db-0[0-9]"},
+		excludeSLA:        []string{"Bronze"},
+		excludeHypervisor: []string{"hyperv"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		vm   rubrik.VirtualMachine
+		want bool
+	}{
+		{rubrik.VirtualMachine{Name: "web-01", EffectiveSLADomainName: "Gold", Hypervisor: "vmware"}, true},
+		{rubrik.VirtualMachine{Name: "db-01", EffectiveSLADomainName: "Gold", Hypervisor: "nutanix"}, true},
+		{rubrik.VirtualMachine{Name: "db-010", EffectiveSLADomainName: "Gold", Hypervisor: "vmware"}, false},
+		{rubrik.VirtualMachine{Name: "myweb-01", EffectiveSLADomainName: "Gold", Hypervisor: "vmware"}, false},
+		{rubrik.VirtualMachine{Name: "web-02", EffectiveSLADomainName: "Bronze", Hypervisor: "vmware"}, false},
+		{rubrik.VirtualMachine{Name: "web-03", EffectiveSLADomainName: "Gold", Hypervisor: "hyperv"}, false},
+	} {
+		if got := f.matchVM(tc.vm); got != tc.want {
+			t.Errorf("matchVM(%+v) = %t, want %t", tc.vm, got, tc.want)
+		}
+	}
+
+	// Hypervisor patterns don't apply to managed volumes
+	if !f.matchManagedVolume(rubrik.ManagedVolume{Name: "web-mv", EffectiveSLADomainName: "Gold"}) {
+		t.Error("managed volume web-mv filtered")
+	}
+
+	if _, err := newObjectFilter(filterConfig{includeSLA: []string{"("}}); err == nil {
+		t.Error("invalid regular expression accepted")
+	}
+}
+
+func TestVMAggregate(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+
+	for _, tc := range []struct {
+		aggregate string
+		want      []string
+	}{
+		{aggregateSLA, []string{
+			`rubrik_vm_group_vms{cluster="cdm-lab-01",sla="Gold"} 3`,
+			`rubrik_vm_group_protected_vms{cluster="cdm-lab-01",sla="Unprotected"} 0`,
+		}},
+		{aggregateFolder, []string{
+			`rubrik_vm_group_vms{cluster="cdm-lab-01",folder="vcenter-01/DC1/Prod/Web"} 1`,
+			`rubrik_vm_group_vms{cluster="cdm-lab-01",folder="vcenter-01/DC1/Lab"} 1`,
+		}},
+	} {
+		collectors := map[string]Collector{"vm": NewVMStatsExport(objectFilter{}, tc.aggregate, nil)}
+		got := string(scrape(t, collectors))
+		for _, line := range tc.want {
+			if !strings.Contains(got, line) {
+				t.Errorf("-vm.aggregate=%s: %s missing in\n%s", tc.aggregate, line, got)
+			}
+		}
+		if strings.Contains(got, "rubrik_vm_consumed_logical_bytes") {
+			t.Errorf("-vm.aggregate=%s exports per VM metrics", tc.aggregate)
+		}
+	}
+}
+
+func TestSeriesLimit(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+
+	before := testutil.ToFloat64(droppedSeries.WithLabelValues("vm"))
+	collectors := newCollectors(collectorConfig{maxSeries: 4})
+	got := string(scrape(t, map[string]Collector{"vm": collectors["vm"]}))
+
+	series := 0
+	for _, line := range strings.Split(got, "\n") {
+		if strings.HasPrefix(line, "rubrik_vm_") {
+			series++
+		}
+	}
+	if series != 4 {
+		t.Errorf("%d VM series exported, want 4:\n%s", series, got)
+	}
+	// 5 VMs with 7 series each
+	if dropped := testutil.ToFloat64(droppedSeries.WithLabelValues("vm")) - before; dropped != 31 {
+		t.Errorf("%g series counted as dropped, want 31", dropped)
+	}
+	if _, ok := collectors["vm"].(prioritized); !ok {
+		t.Error("the limited vm collector lost its priority")
+	}
+}
@@ -46,7 +46,8 @@ ExecStart=/usr/bin/rubrik-exporter \
   -rubrik.password=${RUBRIK_PASSWORD} \
   -rubrik.service-account-client-id=${RUBRIK_SERVICE_ACCOUNT_CLIENT_ID} \
   -rubrik.service-account-client-secret=${RUBRIK_SERVICE_ACCOUNT_CLIENT_SECRET} \
-  -listen-address=:9477
+  -rubrik.service-account-file=${RUBRIK_SERVICE_ACCOUNT_FILE} \
+  -web.listen-address=:9477
 
 # Environment file - create /etc/default/rubrik-exporter with your config
 EnvironmentFile=-/etc/default/rubrik-exporter
@@ -78,6 +79,9 @@ if [ ! -f /etc/default/rubrik-exporter ]; then
 # REQUIRED: Rubrik API Password
 # RUBRIK_PASSWORD=your-password-here
 
+# ALTERNATIVE: Service account JSON file downloaded from Rubrik
+# RUBRIK_SERVICE_ACCOUNT_FILE=/etc/rubrik-exporter/service-account.json
+
 # OPTIONAL: Listen address (default: :9477)
 # LISTEN_ADDRESS=:9477
 EOF
@@ -0,0 +1,166 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+// Package logging sets up log/slog for the exporter. Every record is
+// redacted, and the fields added to a context with With are logged with
+// every record of that context.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Log formats
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+// Redacted replaces secrets in the logs
+const Redacted = "REDACTED"
+
+var (
+	// secretKey matches field names whose values are never logged
+	secretKey = regexp.MustCompile(`(?i)token|password|secret|authorization|cookie`)
+	// secretValue matches credentials inside messages and errors, e.g. an
+	// Authorization header or a JSON or form field
+	secretValue = regexp.MustCompile(`(?i)((?:bearer|basic)\s+|"?[\w.-]*(?:token|password|secret)[\w.-]*"?\s*[:=]\s*"?)[^\s"&,}]+`)
+)
+
+// Redact - Replace the credentials in s
+func Redact(s string) string {
+	return secretValue.ReplaceAllString(s, "${1}"+Redacted)
+}
+
+// NewHandler - A handler writing records of level and above to w in format
+func NewHandler(w io.Writer, level slog.Leveler, format string) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+
+	var h slog.Handler
+	switch format {
+	case FormatLogfmt:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, use %s or %s", format, FormatLogfmt, FormatJSON)
+	}
+	return contextHandler{h}, nil
+}
+
+// ParseLevel - debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// redactAttr - Drop the values of secret fields and the credentials in the
+// other strings, the message included
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+	if secretKey.MatchString(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+
+	switch v := a.Value.Any().(type) {
+	case string:
+		if secretValue.MatchString(v) {
+			return slog.String(a.Key, Redact(v))
+		}
+	case error:
+		return slog.String(a.Key, Redact(v.Error()))
+	case fmt.Stringer:
+		if s := v.String(); secretValue.MatchString(s) {
+			return slog.String(a.Key, Redact(s))
+		}
+	}
+	return a
+}
+
+type fieldsKey struct{}
+
+// With - Returns a context whose records carry args, given as key value
+// pairs or slog.Attr like to slog.Log. Fields of the same key replace the
+// ones of the parent context.
+func With(ctx context.Context, args ...any) context.Context {
+	r := slog.Record{}
+	r.Add(args...)
+
+	var added []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		added = append(added, a)
+		return true
+	})
+
+	// Outer fields first, they read cluster, collector, endpoint
+	var fields []slog.Attr
+	for _, f := range fromContext(ctx) {
+		if !hasKey(added, f.Key) {
+			fields = append(fields, f)
+		}
+	}
+	fields = append(fields, added...)
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+func fromContext(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(fieldsKey{}).([]slog.Attr)
+	return fields
+}
+
+func hasKey(attrs []slog.Attr, key string) bool {
+	for _, a := range attrs {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// contextHandler - Adds the fields of the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if fields := fromContext(ctx); len(fields) > 0 {
+		r = r.Clone()
+		r.AddAttrs(fields...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Trim - Shorten s to n bytes for a log field
+func Trim(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,85 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	for in, want := range map[string]string{
+		"Authorization: Bearer eyJhbGciOi.x-y_z":             "Authorization: Bearer REDACTED",
+		`{"username":"admin","password":"s3cr3t"}`:           `{"username":"admin","password":"REDACTED"}`,
+		"grant_type=client_credentials&client_secret=abc123": "grant_type=client_credentials&client_secret=REDACTED",
+		`session token: "token-1"`:                           `session token: "REDACTED"`,
+		"Session token rejected, logging in again":           "Session token rejected, logging in again",
+	} {
+		if got := Redact(in); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := NewHandler(&buf, slog.LevelInfo, FormatLogfmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(h)
+
+	ctx := With(context.Background(), "collector", "vm")
+	ctx = With(ctx, "cluster", "cdm-01")
+	ctx = With(ctx, "backend", "rest", "endpoint", "/api/v1/vmware/vm")
+	logger.DebugContext(ctx, "hidden below info")
+	logger.WarnContext(ctx, "Rubrik API request failed", "token", "token-1", "err", errors.New("Bearer token-1 expired"))
+
+	got := strings.TrimSpace(buf.String())
+	for _, want := range []string{
+		`level=WARN msg="Rubrik API request failed" token=REDACTED err="Bearer REDACTED expired" collector=vm cluster=cdm-01 backend=rest endpoint=/api/v1/vmware/vm`,
+	} {
+		if !strings.HasSuffix(got, want) {
+			t.Errorf("log line\n%s\ndoesn't end in\n%s", got, want)
+		}
+	}
+	if strings.Contains(got, "hidden") || strings.Contains(got, "token-1") {
+		t.Errorf("log line %q contains a debug record or a secret", got)
+	}
+}
+
+func TestHandlerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := NewHandler(&buf, slog.LevelDebug, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := With(context.Background(), "cluster", "cdm-01")
+	slog.New(h).InfoContext(With(ctx, "cluster", "cdm-02"), "Connected", "client_secret", "abc")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("%v: %s", err, buf.String())
+	}
+	if record["cluster"] != "cdm-02" || record["client_secret"] != Redacted {
+		t.Errorf("record = %v", record)
+	}
+
+	if _, err := NewHandler(&buf, slog.LevelInfo, "xml"); err == nil {
+		t.Error("NewHandler() accepted an unknown format")
+	}
+}
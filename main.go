@@ -11,71 +11,453 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/logging"
 	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/tracing"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
 var rubrikAPI *rubrik.Rubrik
 var vmIDNameMap map[string]string
 
 var (
-	namespace                    = "rubrik"
-	rubrikURL                    = flag.String("rubrik.url", "", "Rubrik URL to connect https://rubrik.local.host")
-	rubrikUser                   = flag.String("rubrik.username", "", "Rubrik API User")
-	rubrikPassword               = flag.String("rubrik.password", "", "Rubrik API User Password")
-	rubrikServiceAccountClientID = flag.String("rubrik.service-account-client-id", "", "Rubrik Service Account Client ID")
-	rubrikServiceAccountClientSecret = flag.String("rubrik.service-account-client-secret", "", "Rubrik Service Account Client Secret")
-	listenAddress                = flag.String("listen-address", ":9477", "The address to listen on for HTTP requests.")
+	namespace                        = "rubrik"
+	rubrikURL                        = flag.String("rubrik.url", "", "Rubrik URL to connect https://rubrik.local.host")
+	rubrikUser                       = flag.String("rubrik.username", "", "Rubrik API User")
+	rubrikPassword                   = flag.String("rubrik.password", "", "Rubrik API User Password, or file://, env://, systemd:// or vault://<path>#<field> to read it from there")
+	rubrikServiceAccountClientID     = flag.String("rubrik.service-account-client-id", "", "Rubrik Service Account Client ID")
+	rubrikServiceAccountClientSecret = flag.String("rubrik.service-account-client-secret", "", "Rubrik Service Account Client Secret, or file://, env://, systemd:// or vault://<path>#<field> to read it from there")
+	rubrikServiceAccountFile         = flag.String("rubrik.service-account-file", "", "Rubrik Service Account JSON credentials file (client_id, client_secret, access_token_uri)")
+	rubrikSecurityCloud              = flag.Bool("rubrik.security-cloud", false, "Connect to Rubrik Security Cloud (https://<account>.my.rubrik.com) instead of a CDM cluster, requires a service account")
+	rubrikRequestTimeout             = flag.Duration("rubrik.request-timeout", rubrik.DefaultRequestTimeout, "Timeout of a single Rubrik API call")
+	rubrikMaxIdleConns               = flag.Int("rubrik.max-idle-conns", rubrik.DefaultMaxIdleConns, "Number of keep-alive connections to the Rubrik API")
+	rubrikMaxRetries                 = flag.Int("rubrik.max-retries", rubrik.DefaultMaxRetries, "How often a Rubrik API call failing with 5xx, 429 or a reset connection is retried, 0 disables retries")
+	rubrikRateLimit                  = flag.Float64("rubrik.rate-limit", 0, "Maximum Rubrik API requests per second, 0 for no limit")
+	rubrikRateBurst                  = flag.Int("rubrik.rate-burst", 5, "Rubrik API requests allowed in a burst above -rubrik.rate-limit")
+	rubrikMaxConcurrentRequests      = flag.Int("rubrik.max-concurrent-requests", 0, "Maximum Rubrik API requests in flight, 0 for no limit")
+	rubrikRecordDir                  = flag.String("rubrik.record-dir", "", "Write every Rubrik API call and its response to this directory, with credentials redacted")
+	rubrikReplayDir                  = flag.String("rubrik.replay-dir", "", "Answer all Rubrik API calls from a capture written with -rubrik.record-dir instead of contacting Rubrik")
+	listenAddress                    = flag.String("listen-address", "", "Deprecated, use -web.listen-address")
+	webListenAddresses               = stringsVar("web.listen-address", "Address to listen on for HTTP requests, can be given several times (default \":9477\")")
+	webConfigFile                    = flag.String("web.config.file", "", "Web configuration file with TLS and basic authentication settings, see https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md")
+	webSystemdSocket                 = flag.Bool("web.systemd-socket", false, "Listen on the sockets passed by systemd socket activation instead of -web.listen-address")
+	webTelemetryPath                 = flag.String("web.telemetry-path", "/metrics", "Path under which the metrics are served")
+	filterNameInclude                = stringsVar("filter.name.include", "Only export VMs and managed volumes whose name matches this regular expression, or glob with the glob: prefix. Can be given several times")
+	filterNameExclude                = stringsVar("filter.name.exclude", "Don't export VMs and managed volumes whose name matches. Can be given several times")
+	filterSLAInclude                 = stringsVar("filter.sla.include", "Only export VMs and managed volumes whose SLA domain name or ID matches. Can be given several times")
+	filterSLAExclude                 = stringsVar("filter.sla.exclude", "Don't export VMs and managed volumes whose SLA domain name or ID matches. Can be given several times")
+	filterHypervisorInclude          = stringsVar("filter.hypervisor.include", "Only export VMs whose hypervisor matches: vmware, nutanix or hyperv. Can be given several times")
+	filterHypervisorExclude          = stringsVar("filter.hypervisor.exclude", "Don't export VMs whose hypervisor matches. Can be given several times")
+	vmAggregate                      = flag.String("vm.aggregate", "", "Export the VM metrics summed by sla or folder instead of per VM")
+	labelsFile                       = flag.String("labels.file", "", "CSV or YAML file mapping VM and managed volume IDs or name patterns to extra labels")
+	labelsVSphereTags                = flag.String("labels.vsphere-tags", "", "vSphere tag categories added as labels to the VMware VM metrics, separated by commas, e.g. Owner,Cost Center. Managed volumes and tags set in Rubrik aren't covered")
+	labelsTarget                     = flag.String("labels.target", labelsOnSeries, "Where the extra labels go: series adds them to every VM and managed volume series, info only to rubrik_vm_info and rubrik_managed_volume_info")
+	compatLegacyMetricNames          = flag.Bool("compat.legacy-metric-names", false, "Export the metrics with the names and types before the unit suffixes were added, see the README. Deprecated, will be removed")
+	collectorMaxSeries               = flag.Int("collector.max-series", 0, "Maximum series of a collector run, the rest are dropped and counted in rubrik_scrape_dropped_series_total. 0 for no limit")
+	scrapeTimeoutOffset              = flag.Duration("scrape.timeout-offset", 500*time.Millisecond, "Time subtracted from the Prometheus scrape timeout to answer with the collected metrics before Prometheus gives up")
+	scrapeFreshness                  = flag.Duration("scrape.freshness-window", 10*time.Second, "Scrapes within this time after a successful collection are answered from it instead of querying Rubrik again")
+	once                             = flag.Bool("once", false, "Run every collector once, write the metrics to -output.file and exit, 1 if a collector failed")
+	outputFile                       = flag.String("output.file", "", "File -once writes the metrics to in text format, e.g. for the node_exporter textfile collector, stdout if empty")
+	pushMode                         = flag.String("push.mode", "", "Push the metrics instead of serving them: pushgateway or remote-write")
+	pushURL                          = flag.String("push.url", "", "Pushgateway URL, or remote write endpoint e.g. http://prometheus:9090/api/v1/write")
+	pushJob                          = flag.String("push.job", "rubrik", "Job name of the pushed metrics")
+	pushInterval                     = flag.Duration("push.interval", time.Minute, "How often the metrics are collected and pushed")
+	pushRetries                      = flag.Int("push.retries", 3, "Retries of a failed push before it is spooled")
+	pushSpoolDir                     = flag.String("push.spool-dir", "", "Directory keeping remote write requests while the receiver is down, sent when it is back")
+	pushSpoolMax                     = flag.Int("push.spool-max", 1440, "Maximum remote write requests in the spool, the oldest are dropped")
+	otlpEndpoint                     = flag.String("otlp.endpoint", "", "Also export the metrics with OTLP to this OpenTelemetry collector, e.g. http://otel-collector:4318")
+	otlpProtocol                     = flag.String("otlp.protocol", "http/protobuf", "OTLP protocol: grpc or http/protobuf")
+	otlpInterval                     = flag.Duration("otlp.interval", time.Minute, "How often the metrics are exported with OTLP")
+	otlpHeaders                      = flag.String("otlp.headers", "", "Headers sent with OTLP metric and trace exports, key=value separated by commas")
+	tracingEndpoint                  = flag.String("tracing.endpoint", "", "Send traces of the scrapes and Rubrik API calls with OTLP to this OpenTelemetry collector, e.g. http://otel-collector:4318")
+	tracingProtocol                  = flag.String("tracing.protocol", "http/protobuf", "OTLP protocol of the traces: grpc or http/protobuf")
+	tracingSamplingRatio             = flag.Float64("tracing.sampling-ratio", 1, "Share of the scrapes traced, 0 to 1. Scrapes sending a traceparent header follow its sampling decision")
+	logLevel                         = flag.String("log.level", "info", "Only log messages with this level or above: debug, info, warn or error")
+	logFormat                        = flag.String("log.format", logging.FormatLogfmt, "Log format: logfmt or json")
+	checkVerbose                     = flag.Bool("check.verbose", false, "Log every API call during rubrik-exporter check instead of only printing the result table")
+	shutdownTimeout                  = flag.Duration("shutdown-timeout", 30*time.Second, "Maximum time to wait for running scrapes on shutdown")
 )
 
 func main() {
-	flag.Parse()
+	started := time.Now()
 
-	log.Print("Create Rubrik Exporter instance")
-	rubrikAPI = rubrik.NewRubrik(*rubrikURL, *rubrikUser, *rubrikPassword, *rubrikServiceAccountClientID, *rubrikServiceAccountClientSecret)
+	// The subcommand comes first, rubrik-exporter check -rubrik.url ...
+	command := ""
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "check" {
+		command, args = args[0], args[1:]
+	}
+	enabled := collectorFlags(flag.CommandLine)
+	flag.CommandLine.Parse(args)
 
-	prometheus.MustRegister(NewRubrikStatsExport())
-	prometheus.MustRegister(NewVMStatsExport())
-	prometheus.MustRegister(NewArchiveLocation())
-	prometheus.MustRegister(NewManagedVolume())
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	handler, err := logging.NewHandler(os.Stderr, level, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	// The check table goes to stdout, logs only on request
+	if command == "check" && !*checkVerbose {
+		handler = slog.DiscardHandler
+	}
+	slog.SetDefault(slog.New(handler))
 
-	metricsHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Metrics request from %s - User-Agent: %s", r.RemoteAddr, r.Header.Get("User-Agent"))
-		promhttp.Handler().ServeHTTP(w, r)
+	if flag.NArg() > 0 {
+		fatal("Unknown command, the only command is check", "command", flag.Arg(0))
+	}
+	filter, err := newObjectFilter(filterConfig{
+		includeName: *filterNameInclude, excludeName: *filterNameExclude,
+		includeSLA: *filterSLAInclude, excludeSLA: *filterSLAExclude,
+		includeHypervisor: *filterHypervisorInclude, excludeHypervisor: *filterHypervisorExclude,
 	})
+	if err != nil {
+		fatal("Invalid -filter flag", "err", err)
+	}
+	if *vmAggregate != "" && *vmAggregate != aggregateSLA && *vmAggregate != aggregateFolder {
+		fatal("Invalid -vm.aggregate, use sla or folder", "value", *vmAggregate)
+	}
+	var categories []string
+	for _, c := range strings.Split(*labelsVSphereTags, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			categories = append(categories, c)
+		}
+	}
+	labels, err := newEnricher(*labelsFile, categories, *labelsTarget)
+	if err != nil {
+		fatal("Invalid -labels flag", "err", err)
+	}
+	schema := metricSchema{legacy: *compatLegacyMetricNames}
+	if schema.legacy {
+		slog.Warn("-compat.legacy-metric-names is deprecated, move to the current metric names")
+	}
+	schema.setInfo()
+	collectors := enabledCollectors(newCollectors(collectorConfig{
+		schema:      schema,
+		filter:      filter,
+		vmAggregate: *vmAggregate,
+		labels:      labels,
+		maxSeries:   *collectorMaxSeries,
+	}), enabled)
+	if len(collectors) == 0 {
+		fatal("All collectors are disabled")
+	}
+
+	password, err := rubrik.ParseSecretSource(*rubrikPassword)
+	if err != nil {
+		fatal("Invalid -rubrik.password", "err", err)
+	}
+	clientSecret, err := rubrik.ParseSecretSource(*rubrikServiceAccountClientSecret)
+	if err != nil {
+		fatal("Invalid -rubrik.service-account-client-secret", "err", err)
+	}
+
+	// The endpoint comes from -rubrik.url or else the service account file
+	var sa rubrik.ServiceAccount
+	if *rubrikServiceAccountFile != "" {
+		sa, err = rubrik.ReadServiceAccountFile(*rubrikServiceAccountFile)
+		if err != nil {
+			fatal("Can't read the service account file", "err", err)
+		}
+		if *rubrikURL == "" && sa.BaseURL() == "" {
+			fatal("No Rubrik URL, set -rubrik.url or the access_token_uri of the service account file", "file", *rubrikServiceAccountFile)
+		}
+	} else if *rubrikURL == "" {
+		fatal("No Rubrik URL, set -rubrik.url or use a -rubrik.service-account-file with an access_token_uri")
+	}
+
+	if *rubrikRecordDir != "" && *rubrikReplayDir != "" {
+		fatal("-rubrik.record-dir and -rubrik.replay-dir can't be used together")
+	}
+	if *rubrikReplayDir != "" {
+		if _, err := os.Stat(*rubrikReplayDir); err != nil {
+			fatal("Invalid -rubrik.replay-dir", "err", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	opts := rubrik.Options{
+		RequestTimeout: *rubrikRequestTimeout,
+		MaxIdleConns:   *rubrikMaxIdleConns,
+		MaxRetries:     *rubrikMaxRetries,
+
+		RateLimit:             *rubrikRateLimit,
+		RateBurst:             *rubrikRateBurst,
+		MaxConcurrentRequests: *rubrikMaxConcurrentRequests,
+
+		RecordDir: *rubrikRecordDir,
+		ReplayDir: *rubrikReplayDir,
+	}
+
+	headers, err := parseOTLPHeaders(*otlpHeaders)
+	if err != nil {
+		fatal("Invalid -otlp.headers", "err", err)
+	}
+	stopTracing := func() {}
+	if *tracingEndpoint != "" && command != "check" {
+		stopTracing, err = startTracing(*tracingEndpoint, *tracingProtocol, *tracingSamplingRatio, headers)
+		if err != nil {
+			fatal("Can't set up tracing", "err", err)
+		}
+	}
+
+	if *rubrikServiceAccountFile != "" {
+		rubrikAPI = rubrik.NewRubrikWithServiceAccount(ctx, *rubrikURL, sa, *rubrikSecurityCloud || sa.IsSecurityCloud(), opts)
+	} else if *rubrikSecurityCloud {
+		rubrikAPI = rubrik.NewRubrikSecurityCloud(ctx, *rubrikURL, *rubrikServiceAccountClientID, clientSecret, opts)
+	} else {
+		rubrikAPI = rubrik.NewRubrik(ctx, *rubrikURL, *rubrikUser, password, *rubrikServiceAccountClientID, clientSecret, opts)
+	}
+
+	if command == "check" {
+		os.Exit(runCheck(ctx, rubrikAPI, collectors, os.Stdout))
+	}
+
+	if *once {
+		code := runOnce(ctx, collectors, *outputFile)
+		if err := rubrikAPI.Logout(context.Background()); err != nil {
+			slog.Error("Rubrik logout failed", "err", err)
+		}
+		stopTracing()
+		os.Exit(code)
+	}
+
+	if *pushMode != "" {
+		code := runPush(ctx, collectors, pushConfig{
+			mode:     *pushMode,
+			url:      *pushURL,
+			job:      *pushJob,
+			interval: *pushInterval,
+			retries:  *pushRetries,
+			spoolDir: *pushSpoolDir,
+			spoolMax: *pushSpoolMax,
+		})
+		if err := rubrikAPI.Logout(context.Background()); err != nil {
+			slog.Error("Rubrik logout failed", "err", err)
+		}
+		stopTracing()
+		os.Exit(code)
+	}
+
+	rubrik.RegisterMetrics(prometheus.DefaultRegisterer)
+	prometheus.MustRegister(coalescedScrapes, droppedSeries, metricSchemaInfo)
+	coalescer := newCoalescer(*scrapeFreshness)
+
+	if *otlpEndpoint != "" {
+		exporter, err := newOTLPExporter(otlpConfig{
+			endpoint: *otlpEndpoint,
+			protocol: *otlpProtocol,
+			interval: *otlpInterval,
+			headers:  headers,
+		}, collectors, coalescer)
+		if err != nil {
+			fatal("Can't set up the OTLP export", "err", err)
+		}
+		prometheus.MustRegister(otlpExports)
+		go exporter.run(ctx)
+	}
+
+	metricsHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := scrapeContext(r, *scrapeTimeoutOffset)
+		defer cancel()
+		ctx, span := tracing.Start(tracing.Extract(ctx, r.Header), "scrape", tracing.KindServer,
+			tracing.Attribute{Key: "user_agent.original", Value: r.Header.Get("User-Agent")})
+		defer span.Finish()
+		slog.DebugContext(ctx, "Metrics request", "remote_addr", r.RemoteAddr, "user_agent", r.Header.Get("User-Agent"))
 
-	// Serve metrics at both /metrics and / for compatibility
-	http.Handle("/metrics", metricsHandler)
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// If request is for metrics (Accept header or direct access), serve metrics
-		acceptHeader := r.Header.Get("Accept")
-		userAgent := r.Header.Get("User-Agent")
-		
-		// Serve metrics if it's from Prometheus/Grafana or has json in Accept header
-		if strings.Contains(acceptHeader, "text/plain") || 
-		   strings.Contains(acceptHeader, "application/json") ||
-		   strings.Contains(userAgent, "Prometheus") ||
-		   strings.Contains(userAgent, "Grafana") ||
-		   r.URL.Path == "/" {
-			metricsHandler.ServeHTTP(w, r)
+		selected, err := selectCollectors(collectors, r.URL.Query()["collect[]"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		
-		// Otherwise show HTML welcome page for browsers
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.Write([]byte(`<html><head><title>Rubrik Exporter</title></head><body><h1>Rubrik Exporter</h1><p><a href="/metrics">Metrics</a></p></body></html>`))
+
+		// The collectors are registered per scrape to hand them its context
+		ctx = withScrapeClusters(ctx)
+		registry := prometheus.NewRegistry()
+		for name, c := range selected {
+			registry.MustRegister(scrapeCollector{ctx: ctx, name: name, collector: c, coalescer: coalescer})
+		}
+		gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+		// Exemplars with the trace IDs are only exposed in the OpenMetrics format
+		opts := promhttp.HandlerOpts{EnableOpenMetrics: *tracingEndpoint != ""}
+		promhttp.HandlerFor(gatherers, opts).ServeHTTP(w, r)
 	})
 
-	log.Printf("Starting Server: %s", *listenAddress)
-	err := http.ListenAndServe(*listenAddress, nil)
+	mux := http.NewServeMux()
+	mux.Handle(*webTelemetryPath, metricsHandler)
+	mux.HandleFunc("/-/healthy", healthyHandler)
+	mux.Handle("/-/ready", readyHandler(rubrikAPI, collectors))
+	mux.Handle("/status", statusHandler(rubrikAPI, collectors, started))
+	landing, err := web.NewLandingPage(web.LandingConfig{
+		Name:        "Rubrik Exporter",
+		Description: "Prometheus exporter for Rubrik CDM clusters and Rubrik Security Cloud",
+		Profiling:   "false",
+		Links: []web.LandingLinks{
+			{Address: *webTelemetryPath, Text: "Metrics", Description: "Metrics of the Rubrik clusters"},
+			{Address: "/status", Text: "Status", Description: "Login, collectors and Rubrik API endpoints, also as JSON with ?format=json"},
+			{Address: "/-/healthy", Text: "Healthy", Description: "Health check, OK while the exporter runs"},
+			{Address: "/-/ready", Text: "Ready", Description: "Readiness check, OK while logged in to Rubrik and after every collector succeeded once"},
+		},
+	})
 	if err != nil {
-		log.Fatal(err)
+		fatal("Can't build the landing page", "err", err)
+	}
+	mux.Handle("/", landing)
+
+	addresses := []string(*webListenAddresses)
+	if *listenAddress != "" {
+		slog.Warn("-listen-address is deprecated, use -web.listen-address")
+		addresses = append(addresses, *listenAddress)
+	}
+	if len(addresses) == 0 {
+		addresses = []string{":9477"}
 	}
+	// The toolkit reads the file again per connection, a broken file is
+	// rejected here instead of failing every handshake later
+	if err := web.Validate(*webConfigFile); err != nil {
+		fatal("Invalid -web.config.file", "err", err)
+	}
+
+	// The toolkit wraps the handler of a server for each of its listeners,
+	// every address gets its own server. systemd passes its sockets at once.
+	groups := [][]string{nil}
+	if !*webSystemdSocket {
+		groups = groups[:0]
+		for _, address := range addresses {
+			groups = append(groups, []string{address})
+		}
+	}
+	servers := make([]*http.Server, len(groups))
+	serverErr := make(chan error, len(groups))
+	for i, group := range groups {
+		// Requests inherit ctx, so a shutdown aborts scrapes waiting on the cluster
+		servers[i] = &http.Server{
+			Handler:     mux,
+			BaseContext: func(net.Listener) context.Context { return ctx },
+		}
+		go func() {
+			serverErr <- web.ListenAndServe(servers[i], &web.FlagConfig{
+				WebListenAddresses: &group,
+				WebSystemdSocket:   webSystemdSocket,
+				WebConfigFile:      webConfigFile,
+			}, slog.Default())
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		fatal("Server failed", "err", err)
+	case <-ctx.Done():
+	}
+
+	code := shutdown(servers)
+	stopTracing()
+	os.Exit(code)
+}
+
+// fatal - Log at error level and exit, slog has no Fatal
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// collectorHelp - What the collectors export, for the -collector.<name> flags
+var collectorHelp = map[string]string{
+	"rubrik":         "cluster storage, nodes, streams, ingest, tasks and reports",
+	"vm":             "VM protection and storage",
+	"archive":        "archive locations and their bandwidth",
+	"managed_volume": "managed volumes",
+}
+
+// collectorConfig - Settings of the collectors
+type collectorConfig struct {
+	schema      metricSchema
+	filter      objectFilter
+	vmAggregate string
+	// labels adds the -labels.* labels, nil for none
+	labels *enricher
+	// maxSeries caps the series of a collector run, 0 for no limit
+	maxSeries int
+}
+
+// newCollectors - The collectors served at /metrics, by name
+func newCollectors(config collectorConfig) map[string]Collector {
+	collectors := map[string]Collector{
+		"rubrik":         NewRubrikStatsExport(config.schema),
+		"vm":             NewVMStatsExport(config.filter, config.vmAggregate, config.labels),
+		"archive":        NewArchiveLocation(),
+		"managed_volume": NewManagedVolume(config.schema, config.filter, config.labels),
+	}
+	if config.maxSeries > 0 {
+		for name, c := range collectors {
+			collectors[name] = seriesLimit{Collector: c, name: name, max: config.maxSeries}
+		}
+	}
+	return collectors
+}
+
+// shutdown - Stop the HTTP servers, abort running Rubrik requests and delete
+// the Rubrik session. Returns the process exit code.
+func shutdown(servers []*http.Server) int {
+	slog.Info("Received shutdown signal, stopping")
+	start := time.Now()
+	code := 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("HTTP server shutdown failed", "err", err)
+			code = 1
+		}
+	}
+
+	if err := rubrikAPI.Logout(ctx); err != nil {
+		slog.Error("Rubrik logout failed", "err", err)
+		code = 1
+	}
+
+	slog.Info("Shutdown completed", "duration", time.Since(start))
+	return code
+}
+
+// stringsFlag - A flag given several times
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+// Set ...
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// stringsVar - Define a flag that can be given several times
+func stringsVar(name, usage string) *stringsFlag {
+	f := &stringsFlag{}
+	flag.Var(f, name, usage)
+	return f
 }
@@ -0,0 +1,380 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubriktest"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// mapping - An exported value, or label if set, and the fixture field it
+// comes from. conv turns the field into the value, numbers and booleans
+// are taken as they are.
+type mapping struct {
+	metric string
+	match  map[string]string
+	label  string
+	key    string
+	path   string
+	conv   func(v any) float64
+}
+
+// Conversions of fields that aren't exported as they are
+var (
+	days = func(v any) float64 { return v.(float64) * 24 * 60 * 60 }
+	is   = func(want string) func(any) float64 {
+		return func(v any) float64 { return boolValue(v == want) }
+	}
+	isNot = func(want string) func(any) float64 {
+		return func(v any) float64 { return boolValue(v != want) }
+	}
+	length = func(v any) float64 { return float64(len(v.([]any))) }
+	// Over the points of a time series, stat in REST and value in GraphQL
+	windowMax = func(v any) float64 {
+		m := 0.0
+		for _, p := range v.([]any) {
+			m = max(m, pointValue(p))
+		}
+		return m
+	}
+	windowAvg = func(v any) float64 {
+		sum := 0.0
+		for _, p := range v.([]any) {
+			sum += pointValue(p)
+		}
+		return sum / float64(len(v.([]any)))
+	}
+	lastTime = func(v any) float64 {
+		points := v.([]any)
+		t, _ := time.Parse(time.RFC3339, points[len(points)-1].(map[string]any)["time"].(string))
+		return float64(t.Unix())
+	}
+)
+
+func pointValue(p any) float64 {
+	if v, ok := p.(map[string]any)["stat"]; ok {
+		return v.(float64)
+	}
+	return p.(map[string]any)["value"].(float64)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// fixtureField - The field at path, names and indexes separated by dots
+func fixtureField(body []byte, path string) (any, error) {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, err
+	}
+	// The REST time series are the whole body
+	if path == "" {
+		return v, nil
+	}
+	for _, p := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = node[p]; !ok {
+				return nil, fmt.Errorf("no field %s", p)
+			}
+		case []any:
+			i, err := strconv.Atoi(p)
+			if err != nil || i >= len(node) {
+				return nil, fmt.Errorf("no index %s", p)
+			}
+			v = node[i]
+		default:
+			return nil, fmt.Errorf("%s in a scalar", p)
+		}
+	}
+	return v, nil
+}
+
+// findMetric - The first series of the family name with the labels of match
+func findMetric(families []*dto.MetricFamily, name string, match map[string]string) *dto.Metric {
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+	series:
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			for k, v := range match {
+				if labels[k] != v {
+					continue series
+				}
+			}
+			return m
+		}
+	}
+	return nil
+}
+
+func metricValue(m *dto.Metric) float64 {
+	switch {
+	case m.GetGauge() != nil:
+		return m.GetGauge().GetValue()
+	case m.GetCounter() != nil:
+		return m.GetCounter().GetValue()
+	case m.GetHistogram() != nil:
+		return float64(m.GetHistogram().GetSampleCount())
+	}
+	return m.GetUntyped().GetValue()
+}
+
+const (
+	mappedVM     = "VirtualMachine:::7b8a9c0d-1e2f-4a3b-9c4d-5e6f7a8b9c0d-vm-102"
+	unprotected  = "VirtualMachine:::7b8a9c0d-1e2f-4a3b-9c4d-5e6f7a8b9c0d-vm-103"
+	mappedVolume = "ManagedVolume:::0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+	mappedNode   = "RVM191S012345"
+)
+
+// sharedMappings - The endpoints read from REST in both shapes
+func sharedMappings() []mapping {
+	node := map[string]string{"node": mappedNode}
+	op := func(op string) map[string]string { return map[string]string{"node": mappedNode, "op": op} }
+	return []mapping{
+		{metric: "rubrik_report_tasks_succeeded", key: "rest/report_chart", path: "0.dataColumns.0.dataPoints.0.value"},
+		{metric: "rubrik_report_tasks_failed", key: "rest/report_chart", path: "0.dataColumns.1.dataPoints.0.value"},
+		{metric: "rubrik_report_tasks_canceled", key: "rest/report_chart", path: "0.dataColumns.2.dataPoints.0.value"},
+		{metric: "rubrik_node_network_receive_bytes_per_second", match: node, key: "rest/node_stats", path: "networkStat.bytesReceived.2.stat"},
+		{metric: "rubrik_node_network_receive_window_max_bytes_per_second", match: node, key: "rest/node_stats", path: "networkStat.bytesReceived", conv: windowMax},
+		{metric: "rubrik_node_network_receive_window_avg_bytes_per_second", match: node, key: "rest/node_stats", path: "networkStat.bytesReceived", conv: windowAvg},
+		{metric: "rubrik_node_network_transmit_bytes_per_second", match: node, key: "rest/node_stats", path: "networkStat.bytesTransmitted.2.stat"},
+		{metric: "rubrik_node_network_transmit_window_max_bytes_per_second", match: node, key: "rest/node_stats", path: "networkStat.bytesTransmitted", conv: windowMax},
+		{metric: "rubrik_node_network_transmit_window_avg_bytes_per_second", match: node, key: "rest/node_stats", path: "networkStat.bytesTransmitted", conv: windowAvg},
+		{metric: "rubrik_node_io_reads_per_second", match: node, key: "rest/node_stats", path: "iops.readsPerSecond.2.stat"},
+		{metric: "rubrik_node_io_reads_window_max_per_second", match: node, key: "rest/node_stats", path: "iops.readsPerSecond", conv: windowMax},
+		{metric: "rubrik_node_io_reads_window_avg_per_second", match: node, key: "rest/node_stats", path: "iops.readsPerSecond", conv: windowAvg},
+		{metric: "rubrik_node_io_writes_per_second", match: node, key: "rest/node_stats", path: "iops.writesPerSecond.2.stat"},
+		{metric: "rubrik_node_io_writes_window_max_per_second", match: node, key: "rest/node_stats", path: "iops.writesPerSecond", conv: windowMax},
+		{metric: "rubrik_node_io_writes_window_avg_per_second", match: node, key: "rest/node_stats", path: "iops.writesPerSecond", conv: windowAvg},
+		{metric: "rubrik_node_io_read_bytes_per_second", match: node, key: "rest/node_stats", path: "ioThroughput.readBytePerSecond.2.stat"},
+		{metric: "rubrik_node_io_read_window_max_bytes_per_second", match: node, key: "rest/node_stats", path: "ioThroughput.readBytePerSecond", conv: windowMax},
+		{metric: "rubrik_node_io_read_window_avg_bytes_per_second", match: node, key: "rest/node_stats", path: "ioThroughput.readBytePerSecond", conv: windowAvg},
+		{metric: "rubrik_node_io_write_bytes_per_second", match: node, key: "rest/node_stats", path: "ioThroughput.writeBytePerSecond.2.stat"},
+		{metric: "rubrik_node_io_write_window_max_bytes_per_second", match: node, key: "rest/node_stats", path: "ioThroughput.writeBytePerSecond", conv: windowMax},
+		{metric: "rubrik_node_io_write_window_avg_bytes_per_second", match: node, key: "rest/node_stats", path: "ioThroughput.writeBytePerSecond", conv: windowAvg},
+		{metric: "rubrik_node_io_operations_per_second", match: op("read"), key: "rest/node_stats", path: "iops.readsPerSecond", conv: length},
+		{metric: "rubrik_node_io_operations_per_second", match: op("write"), key: "rest/node_stats", path: "iops.writesPerSecond", conv: length},
+		{metric: "rubrik_node_io_throughput_bytes_per_second", match: op("read"), key: "rest/node_stats", path: "ioThroughput.readBytePerSecond", conv: length},
+		{metric: "rubrik_node_io_throughput_bytes_per_second", match: op("write"), key: "rest/node_stats", path: "ioThroughput.writeBytePerSecond", conv: length},
+		{metric: "rubrik_node_stats_last_timestamp_seconds", match: node, key: "rest/node_stats", path: "iops.readsPerSecond", conv: lastTime},
+	}
+}
+
+// graphQLMappings - The fields of the CDM GraphQL responses
+func graphQLMappings() []mapping {
+	vm := map[string]string{"vmid": mappedVM}
+	volume := map[string]string{"id": mappedVolume}
+	s3 := map[string]string{"name": "s3-archive"}
+	archived := func(kind string) map[string]string { return map[string]string{"name": "s3-archive", "type": kind} }
+	// PerVMStorage and VMwareVMs both list the VMware VMs
+	const (
+		vms   = "data.vmwareVms.edges.1.node."
+		mv    = "data.managedVolumes.edges.0.node."
+		usage = "data.archiveLocations.edges.0.node."
+	)
+	return append(sharedMappings(), []mapping{
+		{metric: "rubrik_vm_consumed_exclusive_bytes", match: vm, key: "graphql/PerVMStorage", path: vms + "exclusivePhysicalBytes"},
+		{metric: "rubrik_vm_consumed_index_storage_bytes", match: vm, key: "graphql/PerVMStorage", path: vms + "indexStorageBytes"},
+		{metric: "rubrik_vm_consumed_ingested_bytes", match: vm, key: "graphql/PerVMStorage", path: vms + "ingestedBytes"},
+		{metric: "rubrik_vm_consumed_logical_bytes", match: vm, key: "graphql/PerVMStorage", path: vms + "logicalBytes"},
+		{metric: "rubrik_vm_consumed_shared_physical_bytes", match: vm, key: "graphql/PerVMStorage", path: vms + "sharedPhysicalBytes"},
+		{metric: "rubrik_vm_protected", match: vm, key: "graphql/VMwareVMs", path: vms + "effectiveSlaDomain.id", conv: isNot("UNPROTECTED")},
+		{metric: "rubrik_vm_protected", match: map[string]string{"vmid": unprotected}, key: "graphql/VMwareVMs", path: "data.vmwareVms.edges.2.node.effectiveSlaDomain.id", conv: isNot("UNPROTECTED")},
+		{metric: "rubrik_vm_info", match: vm, label: "vmname", key: "graphql/VMwareVMs", path: vms + "name"},
+		{metric: "rubrik_vm_info", match: vm, label: "sla", key: "graphql/VMwareVMs", path: vms + "effectiveSlaDomain.name"},
+		{metric: "rubrik_vm_info", match: vm, label: "sla_id", key: "graphql/VMwareVMs", path: vms + "effectiveSlaDomain.id"},
+
+		{metric: "rubrik_managed_volume_snapshots", match: volume, key: "graphql/ManagedVolumes", path: mv + "snapshotCount"},
+		{metric: "rubrik_managed_volume_used_size_bytes", match: volume, key: "graphql/ManagedVolumes", path: mv + "usedSize"},
+		{metric: "rubrik_managed_volume_size_bytes", match: volume, key: "graphql/ManagedVolumes", path: mv + "volumeSize"},
+		{metric: "rubrik_managed_volume_info", match: volume, label: "name", key: "graphql/ManagedVolumes", path: mv + "name"},
+		{metric: "rubrik_managed_volume_info", match: volume, label: "state", key: "graphql/ManagedVolumes", path: mv + "state"},
+		{metric: "rubrik_managed_volume_info", match: volume, label: "sla", key: "graphql/ManagedVolumes", path: mv + "effectiveSlaDomainName"},
+
+		{metric: "rubrik_system_storage_size_bytes", key: "graphql/SystemStorage", path: "data.system.storage.total"},
+		{metric: "rubrik_system_storage_used_bytes", key: "graphql/SystemStorage", path: "data.system.storage.used"},
+		{metric: "rubrik_system_storage_available_bytes", key: "graphql/SystemStorage", path: "data.system.storage.available"},
+		{metric: "rubrik_system_storage_snapshot_bytes", key: "graphql/SystemStorage", path: "data.system.storage.snapshot"},
+		{metric: "rubrik_system_storage_live_mount_bytes", key: "graphql/SystemStorage", path: "data.system.storage.liveMount"},
+		{metric: "rubrik_system_storage_miscellaneous_bytes", key: "graphql/SystemStorage", path: "data.system.storage.miscellaneous"},
+		{metric: "rubrik_stat_runway_remaining_seconds", key: "graphql/RunwayRemaining", path: "data.system.runwayRemaining", conv: days},
+		{metric: "rubrik_stat_average_storage_growth_bytes_per_day", key: "graphql/AverageStorageGrowth", path: "data.system.averageStorageGrowthPerDay"},
+		{metric: "rubrik_count_streams", key: "graphql/StreamsCount", path: "data.system.streams.count"},
+		{metric: "rubrik_count_nodes", match: map[string]string{"brik": "RVM191S012340"}, key: "graphql/Nodes", path: "data.nodes", conv: length},
+		{metric: "rubrik_system_physical_ingest_bytes", key: "graphql/PhysicalIngestTimeSeries", path: "data.system.physicalIngest.timeSeries.2.value"},
+
+		{metric: "rubrik_archive_location_status", match: s3, key: "graphql/ArchiveLocations", path: "data.archiveLocations.edges.0.node.status", conv: is("CONNECTED")},
+		{metric: "rubrik_archive_storage_bandwidth_bytes_per_second", match: s3, key: "graphql/ArchivalBandwidthTimeSeries", path: "data.system.archivalBandwidth.timeSeries.2.value"},
+		{metric: "rubrik_archive_storage_bandwidth_window_max_bytes_per_second", match: s3, key: "graphql/ArchivalBandwidthTimeSeries", path: "data.system.archivalBandwidth.timeSeries", conv: windowMax},
+		{metric: "rubrik_archive_storage_bandwidth_window_avg_bytes_per_second", match: s3, key: "graphql/ArchivalBandwidthTimeSeries", path: "data.system.archivalBandwidth.timeSeries", conv: windowAvg},
+		{metric: "rubrik_archive_storage_data_archived_bytes_total", match: s3, key: "graphql/DataLocationUsage", path: usage + "dataArchived"},
+		{metric: "rubrik_archive_storage_data_downloaded_bytes_total", match: s3, key: "graphql/DataLocationUsage", path: usage + "dataDownloaded"},
+		{metric: "rubrik_archive_storage_archived_vm", match: archived("vmware"), key: "graphql/DataLocationUsage", path: usage + "numVMsArchived"},
+		{metric: "rubrik_archive_storage_archived_vm", match: archived("nutanix"), key: "graphql/DataLocationUsage", path: usage + "numNutanixVmsArchived"},
+		{metric: "rubrik_archive_storage_archived_vm", match: archived("hyperv"), key: "graphql/DataLocationUsage", path: usage + "numHypervVmsArchived"},
+		{metric: "rubrik_archive_storage_archived_fileset", match: archived("linux"), key: "graphql/DataLocationUsage", path: usage + "numLinuxFilesetsArchived"},
+		{metric: "rubrik_archive_storage_archived_fileset", match: archived("windows"), key: "graphql/DataLocationUsage", path: usage + "numWindowsFilesetsArchived"},
+		{metric: "rubrik_archive_storage_archived_fileset", match: archived("share"), key: "graphql/DataLocationUsage", path: usage + "numShareFilesetsArchived"},
+		{metric: "rubrik_archive_storage_archived_fileset", match: archived("fileset"), key: "graphql/DataLocationUsage", path: usage + "numFilesetsArchived"},
+	}...)
+}
+
+// restMappings - The fields of the CDM REST responses
+func restMappings() []mapping {
+	vm := map[string]string{"vmid": mappedVM}
+	volume := map[string]string{"id": mappedVolume}
+	s3 := map[string]string{"name": "s3-archive"}
+	archived := func(kind string) map[string]string { return map[string]string{"name": "s3-archive", "type": kind} }
+	return append(sharedMappings(), []mapping{
+		{metric: "rubrik_vm_consumed_exclusive_bytes", match: vm, key: "rest/per_vm_storage", path: "data.1.exclusivePhysicalBytes"},
+		{metric: "rubrik_vm_consumed_index_storage_bytes", match: vm, key: "rest/per_vm_storage", path: "data.1.indexStorageBytes"},
+		{metric: "rubrik_vm_consumed_ingested_bytes", match: vm, key: "rest/per_vm_storage", path: "data.1.ingestedBytes"},
+		{metric: "rubrik_vm_consumed_logical_bytes", match: vm, key: "rest/per_vm_storage", path: "data.1.logicalBytes"},
+		{metric: "rubrik_vm_consumed_shared_physical_bytes", match: vm, key: "rest/per_vm_storage", path: "data.1.sharedPhysicalBytes"},
+		{metric: "rubrik_vm_protected", match: vm, key: "rest/vmware_vm", path: "data.1.effectiveSlaDomainId", conv: isNot("UNPROTECTED")},
+		{metric: "rubrik_vm_protected", match: map[string]string{"vmid": unprotected}, key: "rest/vmware_vm", path: "data.2.effectiveSlaDomainId", conv: isNot("UNPROTECTED")},
+		{metric: "rubrik_vm_info", match: vm, label: "vmname", key: "rest/vmware_vm", path: "data.1.name"},
+		{metric: "rubrik_vm_info", match: vm, label: "sla", key: "rest/vmware_vm", path: "data.1.effectiveSlaDomainName"},
+		{metric: "rubrik_vm_info", match: vm, label: "sla_id", key: "rest/vmware_vm", path: "data.1.effectiveSlaDomainId"},
+
+		{metric: "rubrik_managed_volume_snapshots", match: volume, key: "rest/managed_volume", path: "data.0.snapshotCount"},
+		{metric: "rubrik_managed_volume_used_size_bytes", match: volume, key: "rest/managed_volume", path: "data.0.usedSize"},
+		{metric: "rubrik_managed_volume_size_bytes", match: volume, key: "rest/managed_volume", path: "data.0.volumeSize"},
+		{metric: "rubrik_managed_volume_info", match: volume, label: "name", key: "rest/managed_volume", path: "data.0.name"},
+		{metric: "rubrik_managed_volume_info", match: volume, label: "state", key: "rest/managed_volume", path: "data.0.state"},
+		{metric: "rubrik_managed_volume_info", match: volume, label: "sla", key: "rest/managed_volume", path: "data.0.effectiveSlaDomainName"},
+
+		{metric: "rubrik_system_storage_size_bytes", key: "rest/system_storage", path: "total"},
+		{metric: "rubrik_system_storage_used_bytes", key: "rest/system_storage", path: "used"},
+		{metric: "rubrik_system_storage_available_bytes", key: "rest/system_storage", path: "available"},
+		{metric: "rubrik_system_storage_snapshot_bytes", key: "rest/system_storage", path: "snapshot"},
+		{metric: "rubrik_system_storage_live_mount_bytes", key: "rest/system_storage", path: "liveMount"},
+		{metric: "rubrik_system_storage_miscellaneous_bytes", key: "rest/system_storage", path: "miscellaneous"},
+		{metric: "rubrik_stat_runway_remaining_seconds", key: "rest/runway_remaining", path: "days", conv: days},
+		{metric: "rubrik_stat_average_storage_growth_bytes_per_day", key: "rest/average_storage_growth", path: "bytes"},
+		{metric: "rubrik_count_streams", key: "rest/streams_count", path: "count"},
+		{metric: "rubrik_count_nodes", match: map[string]string{"brik": "RVM191S012340"}, key: "rest/node", path: "data", conv: length},
+		{metric: "rubrik_system_physical_ingest_bytes", key: "rest/physical_ingest", path: "2.stat"},
+
+		{metric: "rubrik_archive_location_status", match: s3, key: "rest/archive_location", path: "data.0.isActive"},
+		{metric: "rubrik_archive_location_status", match: map[string]string{"name": "nfs-archive"}, label: "bucket", key: "rest/archive_location", path: "data.1.bucket"},
+		{metric: "rubrik_archive_storage_bandwidth_bytes_per_second", match: s3, key: "rest/archival_bandwidth", path: "2.stat"},
+		{metric: "rubrik_archive_storage_bandwidth_window_max_bytes_per_second", match: s3, key: "rest/archival_bandwidth", path: "", conv: windowMax},
+		{metric: "rubrik_archive_storage_bandwidth_window_avg_bytes_per_second", match: s3, key: "rest/archival_bandwidth", path: "", conv: windowAvg},
+		{metric: "rubrik_archive_storage_data_archived_bytes_total", match: s3, key: "rest/data_location_usage", path: "data.0.dataArchived"},
+		{metric: "rubrik_archive_storage_data_downloaded_bytes_total", match: s3, key: "rest/data_location_usage", path: "data.0.dataDownloaded"},
+		{metric: "rubrik_archive_storage_archived_vm", match: archived("vmware"), key: "rest/data_location_usage", path: "data.0.numVMsArchived"},
+		{metric: "rubrik_archive_storage_archived_vm", match: archived("nutanix"), key: "rest/data_location_usage", path: "data.0.numNutanixVmsArchived"},
+		{metric: "rubrik_archive_storage_archived_vm", match: archived("hyperv"), key: "rest/data_location_usage", path: "data.0.numHypervVmsArchived"},
+		{metric: "rubrik_archive_storage_archived_fileset", match: archived("linux"), key: "rest/data_location_usage", path: "data.0.numLinuxFilesetsArchived"},
+		{metric: "rubrik_archive_storage_archived_fileset", match: archived("windows"), key: "rest/data_location_usage", path: "data.0.numWindowsFilesetsArchived"},
+		{metric: "rubrik_archive_storage_archived_fileset", match: archived("share"), key: "rest/data_location_usage", path: "data.0.numShareFilesetsArchived"},
+		{metric: "rubrik_archive_storage_archived_fileset", match: archived("fileset"), key: "rest/data_location_usage", path: "data.0.numFilesetsArchived"},
+	}...)
+}
+
+// TestFieldMapping - Every exported value comes from the right field of the
+// GraphQL and the REST responses
+func TestFieldMapping(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		graphql  bool
+		mappings []mapping
+	}{
+		{"graphql", true, graphQLMappings()},
+		{"rest", false, restMappings()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := rubriktest.NewServer()
+			defer srv.Close()
+			if !tc.graphql {
+				srv.Inject("graphql", rubriktest.NotFound)
+			}
+			rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+
+			families, err := gatherCollectors(context.Background(), newCollectors(collectorConfig{}), newCoalescer(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Every metric of the collectors has a mapping
+			mapped := make(map[string]bool)
+			for _, m := range tc.mappings {
+				mapped[m.metric] = true
+			}
+			for _, mf := range families {
+				if name := mf.GetName(); strings.HasPrefix(name, "rubrik_") && !strings.HasPrefix(name, "rubrik_scrape_") &&
+					!strings.HasPrefix(name, "rubrik_api_") && !mapped[name] {
+					t.Errorf("%s has no mapping", name)
+				}
+			}
+
+			for _, m := range tc.mappings {
+				if srv.Requests(m.key) == 0 {
+					t.Errorf("%s: %s was not requested", m.metric, m.key)
+					continue
+				}
+				field, err := fixtureField(srv.Fixture(m.key), m.path)
+				if err != nil {
+					t.Errorf("%s: %s %s: %v", m.metric, m.key, m.path, err)
+					continue
+				}
+				metric := findMetric(families, m.metric, m.match)
+				if metric == nil {
+					t.Errorf("%s%v not exported", m.metric, m.match)
+					continue
+				}
+
+				if m.label != "" {
+					for _, l := range metric.GetLabel() {
+						if l.GetName() == m.label && l.GetValue() != field {
+							t.Errorf("%s%v: label %s = %q, want %q from %s %s", m.metric, m.match, m.label, l.GetValue(), field, m.key, m.path)
+						}
+					}
+					continue
+				}
+				var want float64
+				switch v := field.(type) {
+				case float64:
+					want = v
+				case bool:
+					want = boolValue(v)
+				}
+				if m.conv != nil {
+					want = m.conv(field)
+				}
+				if got := metricValue(metric); got != want {
+					t.Errorf("%s%v = %g, want %g from %s %s", m.metric, m.match, got, want, m.key, m.path)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Versions of the metric schema. Version 2 names the metrics with their
+// units and exports cumulative values as counters, version 1 are the names
+// before that, kept with -compat.legacy-metric-names.
+const (
+	schemaLegacy  = 1
+	schemaCurrent = 2
+)
+
+// metricSchema - Picks the name and type of a metric in the schema in use
+type metricSchema struct {
+	legacy bool
+}
+
+// version ...
+func (s metricSchema) version() int {
+	if s.legacy {
+		return schemaLegacy
+	}
+	return schemaCurrent
+}
+
+// name - The current name, or the legacy one with -compat.legacy-metric-names
+func (s metricSchema) name(current, legacy string) string {
+	if s.legacy {
+		return legacy
+	}
+	return current
+}
+
+// windowName - The name of the maximum or average of a series over its
+// window. The stat goes before the unit, e.g.
+// node_io_reads_window_max_per_second; the legacy names have no unit, it is
+// appended to them.
+func (s metricSchema) windowName(current, legacy, stat string) string {
+	if s.legacy {
+		return legacy + "_window_" + stat
+	}
+	for _, unit := range []string{"_bytes_per_second", "_per_second"} {
+		if base, ok := strings.CutSuffix(current, unit); ok {
+			return base + "_window_" + stat + unit
+		}
+	}
+	return current + "_window_" + stat
+}
+
+// counter - Cumulative values were gauges in the legacy schema
+func (s metricSchema) counter() prometheus.ValueType {
+	if s.legacy {
+		return prometheus.GaugeValue
+	}
+	return prometheus.CounterValue
+}
+
+var metricSchemaInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace, Name: "metric_schema_info",
+	Help: "Version of the metric names and types the exporter uses, 1 with -compat.legacy-metric-names",
+}, []string{"version"})
+
+// setInfo - Export the version in rubrik_metric_schema_info
+func (s metricSchema) setInfo() {
+	metricSchemaInfo.Reset()
+	metricSchemaInfo.WithLabelValues(strconv.Itoa(s.version())).Set(1)
+}
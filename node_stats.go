@@ -0,0 +1,173 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// nodeSeries - One time series of the node stats. The node stats cover the
+// last 10 minutes, exported as the latest point, the maximum and average
+// over them, and for IO in a histogram of every point.
+type nodeSeries struct {
+	// key tells the series apart in nodeStatsSeen
+	key      string
+	latest   *prometheus.Desc
+	max, avg *prometheus.Desc
+	// histogram with the label op, nil for the network series
+	histogram *prometheus.HistogramVec
+	op        string
+	points    func(rubrik.NodeStat) []rubrik.TimeStat
+}
+
+// newNodeSeries - The series of the node stats, named by the schema of e
+func newNodeSeries(e *RubrikStats) []nodeSeries {
+	series := func(key, current, legacy, help string, histogram *prometheus.HistogramVec, op string, points func(rubrik.NodeStat) []rubrik.TimeStat) nodeSeries {
+		return nodeSeries{
+			key: key, histogram: histogram, op: op, points: points,
+			latest: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", e.schema.name(current, legacy)),
+				help, []string{"cluster", "node"}, nil),
+			max: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", e.schema.windowName(current, legacy, "max")),
+				help+", maximum over the last 10 minutes", []string{"cluster", "node"}, nil),
+			avg: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", e.schema.windowName(current, legacy, "avg")),
+				help+", average over the last 10 minutes", []string{"cluster", "node"}, nil),
+		}
+	}
+	return []nodeSeries{
+		series("network_received", "node_network_receive_bytes_per_second", "node_network_received",
+			"Bytes per second the node received over the network", nil, "",
+			func(s rubrik.NodeStat) []rubrik.TimeStat { return s.NetworkStat.BytesReceived }),
+		series("network_transmitted", "node_network_transmit_bytes_per_second", "node_network_transmitted",
+			"Bytes per second the node transmitted over the network", nil, "",
+			func(s rubrik.NodeStat) []rubrik.TimeStat { return s.NetworkStat.BytesTransmitted }),
+		series("io_read", "node_io_reads_per_second", "node_io_read",
+			"Read operations per second of the node", e.NodeIOPS, "read",
+			func(s rubrik.NodeStat) []rubrik.TimeStat { return s.Iops.ReadsPerSecond }),
+		series("io_write", "node_io_writes_per_second", "node_io_write",
+			"Write operations per second of the node", e.NodeIOPS, "write",
+			func(s rubrik.NodeStat) []rubrik.TimeStat { return s.Iops.WritesPerSecond }),
+		series("throughput_read", "node_io_read_bytes_per_second", "node_throughput_read",
+			"Bytes per second read by the node", e.NodeThroughput, "read",
+			func(s rubrik.NodeStat) []rubrik.TimeStat { return s.IOThroughput.ReadBytePerSecond }),
+		series("throughput_write", "node_io_write_bytes_per_second", "node_throughput_write",
+			"Bytes per second written by the node", e.NodeThroughput, "write",
+			func(s rubrik.NodeStat) []rubrik.TimeStat { return s.IOThroughput.WriteBytePerSecond }),
+	}
+}
+
+// collectNodeStats - Export the window of every series of a node. The
+// histograms only observe the points newer than in the last scrape.
+func (e *RubrikStats) collectNodeStats(ch chan<- prometheus.Metric, cluster, node string, stat rubrik.NodeStat) {
+	var newest time.Time
+	for _, s := range e.nodeSeries {
+		points := s.points(stat)
+		if len(points) == 0 {
+			continue
+		}
+		w := summarizeWindow(points)
+		ch <- prometheus.MustNewConstMetric(s.latest, prometheus.GaugeValue, w.latest, cluster, node)
+		ch <- prometheus.MustNewConstMetric(s.max, prometheus.GaugeValue, w.max, cluster, node)
+		ch <- prometheus.MustNewConstMetric(s.avg, prometheus.GaugeValue, w.avg, cluster, node)
+		if w.newest.After(newest) {
+			newest = w.newest
+		}
+
+		if s.histogram == nil {
+			continue
+		}
+		h := s.histogram.WithLabelValues(cluster, node, s.op)
+		for _, p := range e.nodeStatsSeen.newPoints(cluster+"/"+node+"/"+s.key, points) {
+			h.Observe(float64(p.Stat))
+		}
+		h.(prometheus.Histogram).Collect(ch)
+	}
+	if !newest.IsZero() {
+		ch <- prometheus.MustNewConstMetric(e.NodeStatsTime, prometheus.GaugeValue, float64(newest.Unix()), cluster, node)
+	}
+}
+
+// statWindow - The points of one time series over its window, e.g. the
+// last 10 minutes of the node stats or the archival bandwidth
+type statWindow struct {
+	latest, max, avg float64
+	// newest is the time of the latest point, zero if no time parsed
+	newest time.Time
+}
+
+// summarizeWindow - Points without a parsable time are used for the maximum
+// and average, the latest point is the newest by time or else the last one
+func summarizeWindow(points []rubrik.TimeStat) statWindow {
+	var w statWindow
+	if len(points) == 0 {
+		return w
+	}
+	w.latest = float64(points[len(points)-1].Stat)
+	w.max = float64(points[0].Stat)
+	sum := 0.0
+	for _, p := range points {
+		v := float64(p.Stat)
+		sum += v
+		w.max = max(w.max, v)
+		if t, err := p.Timestamp(); err == nil && t.After(w.newest) {
+			w.newest = t
+			w.latest = v
+		}
+	}
+	w.avg = sum / float64(len(points))
+	return w
+}
+
+// nodeStatsSeen - The time of the newest point of every node series that
+// went into a histogram. The windows of consecutive scrapes overlap, a point
+// is only observed once.
+type nodeStatsSeen struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// newPoints - The points of the series key newer than those of the last
+// call. Points without a parsable time are never observed.
+func (s *nodeStatsSeen) newPoints(key string, points []rubrik.TimeStat) []rubrik.TimeStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := s.last[key]
+	newest := last
+	var fresh []rubrik.TimeStat
+	for _, p := range points {
+		t, err := p.Timestamp()
+		if err != nil || !t.After(last) {
+			continue
+		}
+		fresh = append(fresh, p)
+		if t.After(newest) {
+			newest = t
+		}
+	}
+	s.last[key] = newest
+	return fresh
+}
+
+// newNodeHistogram - A native histogram of the node points, with classic
+// buckets for servers without native histogram support
+func newNodeHistogram(name, help string, buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Name: name, Help: help,
+		Buckets:                         buckets,
+		NativeHistogramBucketFactor:     1.1,
+		NativeHistogramMaxBucketNumber:  160,
+		NativeHistogramMinResetDuration: time.Hour,
+	}, []string{"cluster", "node", "op"})
+}
@@ -0,0 +1,131 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubriktest"
+)
+
+func TestNodeStatsWindow(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+	collectors := newCollectors(collectorConfig{})
+
+	node := map[string]string{"node": mappedNode}
+	read := map[string]string{"node": mappedNode, "op": "read"}
+	check := func(step string, want map[string]float64) {
+		t.Helper()
+		families, err := gatherCollectors(context.Background(), collectors, newCoalescer(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, v := range want {
+			match := node
+			if name == "rubrik_node_io_operations_per_second" {
+				match = read
+			}
+			m := findMetric(families, name, match)
+			if m == nil {
+				t.Errorf("%s: %s not exported", step, name)
+			} else if got := metricValue(m); got != v {
+				t.Errorf("%s: %s = %g, want %g", step, name, got, v)
+			}
+		}
+	}
+
+	// The windows overlap, the points of the first scrape are observed once
+	first := map[string]float64{
+		"rubrik_node_io_reads_per_second":            150,
+		"rubrik_node_io_reads_window_max_per_second": 180,
+		"rubrik_node_io_reads_window_avg_per_second": 150,
+		"rubrik_node_io_operations_per_second":       3,
+		"rubrik_node_stats_last_timestamp_seconds":   float64(time.Date(2026, 10, 18, 10, 10, 0, 0, time.UTC).Unix()),
+	}
+	check("first scrape", first)
+	check("same window", first)
+
+	var stats map[string]any
+	if err := json.Unmarshal(srv.Fixture("rest/node_stats"), &stats); err != nil {
+		t.Fatal(err)
+	}
+	stats["iops"].(map[string]any)["readsPerSecond"] = []map[string]any{
+		{"time": "2026-10-18T10:05:00.000Z", "stat": 180},
+		{"time": "2026-10-18T10:15:00.000Z", "stat": 90},
+		{"time": "2026-10-18T10:10:00.000Z", "stat": 150},
+		{"time": "not a time", "stat": 60},
+	}
+	body, err := json.Marshal(stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetFixture("rest/node_stats", body)
+
+	// Only the point at 10:15 is new, the latest is the newest by time
+	check("next window", map[string]float64{
+		"rubrik_node_io_reads_per_second":            90,
+		"rubrik_node_io_reads_window_max_per_second": 180,
+		"rubrik_node_io_reads_window_avg_per_second": 120,
+		"rubrik_node_io_operations_per_second":       4,
+		"rubrik_node_stats_last_timestamp_seconds":   float64(time.Date(2026, 10, 18, 10, 15, 0, 0, time.UTC).Unix()),
+	})
+}
+
+func TestArchiveAndIngestWindow(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+
+	// The newest point comes first, neither the first nor the last is latest
+	series := func(field string, values ...int) []byte {
+		times := []string{"2026-10-18T10:10:00.000Z", "2026-10-18T10:00:00.000Z", "2026-10-18T10:05:00.000Z"}
+		points := make([]map[string]any, len(values))
+		for i, v := range values {
+			points[i] = map[string]any{"date": times[i], "value": v}
+		}
+		body, err := json.Marshal(map[string]any{"data": map[string]any{"system": map[string]any{field: map[string]any{"timeSeries": points}}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+	srv.SetFixture("graphql/ArchivalBandwidthTimeSeries", series("archivalBandwidth", 300, 100, 500))
+	srv.SetFixture("graphql/PhysicalIngestTimeSeries", series("physicalIngest", 7000, 9000, 8000))
+
+	families, err := gatherCollectors(context.Background(), newCollectors(collectorConfig{}), newCoalescer(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3 := map[string]string{"name": "s3-archive"}
+	for _, tc := range []struct {
+		name  string
+		match map[string]string
+		want  float64
+	}{
+		{"rubrik_archive_storage_bandwidth_bytes_per_second", s3, 300},
+		{"rubrik_archive_storage_bandwidth_window_max_bytes_per_second", s3, 500},
+		{"rubrik_archive_storage_bandwidth_window_avg_bytes_per_second", s3, 300},
+		{"rubrik_system_physical_ingest_bytes", nil, 7000},
+	} {
+		m := findMetric(families, tc.name, tc.match)
+		if m == nil {
+			t.Errorf("%s not exported", tc.name)
+		} else if got := metricValue(m); got != tc.want {
+			t.Errorf("%s = %g, want %g", tc.name, got, tc.want)
+		}
+	}
+}
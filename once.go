@@ -0,0 +1,134 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// runOnce - Run every collector once and write the metrics in text format
+// to path, or to stdout if path is empty. Returns the exit code, 1 if a
+// collector failed.
+func runOnce(ctx context.Context, collectors map[string]Collector, path string) int {
+	code := 0
+	families, err := gatherCollectors(ctx, collectors, newCoalescer(0))
+	if err != nil {
+		slog.ErrorContext(ctx, "Collecting the metrics failed", "err", err)
+		code = 1
+	}
+
+	write := func(w io.Writer) error {
+		for _, mf := range families {
+			if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if path == "" {
+		if err := write(os.Stdout); err != nil {
+			slog.Error("Writing metrics failed", "err", err)
+			return 1
+		}
+		return code
+	}
+	if err := writeFileAtomic(path, write); err != nil {
+		slog.Error("Writing metrics failed", "file", path, "err", err)
+		return 1
+	}
+	slog.Info("Wrote metrics", "file", path, "families", len(families))
+	return code
+}
+
+// gatherCollectors - Run every collector once outside of a scrape. Only the
+// rubrik_ metrics are gathered, the Go runtime metrics of the exporter
+// process would clash with those of node_exporter or of other jobs. The
+// error names the collectors that failed, the metrics of all the others
+// are returned anyway.
+func gatherCollectors(ctx context.Context, collectors map[string]Collector, coalescer *coalescer, extra ...prometheus.Collector) ([]*dto.MetricFamily, error) {
+	ctx, span := tracing.Start(ctx, "gather", tracing.KindInternal)
+	defer span.Finish()
+	ctx = withScrapeClusters(ctx)
+
+	registry := prometheus.NewRegistry()
+	rubrik.RegisterMetrics(registry)
+	registry.MustRegister(coalescedScrapes, droppedSeries, metricSchemaInfo)
+	registry.MustRegister(extra...)
+
+	for name, c := range collectors {
+		registry.MustRegister(scrapeCollector{ctx: ctx, name: name, collector: c, coalescer: coalescer})
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		return families, fmt.Errorf("gathering metrics failed: %v", err)
+	}
+
+	var failed []string
+	for _, mf := range families {
+		if mf.GetName() != prometheus.BuildFQName(namespace, "scrape", "collector_success") {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if m.GetGauge().GetValue() == 0 {
+				failed = append(failed, m.GetLabel()[0].GetValue())
+			}
+		}
+	}
+	if len(failed) > 0 {
+		err := fmt.Errorf("collectors failed: %s", strings.Join(failed, ", "))
+		span.RecordError(err)
+		return families, err
+	}
+	return families, nil
+}
+
+// writeFileAtomic - Write a temporary file next to path and rename it, so
+// readers like the node_exporter textfile collector never see a partial
+// file. The temporary name doesn't end in .prom to be ignored by them.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	// CreateTemp creates the file readable by the owner only
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,53 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubriktest"
+)
+
+func TestOnce(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject("graphql", rubriktest.NotFound)
+	srv.Inject("rest/managed_volume", rubriktest.ServerError)
+
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rubrik.prom")
+	if code := runOnce(context.Background(), newCollectors(collectorConfig{}), path); code != 1 {
+		t.Errorf("runOnce() = %d with a failing collector, want 1", code)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `rubrik_scrape_collector_success{collector="managed_volume"} 0`) {
+		t.Errorf("%s doesn't report the failed collector:\n%s", path, data)
+	}
+	if strings.Contains(string(data), "go_goroutines") {
+		t.Errorf("%s contains the Go runtime metrics", path)
+	}
+
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("%d files left in the output directory, want 1", len(files))
+	}
+}
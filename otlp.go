@@ -0,0 +1,225 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// OTLP protocols
+const (
+	otlpProtocolGRPC = "grpc"
+	otlpProtocolHTTP = "http/protobuf"
+)
+
+// otlpHTTPPaths - The path of the HTTP endpoint of the OTLP signals
+var otlpHTTPPaths = map[string]string{
+	"metrics": "/v1/metrics",
+	"traces":  "/v1/traces",
+}
+
+var otlpExports = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace, Subsystem: "otlp", Name: "exports_total",
+	Help: "OTLP metric exports by result: success or failure",
+}, []string{"result"})
+
+// otlpConfig - Where and how often the metrics are exported with OTLP
+type otlpConfig struct {
+	endpoint string
+	protocol string
+	interval time.Duration
+	headers  map[string]string
+}
+
+// otlpExporter - Sends the metrics of the collectors to an OpenTelemetry
+// collector. It shares the coalescer with the scrapes, so an export right
+// after a scrape doesn't query Rubrik again.
+type otlpExporter struct {
+	config     otlpConfig
+	collectors map[string]Collector
+	coalescer  *coalescer
+	exporter   sdkmetric.Exporter
+	url        string
+	start      time.Time
+}
+
+// newOTLPExporter ...
+func newOTLPExporter(config otlpConfig, collectors map[string]Collector, coalescer *coalescer) (*otlpExporter, error) {
+	u, err := otlpURL(config.endpoint, config.protocol, "metrics")
+	if err != nil {
+		return nil, err
+	}
+	var exporter sdkmetric.Exporter
+	if config.protocol == otlpProtocolGRPC {
+		exporter, err = otlpmetricgrpc.New(context.Background(),
+			otlpmetricgrpc.WithEndpointURL(u),
+			otlpmetricgrpc.WithHeaders(config.headers),
+			otlpmetricgrpc.WithTimeout(config.interval))
+	} else {
+		exporter, err = otlpmetrichttp.New(context.Background(),
+			otlpmetrichttp.WithEndpointURL(u),
+			otlpmetrichttp.WithHeaders(config.headers),
+			otlpmetrichttp.WithTimeout(config.interval))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &otlpExporter{
+		config:     config,
+		collectors: collectors,
+		coalescer:  coalescer,
+		exporter:   exporter,
+		url:        u,
+		start:      time.Now(),
+	}, nil
+}
+
+// run - Export every interval until ctx ends. The exporter retries
+// temporary errors within the interval, a failed export isn't repeated
+// after that, the next one carries the cumulative values anyway.
+func (e *otlpExporter) run(ctx context.Context) {
+	slog.Info("Exporting metrics with OTLP", "protocol", e.config.protocol, "url", e.url, "interval", e.config.interval)
+
+	defer e.exporter.Shutdown(context.WithoutCancel(ctx))
+	ticker := time.NewTicker(e.config.interval)
+	defer ticker.Stop()
+	for {
+		if err := e.export(ctx); err != nil {
+			slog.Error("OTLP export failed", "err", err)
+			otlpExports.WithLabelValues("failure").Inc()
+		} else {
+			otlpExports.WithLabelValues("success").Inc()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// export - Collect and send the metrics once, a request per resource
+func (e *otlpExporter) export(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, e.config.interval)
+	defer cancel()
+	ctx = withScrapeClusters(ctx)
+
+	now := time.Now()
+	families, err := gatherCollectors(ctx, e.collectors, e.coalescer, otlpExports)
+	if err != nil {
+		slog.ErrorContext(ctx, "Collecting the metrics failed", "err", err)
+	}
+	clusters, err := getClusters(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Can't look up the clusters for the OTLP resources", "err", err)
+	}
+
+	var errs []error
+	for _, rm := range otlpResourceMetrics(families, clusters, e.start, now) {
+		if err := e.exporter.Export(ctx, rm); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// startTracing - Record spans and export them with OTLP until the returned
+// function is called, which sends the remaining ones
+func startTracing(endpoint, protocol string, ratio float64, headers map[string]string) (func(), error) {
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("sampling ratio %g is not between 0 and 1", ratio)
+	}
+	u, err := otlpURL(endpoint, protocol, "traces")
+	if err != nil {
+		return nil, err
+	}
+	var exporter *otlptrace.Exporter
+	if protocol == otlpProtocolGRPC {
+		exporter, err = otlptracegrpc.New(context.Background(), otlptracegrpc.WithEndpointURL(u), otlptracegrpc.WithHeaders(headers))
+	} else {
+		exporter, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(u), otlptracehttp.WithHeaders(headers))
+	}
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Sending traces with OTLP", "protocol", protocol, "url", u, "sampling_ratio", ratio)
+
+	tracer := tracing.NewTracer(ratio, exporter)
+	tracing.SetTracer(tracer)
+	return func() {
+		// Shutdown, give the last batch a moment
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tracer.Shutdown(ctx); err != nil {
+			slog.Warn("Exporting spans failed", "err", err)
+		}
+	}, nil
+}
+
+// otlpURL - The endpoint is the base URL of the collector, http:// if it
+// has no scheme. For HTTP the path of the signal is appended unless it is
+// already there.
+func otlpURL(endpoint, protocol, signal string) (string, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid OTLP endpoint: %v", err)
+	}
+
+	switch protocol {
+	case otlpProtocolHTTP:
+		if path := otlpHTTPPaths[signal]; !strings.HasSuffix(u.Path, path) {
+			u.Path = strings.TrimSuffix(u.Path, "/") + path
+		}
+	case otlpProtocolGRPC:
+	default:
+		return "", fmt.Errorf("unknown OTLP protocol %q, use %s or %s", protocol, otlpProtocolGRPC, otlpProtocolHTTP)
+	}
+	return u.String(), nil
+}
+
+// parseOTLPHeaders - Parse key=value pairs separated by commas, like
+// OTEL_EXPORTER_OTLP_HEADERS
+func parseOTLPHeaders(s string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid OTLP header %q, expected key=value", pair)
+		}
+		if decoded, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+			value = decoded
+		}
+		headers[strings.TrimSpace(key)] = value
+	}
+	return headers, nil
+}
@@ -0,0 +1,200 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/tracing"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// otlpResourceMetrics - Convert metric families to OTLP resource metrics.
+// The metrics of every cluster go to a resource carrying its ID, name and
+// version, their cluster label is dropped. Metrics about the exporter
+// itself go to a resource of their own. Counters, histograms and summaries
+// are cumulative since start.
+func otlpResourceMetrics(families []*dto.MetricFamily, clusters []rubrik.Cluster, start, now time.Time) []*metricdata.ResourceMetrics {
+	// Metrics by cluster name, "" for the exporter
+	byCluster := map[string][]metricdata.Metrics{}
+	for _, mf := range families {
+		metrics := map[string][]*dto.Metric{}
+		for _, m := range mf.GetMetric() {
+			cluster := ""
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "cluster" {
+					cluster = l.GetValue()
+				}
+			}
+			metrics[cluster] = append(metrics[cluster], m)
+		}
+		for cluster, list := range metrics {
+			byCluster[cluster] = append(byCluster[cluster], otlpMetric(mf, list, start, now))
+		}
+	}
+
+	names := make([]string, 0, len(byCluster))
+	for name := range byCluster {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resources := make([]*metricdata.ResourceMetrics, 0, len(names))
+	for _, name := range names {
+		attrs := []attribute.KeyValue{attribute.String("service.name", "rubrik-exporter")}
+		if name != "" {
+			attrs = append(attrs, attribute.String("rubrik.cluster.name", name))
+			for _, c := range clusters {
+				if c.Name == name {
+					attrs = append(attrs, attribute.String("rubrik.cluster.id", c.ID))
+					if c.Version != "" {
+						attrs = append(attrs, attribute.String("rubrik.cluster.version", c.Version))
+					}
+				}
+			}
+		}
+		resources = append(resources, &metricdata.ResourceMetrics{
+			Resource: resource.NewSchemaless(attrs...),
+			ScopeMetrics: []metricdata.ScopeMetrics{{
+				Scope:   instrumentation.Scope{Name: tracing.Scope},
+				Metrics: byCluster[name],
+			}},
+		})
+	}
+	return resources
+}
+
+// otlpMetric - Convert the given metrics of a family
+func otlpMetric(mf *dto.MetricFamily, metrics []*dto.Metric, start, now time.Time) metricdata.Metrics {
+	out := metricdata.Metrics{
+		Name:        mf.GetName(),
+		Description: mf.GetHelp(),
+		Unit:        otlpUnit(mf.GetName()),
+	}
+
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		sum := metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+		for _, m := range metrics {
+			sum.DataPoints = append(sum.DataPoints, metricdata.DataPoint[float64]{
+				Attributes: otlpAttributes(m),
+				StartTime:  start,
+				Time:       otlpTime(m, now),
+				Value:      m.GetCounter().GetValue(),
+			})
+		}
+		out.Data = sum
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		histogram := metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality}
+		for _, m := range metrics {
+			histogram.DataPoints = append(histogram.DataPoints, otlpHistogramPoint(m, start, now))
+		}
+		out.Data = histogram
+	case dto.MetricType_SUMMARY:
+		var summary metricdata.Summary
+		for _, m := range metrics {
+			s := m.GetSummary()
+			p := metricdata.SummaryDataPoint{
+				Attributes: otlpAttributes(m),
+				StartTime:  start,
+				Time:       otlpTime(m, now),
+				Count:      s.GetSampleCount(),
+				Sum:        s.GetSampleSum(),
+			}
+			for _, q := range s.GetQuantile() {
+				p.QuantileValues = append(p.QuantileValues, metricdata.QuantileValue{Quantile: q.GetQuantile(), Value: q.GetValue()})
+			}
+			summary.DataPoints = append(summary.DataPoints, p)
+		}
+		out.Data = summary
+	default:
+		var gauge metricdata.Gauge[float64]
+		for _, m := range metrics {
+			value := m.GetGauge().GetValue()
+			if mf.GetType() == dto.MetricType_UNTYPED {
+				value = m.GetUntyped().GetValue()
+			}
+			gauge.DataPoints = append(gauge.DataPoints, metricdata.DataPoint[float64]{
+				Attributes: otlpAttributes(m),
+				Time:       otlpTime(m, now),
+				Value:      value,
+			})
+		}
+		out.Data = gauge
+	}
+	return out
+}
+
+// otlpHistogramPoint - Prometheus buckets are cumulative, OTLP counts every
+// bucket on its own and has no +Inf bound
+func otlpHistogramPoint(m *dto.Metric, start, now time.Time) metricdata.HistogramDataPoint[float64] {
+	h := m.GetHistogram()
+	p := metricdata.HistogramDataPoint[float64]{
+		Attributes: otlpAttributes(m),
+		StartTime:  start,
+		Time:       otlpTime(m, now),
+		Count:      h.GetSampleCount(),
+		Sum:        h.GetSampleSum(),
+	}
+
+	var previous uint64
+	for _, bucket := range h.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), +1) {
+			continue
+		}
+		p.Bounds = append(p.Bounds, bucket.GetUpperBound())
+		p.BucketCounts = append(p.BucketCounts, bucket.GetCumulativeCount()-previous)
+		previous = bucket.GetCumulativeCount()
+	}
+	p.BucketCounts = append(p.BucketCounts, h.GetSampleCount()-previous)
+	return p
+}
+
+// otlpAttributes - The labels of m except cluster, which is a resource
+// attribute
+func otlpAttributes(m *dto.Metric) attribute.Set {
+	var attrs []attribute.KeyValue
+	for _, l := range m.GetLabel() {
+		if l.GetName() == "cluster" {
+			continue
+		}
+		attrs = append(attrs, attribute.String(l.GetName(), l.GetValue()))
+	}
+	return attribute.NewSet(attrs...)
+}
+
+// otlpTime - The timestamp of m, now if it has none
+func otlpTime(m *dto.Metric, now time.Time) time.Time {
+	if m.TimestampMs != nil {
+		return time.UnixMilli(m.GetTimestampMs())
+	}
+	return now
+}
+
+// otlpUnit - UCUM unit from the suffix of a Prometheus metric name
+func otlpUnit(name string) string {
+	name = strings.TrimSuffix(name, "_total")
+	switch {
+	case strings.HasSuffix(name, "_seconds"):
+		return "s"
+	case strings.HasSuffix(name, "_bytes"):
+		return "By"
+	}
+	return ""
+}
@@ -0,0 +1,322 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubriktest"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/tracing"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpCollector - Receives OTLP metric exports with gRPC and HTTP, they
+// need the header x-tenant: lab
+type otlpCollector struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+
+	mu        sync.Mutex
+	resources []*metricpb.ResourceMetrics
+}
+
+func (c *otlpCollector) Export(ctx context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	if md, _ := metadata.FromIncomingContext(ctx); len(md.Get("x-tenant")) != 1 || md.Get("x-tenant")[0] != "lab" {
+		return nil, status.Error(codes.Unauthenticated, "no tenant")
+	}
+	c.mu.Lock()
+	c.resources = append(c.resources, req.GetResourceMetrics()...)
+	c.mu.Unlock()
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+// start - Serve the protocol, returns the endpoint
+func (c *otlpCollector) start(t *testing.T, protocol string) string {
+	t.Helper()
+	if protocol == otlpProtocolGRPC {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := grpc.NewServer()
+		colmetricpb.RegisterMetricsServiceServer(srv, c)
+		go srv.Serve(lis)
+		t.Cleanup(srv.Stop)
+		return "http://" + lis.Addr().String()
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req colmetricpb.ExportMetricsServiceRequest
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/x-protobuf" || proto.Unmarshal(body, &req) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		ctx := metadata.NewIncomingContext(r.Context(), metadata.Pairs("x-tenant", r.Header.Get("X-Tenant")))
+		if _, err := c.Export(ctx, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// otlpStrings - Attributes with string values by key
+func otlpStrings(attrs []*commonpb.KeyValue) map[string]string {
+	values := make(map[string]string, len(attrs))
+	for _, kv := range attrs {
+		values[kv.GetKey()] = kv.GetValue().GetStringValue()
+	}
+	return values
+}
+
+func TestOTLPExport(t *testing.T) {
+	for _, protocol := range []string{otlpProtocolHTTP, otlpProtocolGRPC} {
+		t.Run(protocol, func(t *testing.T) {
+			srv := rubriktest.NewServer()
+			defer srv.Close()
+			rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+
+			collector := &otlpCollector{}
+			endpoint := collector.start(t, protocol)
+			exporter, err := newOTLPExporter(otlpConfig{endpoint: endpoint, protocol: protocol, interval: 10 * time.Second, headers: map[string]string{"x-tenant": "lab"}}, newCollectors(collectorConfig{}), newCoalescer(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer exporter.exporter.Shutdown(context.Background())
+			if err := exporter.export(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			// ResourceMetrics by cluster name
+			resources := map[string]*metricpb.ResourceMetrics{}
+			for _, rm := range collector.resources {
+				attrs := otlpStrings(rm.GetResource().GetAttributes())
+				resources[attrs["rubrik.cluster.name"]] = rm
+				if name := attrs["rubrik.cluster.name"]; name != "" {
+					want := map[string]string{
+						"service.name":           "rubrik-exporter",
+						"rubrik.cluster.name":    "cdm-lab-01",
+						"rubrik.cluster.id":      "5f0c6e2a-8a3d-4b61-9d3e-1c2b3a4d5e6f",
+						"rubrik.cluster.version": "9.1.2-p3-29102",
+					}
+					if !maps.Equal(attrs, want) {
+						t.Errorf("resource attributes %v, want %v", attrs, want)
+					}
+				}
+			}
+			cluster, ok := resources["cdm-lab-01"]
+			if !ok {
+				t.Fatalf("no resource of cdm-lab-01, got %d resources", len(resources))
+			}
+
+			metrics := map[string]*metricpb.Metric{}
+			for _, sm := range cluster.GetScopeMetrics() {
+				if scope := sm.GetScope().GetName(); scope != tracing.Scope {
+					t.Errorf("scope %q", scope)
+				}
+				for _, m := range sm.GetMetrics() {
+					metrics[m.GetName()] = m
+				}
+			}
+
+			// A gauge with one point, the cluster label is on the resource
+			size := metrics["rubrik_system_storage_size_bytes"]
+			if size.GetGauge() == nil {
+				t.Fatalf("rubrik_system_storage_size_bytes is not a gauge: %v", size)
+			}
+			if size.GetUnit() != "By" {
+				t.Errorf("rubrik_system_storage_size_bytes unit %q", size.GetUnit())
+			}
+			points := size.GetGauge().GetDataPoints()
+			if len(points) != 1 {
+				t.Fatalf("rubrik_system_storage_size_bytes has %d points", len(points))
+			}
+			if v := points[0].GetAsDouble(); v != 107374182400000 {
+				t.Errorf("rubrik_system_storage_size_bytes = %g", v)
+			}
+			if len(points[0].GetAttributes()) != 0 || points[0].GetTimeUnixNano() == 0 {
+				t.Errorf("rubrik_system_storage_size_bytes point: %d attributes, time %d", len(points[0].GetAttributes()), points[0].GetTimeUnixNano())
+			}
+
+			found := false
+			for _, p := range metrics["rubrik_vm_protected"].GetGauge().GetDataPoints() {
+				attrs := otlpStrings(p.GetAttributes())
+				if attrs["vmid"] == mappedVM {
+					found = len(attrs) == 1 && p.GetAsDouble() == 1
+				}
+			}
+			if !found {
+				t.Errorf("rubrik_vm_protected has no point {vmid=%q} 1", mappedVM)
+			}
+
+			// Counters are monotonic cumulative sums with a start time
+			sum := metrics["rubrik_archive_storage_data_archived_bytes_total"].GetSum()
+			if sum == nil {
+				t.Fatal("rubrik_archive_storage_data_archived_bytes_total is not a sum")
+			}
+			if sum.GetAggregationTemporality() != metricpb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+				t.Errorf("aggregation temporality %v, want cumulative", sum.GetAggregationTemporality())
+			}
+			if !sum.GetIsMonotonic() {
+				t.Error("counter sum is not monotonic")
+			}
+			for _, p := range sum.GetDataPoints() {
+				if start, end := p.GetStartTimeUnixNano(), p.GetTimeUnixNano(); start == 0 || start > end {
+					t.Errorf("sum point start %d, time %d", start, end)
+				}
+			}
+		})
+	}
+}
+
+func TestTracing(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject("graphql", rubriktest.NotFound)
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+
+	var mu sync.Mutex
+	var received []*tracepb.ResourceSpans
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req coltracepb.ExportTraceServiceRequest
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/v1/traces" || proto.Unmarshal(body, &req) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, req.GetResourceSpans()...)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}))
+	defer collector.Close()
+
+	stopTracing, err := startTracing(collector.URL, otlpProtocolHTTP, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracing.SetTracer(nil)
+	// The histogram is gathered alongside the collectors, the second gather
+	// shows the calls of the first
+	coalescer := newCoalescer(time.Minute)
+	gatherCollectors(context.Background(), newCollectors(collectorConfig{}), coalescer)
+	families, _ := gatherCollectors(context.Background(), newCollectors(collectorConfig{}), coalescer)
+	stopTracing()
+
+	spans := map[string]*tracepb.Span{}
+	var calls, graphqlCalls []*tracepb.Span
+	for _, rs := range received {
+		if attrs := otlpStrings(rs.GetResource().GetAttributes()); attrs["service.name"] != "rubrik-exporter" {
+			t.Errorf("trace resource %v", attrs)
+		}
+		for _, ss := range rs.GetScopeSpans() {
+			for _, span := range ss.GetSpans() {
+				spans[string(span.GetSpanId())] = span
+				switch span.GetName() {
+				case "GET /api/v1/vmware/vm":
+					calls = append(calls, span)
+				case "graphql VMwareVMs":
+					graphqlCalls = append(graphqlCalls, span)
+				}
+			}
+		}
+	}
+	if len(calls) == 0 || len(graphqlCalls) == 0 {
+		t.Fatalf("no spans of the REST and GraphQL VM calls in %d spans", len(spans))
+	}
+
+	// The REST call is a client span in collect vm, in gather, the root
+	call := calls[0]
+	parent := func(span *tracepb.Span) *tracepb.Span {
+		t.Helper()
+		if len(span.GetParentSpanId()) != 8 {
+			t.Fatalf("span %s has parent ID %x", span.GetName(), span.GetParentSpanId())
+		}
+		p, ok := spans[string(span.GetParentSpanId())]
+		if !ok {
+			t.Fatalf("parent of span %s not exported", span.GetName())
+		}
+		if !bytes.Equal(p.GetTraceId(), span.GetTraceId()) {
+			t.Errorf("span %s has another trace ID than its parent %s", span.GetName(), p.GetName())
+		}
+		return p
+	}
+	collect := parent(call)
+	gather := parent(collect)
+	if collect.GetName() != "collect vm" || gather.GetName() != "gather" || gather.GetParentSpanId() != nil {
+		t.Errorf("span tree %s < %s < %s, parent of the root %x", call.GetName(), collect.GetName(), gather.GetName(), gather.GetParentSpanId())
+	}
+	if len(call.GetTraceId()) != 16 || len(call.GetSpanId()) != 8 {
+		t.Errorf("trace ID %x, span ID %x", call.GetTraceId(), call.GetSpanId())
+	}
+	if call.GetKind() != tracepb.Span_SPAN_KIND_CLIENT {
+		t.Errorf("REST call span kind %v, want client", call.GetKind())
+	}
+	if start, end := call.GetStartTimeUnixNano(), call.GetEndTimeUnixNano(); start == 0 || end < start {
+		t.Errorf("REST call span from %d to %d", start, end)
+	}
+	attrs := map[string]bool{}
+	for _, kv := range call.GetAttributes() {
+		attrs[kv.GetKey()] = true
+	}
+	for _, key := range []string{"rubrik.endpoint", "http.response.status_code", "http.response.body.size"} {
+		if !attrs[key] {
+			t.Errorf("REST call span has no attribute %s", key)
+		}
+	}
+	if code := call.GetStatus().GetCode(); code == tracepb.Status_STATUS_CODE_ERROR {
+		t.Errorf("successful REST call has status code %v", code)
+	}
+	// GraphQL is answered with 404
+	for _, span := range graphqlCalls {
+		if status := span.GetStatus(); status.GetCode() != tracepb.Status_STATUS_CODE_ERROR || status.GetMessage() == "" {
+			t.Errorf("failed GraphQL call has status %v", status)
+		}
+	}
+
+	exemplars := 0
+	for _, mf := range families {
+		if mf.GetName() != "rubrik_api_request_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, b := range m.GetHistogram().GetBucket() {
+				if e := b.GetExemplar(); e != nil && e.GetLabel()[0].GetName() == "trace_id" {
+					exemplars++
+				}
+			}
+		}
+	}
+	if exemplars == 0 {
+		t.Error("rubrik_api_request_duration_seconds has no exemplars with trace IDs")
+	}
+}
@@ -0,0 +1,271 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Push modes
+const (
+	pushModePushgateway = "pushgateway"
+	pushModeRemoteWrite = "remote-write"
+)
+
+var (
+	pushTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "push", Name: "total",
+		Help: "Pushes of the collected metrics by result: success, retried, spooled or dropped",
+	}, []string{"result"})
+	pushSpooled = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "push", Name: "spooled_requests",
+		Help: "Remote write requests waiting in the spool for the receiver to come back",
+	})
+)
+
+// pushRetryDelay is the delay before the first retry of a push, it doubles
+// with every further retry
+var pushRetryDelay = time.Second
+
+// pushConfig - Where and how often push mode sends the metrics
+type pushConfig struct {
+	mode     string
+	url      string
+	job      string
+	interval time.Duration
+	retries  int
+
+	// spoolDir keeps remote write requests that couldn't be sent, at most
+	// spoolMax of them
+	spoolDir string
+	spoolMax int
+}
+
+// permanentError - The receiver rejected the push, sending it again
+// wouldn't help
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// pusher - Collects and sends the metrics on every interval
+type pusher struct {
+	config     pushConfig
+	collectors map[string]Collector
+	client     *http.Client
+}
+
+// runPush - Push the metrics of the collectors until ctx ends
+func runPush(ctx context.Context, collectors map[string]Collector, config pushConfig) int {
+	switch config.mode {
+	case pushModePushgateway, pushModeRemoteWrite:
+	default:
+		slog.Error("Unknown push mode, use "+pushModePushgateway+" or "+pushModeRemoteWrite, "mode", config.mode)
+		return 1
+	}
+	if config.url == "" {
+		slog.Error("-push.url is required in push mode")
+		return 1
+	}
+	if config.spoolDir != "" {
+		if err := os.MkdirAll(config.spoolDir, 0o700); err != nil {
+			slog.Error("Can't create spool directory", "err", err)
+			return 1
+		}
+	}
+
+	p := &pusher{
+		config:     config,
+		collectors: collectors,
+		client:     &http.Client{Timeout: config.interval},
+	}
+	slog.Info("Pushing metrics", "url", config.url, "mode", config.mode, "interval", config.interval)
+
+	ticker := time.NewTicker(config.interval)
+	defer ticker.Stop()
+	for {
+		p.pushOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		}
+	}
+}
+
+// pushOnce - Collect and send the metrics once. A collection is bounded by
+// the interval so a hanging cluster doesn't delay the following pushes.
+func (p *pusher) pushOnce(ctx context.Context) {
+	collectCtx, cancel := context.WithTimeout(ctx, p.config.interval)
+	defer cancel()
+
+	now := time.Now()
+	families, err := gatherCollectors(collectCtx, p.collectors, newCoalescer(0), pushTotal, pushSpooled)
+	if err != nil {
+		slog.ErrorContext(ctx, "Collecting the metrics failed", "err", err)
+	}
+
+	switch p.config.mode {
+	case pushModePushgateway:
+		p.retry(ctx, "Pushgateway", func() error { return p.pushGateway(ctx, families) })
+	case pushModeRemoteWrite:
+		p.remoteWrite(ctx, encodeRemoteWrite(families, now, map[string]string{"job": p.config.job}))
+	}
+}
+
+// pushGateway - Replace the group of the job on the Pushgateway. The
+// Pushgateway only keeps the latest push, so nothing is spooled for it.
+func (p *pusher) pushGateway(ctx context.Context, families []*dto.MetricFamily) error {
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return families, nil })
+	err := push.New(p.config.url, p.config.job).Client(p.client).Gatherer(gatherer).PushContext(ctx)
+	// Only rejected metrics are answered with 400
+	if err != nil && strings.Contains(err.Error(), "status code 400") {
+		return permanentError{err}
+	}
+	return err
+}
+
+// remoteWrite - Send the spooled requests oldest first, then req. Once a
+// request can't be sent the rest waits in the spool for the next interval.
+func (p *pusher) remoteWrite(ctx context.Context, req []byte) {
+	for _, file := range p.spooled() {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = p.retry(ctx, "remote write of spooled request", func() error { return p.sendRemoteWrite(ctx, data) })
+		}
+		if err != nil && !errors.As(err, new(permanentError)) {
+			p.spool(req)
+			return
+		}
+		os.Remove(file)
+	}
+	pushSpooled.Set(float64(len(p.spooled())))
+
+	err := p.retry(ctx, "remote write", func() error { return p.sendRemoteWrite(ctx, req) })
+	if err != nil && !errors.As(err, new(permanentError)) {
+		p.spool(req)
+	}
+}
+
+// sendRemoteWrite - POST a snappy compressed remote write request
+func (p *pusher) sendRemoteWrite(ctx context.Context, req []byte) error {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.config.url, bytes.NewReader(req))
+	if err != nil {
+		return permanentError{err}
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", "rubrik-exporter")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	// Bad data is answered with 4xx and won't be accepted later either
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// retry - Call send until it succeeds, fails permanently or the retries
+// are used up, with exponential backoff. Returns the last error.
+func (p *pusher) retry(ctx context.Context, what string, send func() error) error {
+	delay := pushRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := send()
+		if err == nil {
+			pushTotal.WithLabelValues("success").Inc()
+			return nil
+		}
+		if errors.As(err, new(permanentError)) {
+			slog.Error("Push rejected, dropping it", "push", what, "err", err)
+			pushTotal.WithLabelValues("dropped").Inc()
+			return err
+		}
+		if attempt >= p.config.retries {
+			slog.Error("Push failed", "push", what, "err", err)
+			return err
+		}
+
+		slog.Warn("Push failed, retrying", "push", what, "delay", delay, "attempt", attempt+1, "retries", p.config.retries, "err", err)
+		pushTotal.WithLabelValues("retried").Inc()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// spool - Keep a remote write request to send it when the receiver is
+// back. The oldest requests are dropped when the spool is full.
+func (p *pusher) spool(req []byte) {
+	if p.config.spoolDir == "" {
+		pushTotal.WithLabelValues("dropped").Inc()
+		return
+	}
+
+	name := filepath.Join(p.config.spoolDir, fmt.Sprintf("%d.rw", time.Now().UnixNano()))
+	if err := os.WriteFile(name, req, 0o600); err != nil {
+		slog.Error("Can't spool remote write request", "err", err)
+		pushTotal.WithLabelValues("dropped").Inc()
+		return
+	}
+	pushTotal.WithLabelValues("spooled").Inc()
+
+	files := p.spooled()
+	for len(files) > p.config.spoolMax && p.config.spoolMax > 0 {
+		slog.Warn("Spool is full, dropping the oldest request", "file", filepath.Base(files[0]))
+		os.Remove(files[0])
+		pushTotal.WithLabelValues("dropped").Inc()
+		files = files[1:]
+	}
+	pushSpooled.Set(float64(len(files)))
+}
+
+// spooled - The spooled requests, oldest first
+func (p *pusher) spooled() []string {
+	if p.config.spoolDir == "" {
+		return nil
+	}
+	files, _ := filepath.Glob(filepath.Join(p.config.spoolDir, "*.rw"))
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,106 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubriktest"
+	"github.com/klauspost/compress/snappy"
+)
+
+// receiver - Records what was pushed, failing while down is set
+type receiver struct {
+	mu       sync.Mutex
+	down     bool
+	requests [][]byte
+	paths    []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.down {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	rc.requests = append(rc.requests, body)
+	rc.paths = append(rc.paths, r.Method+" "+r.URL.Path)
+}
+
+func newPusher(t *testing.T, config pushConfig) *pusher {
+	t.Helper()
+	srv := rubriktest.NewServer()
+	t.Cleanup(srv.Close)
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+
+	pushRetryDelay = time.Millisecond
+	config.interval = 10 * time.Second
+	return &pusher{config: config, collectors: newCollectors(collectorConfig{}), client: &http.Client{}}
+}
+
+func TestPushGateway(t *testing.T) {
+	rc := &receiver{}
+	gateway := httptest.NewServer(rc)
+	defer gateway.Close()
+
+	p := newPusher(t, pushConfig{mode: pushModePushgateway, url: gateway.URL, job: "rubrik"})
+	p.pushOnce(context.Background())
+
+	if len(rc.requests) != 1 || rc.paths[0] != "PUT /metrics/job/rubrik" {
+		t.Fatalf("pushed %v, want one PUT of the job", rc.paths)
+	}
+	if !bytes.Contains(rc.requests[0], []byte("rubrik_system_storage_size_bytes")) {
+		t.Error("push doesn't contain the collected metrics")
+	}
+}
+
+func TestRemoteWriteSpool(t *testing.T) {
+	rc := &receiver{down: true}
+	endpoint := httptest.NewServer(rc)
+	defer endpoint.Close()
+
+	p := newPusher(t, pushConfig{mode: pushModeRemoteWrite, url: endpoint.URL, job: "rubrik", retries: 1, spoolDir: t.TempDir(), spoolMax: 10})
+	p.pushOnce(context.Background())
+	if n := len(p.spooled()); n != 1 {
+		t.Fatalf("%d requests spooled while the receiver is down, want 1", n)
+	}
+
+	rc.mu.Lock()
+	rc.down = false
+	rc.mu.Unlock()
+	p.pushOnce(context.Background())
+	if n := len(p.spooled()); n != 0 {
+		t.Errorf("%d requests left in the spool", n)
+	}
+	if len(rc.requests) != 2 {
+		t.Fatalf("receiver got %d requests, want the spooled and the current one", len(rc.requests))
+	}
+
+	req, err := snappy.Decode(nil, rc.requests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"__name__", "rubrik_system_storage_size_bytes", "job", "cdm-lab-01"} {
+		if !bytes.Contains(req, []byte(want)) {
+			t.Errorf("remote write request doesn't contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the Prometheus remote write protocol (prompb)
+const (
+	writeRequestTimeseries = 1
+	timeSeriesLabels       = 1
+	timeSeriesSamples      = 2
+	labelName              = 1
+	labelValue             = 2
+	sampleValue            = 1
+	sampleTimestamp        = 2
+)
+
+// sample - One sample of a remote write time series
+type sample struct {
+	labels    []*dto.LabelPair
+	value     float64
+	timestamp int64
+}
+
+// encodeRemoteWrite - Convert metric families to a snappy compressed
+// remote write request. Summaries and histograms are split into their
+// series like Prometheus does when scraping them, native histograms are
+// left out. Metrics without a timestamp get now. extra labels are added to
+// every series.
+func encodeRemoteWrite(families []*dto.MetricFamily, now time.Time, extra map[string]string) []byte {
+	var req []byte
+	for _, s := range familySamples(families, now.UnixMilli()) {
+		labels := append([]*dto.LabelPair(nil), s.labels...)
+		for name, value := range extra {
+			labels = append(labels, &dto.LabelPair{Name: &name, Value: &value})
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+
+		var ts []byte
+		for _, l := range labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, labelName, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.GetName())
+			lb = protowire.AppendTag(lb, labelValue, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.GetValue())
+			ts = protowire.AppendTag(ts, timeSeriesLabels, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+
+		var sb []byte
+		sb = protowire.AppendTag(sb, sampleValue, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+		sb = protowire.AppendTag(sb, sampleTimestamp, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, timeSeriesSamples, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sb)
+
+		req = protowire.AppendTag(req, writeRequestTimeseries, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return snappy.Encode(nil, req)
+}
+
+// familySamples - Flatten metric families into samples, the metric name in
+// the __name__ label
+func familySamples(families []*dto.MetricFamily, now int64) []sample {
+	var samples []sample
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			timestamp := now
+			if m.TimestampMs != nil {
+				timestamp = m.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extra ...string) {
+				name := mf.GetName() + suffix
+				labels := []*dto.LabelPair{{Name: strPtr(model.MetricNameLabel), Value: &name}}
+				labels = append(labels, m.GetLabel()...)
+				for i := 0; i+1 < len(extra); i += 2 {
+					labels = append(labels, &dto.LabelPair{Name: strPtr(extra[i]), Value: strPtr(extra[i+1])})
+				}
+				samples = append(samples, sample{labels: labels, value: value, timestamp: timestamp})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", q.GetValue(), model.QuantileLabel, formatFloat(q.GetQuantile()))
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				infSeen := false
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						infSeen = true
+					}
+					add("_bucket", float64(b.GetCumulativeCount()), model.BucketLabel, formatFloat(b.GetUpperBound()))
+				}
+				if !infSeen {
+					add("_bucket", float64(h.GetSampleCount()), model.BucketLabel, "+Inf")
+				}
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return samples
+}
+
+// formatFloat - Format a bucket bound or quantile like the text exposition
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func strPtr(s string) *string {
+	return &s
+}
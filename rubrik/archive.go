@@ -13,7 +13,7 @@ package rubrik
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 )
 
 type LocationList struct {
@@ -31,13 +31,14 @@ type Location struct {
 }
 
 // GetArchiveLocations ...
-func (r Rubrik) GetArchiveLocations() []Location {
-	log.Printf("GetArchiveLocations: Starting API call")
+func (r Rubrik) GetArchiveLocations(ctx context.Context) ([]Location, error) {
+	if r.securityCloud {
+		return r.rscGetArchiveLocations(ctx)
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
-		log.Printf("GetArchiveLocations: Trying GraphQL")
+	if r.useGraphQL(ctx) {
 		var response ArchiveLocationsResponse
-		err := r.graphqlClient.ExecuteQuery(context.Background(), ArchiveLocationsQuery, nil, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, ArchiveLocationsQuery, nil, &response)
 		if err == nil {
 			// Convert GraphQL response to Location structs
 			locations := make([]Location, len(response.ArchiveLocations.Edges))
@@ -49,29 +50,25 @@ func (r Rubrik) GetArchiveLocations() []Location {
 					IsActive:     edge.Node.Status == "CONNECTED", // Map status to isActive
 				}
 			}
-			log.Printf("GetArchiveLocations: GraphQL succeeded, found %d locations", len(locations))
-			return locations
+			slog.DebugContext(ctx, "Archive locations", "backend", BackendGraphQL, "count", len(locations))
+			return locations, nil
 		}
-		log.Printf("GetArchiveLocations: GraphQL failed, falling back to REST: %v", err)
-	} else {
-		log.Printf("GetArchiveLocations: GraphQL client not available, using REST")
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "GetArchiveLocations", "err", err)
 	}
 
 	// Fallback to REST API
-	log.Printf("GetArchiveLocations: Trying REST API")
-	resp, err := r.makeRequest("GET", "/api/internal/archive/location", RequestParams{})
-	if err != nil || resp == nil {
-		log.Printf("GetArchiveLocations: REST API failed: %v", err)
-		return []Location{}
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/archive/location", RequestParams{})
+	if err != nil {
+		return []Location{}, err
 	}
 	defer resp.Body.Close()
 	var data LocationList
 	decoder := json.NewDecoder(resp.Body)
 	err = decoder.Decode(&data)
 	if err != nil {
-		log.Printf("GetArchiveLocations: Failed to decode REST response: %v", err)
-		return []Location{}
+		slog.WarnContext(ctx, "Decoding Rubrik API response failed", "call", "GetArchiveLocations", "err", err)
+		return []Location{}, err
 	}
-	log.Printf("GetArchiveLocations: REST succeeded, found %d locations", len(data.Data))
-	return data.Data
+	slog.DebugContext(ctx, "Archive locations", "backend", BackendREST, "count", len(data.Data))
+	return data.Data, nil
 }
@@ -0,0 +1,106 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/tracing"
+)
+
+// Backend - The API a call went to
+type Backend string
+
+// Backends of the Rubrik API
+const (
+	BackendGraphQL Backend = "graphql"
+	BackendREST    Backend = "rest"
+)
+
+// Call - One request to the API, seen by a CallTrace
+type Call struct {
+	Backend Backend
+	// Endpoint is the GraphQL operation name or the REST path
+	Endpoint string
+	Duration time.Duration
+	Err      error
+}
+
+// Denied - Reports whether the API refused the call for missing
+// permissions. REST answers 403, GraphQL may answer 200 with an error.
+func (c Call) Denied() bool {
+	var apiErr *APIError
+	if errors.As(c.Err, &apiErr) {
+		return apiErr.StatusCode == http.StatusForbidden
+	}
+	if c.Err == nil {
+		return false
+	}
+	msg := strings.ToLower(c.Err.Error())
+	return strings.Contains(msg, "forbidden") || strings.Contains(msg, "permission") || strings.Contains(msg, "not authorized")
+}
+
+// CallTrace - Collects the API calls made with a context returned by
+// WithCallTrace
+type CallTrace struct {
+	mu    sync.Mutex
+	calls []Call
+}
+
+// Calls - Returns the calls in the order they finished
+func (t *CallTrace) Calls() []Call {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Call(nil), t.calls...)
+}
+
+type callTraceKey struct{}
+
+// WithCallTrace - Returns a context recording its API calls to t
+func WithCallTrace(ctx context.Context, t *CallTrace) context.Context {
+	return context.WithValue(ctx, callTraceKey{}, t)
+}
+
+// traceCall - Observe the duration of c, mark the span started by startCall
+// as failed if c failed, remember it for Endpoints and record c if ctx
+// carries a CallTrace
+func traceCall(ctx context.Context, c Call) {
+	span := tracing.SpanFromContext(ctx)
+	span.RecordError(c.Err)
+	observeCall(c, span)
+	recordEndpoint(c)
+
+	t, ok := ctx.Value(callTraceKey{}).(*CallTrace)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	t.calls = append(t.calls, c)
+	t.mu.Unlock()
+}
+
+type restOnlyKey struct{}
+
+// WithoutGraphQL - Returns a context whose calls skip GraphQL and go to the
+// REST API directly. Rubrik Security Cloud only has GraphQL and ignores it.
+func WithoutGraphQL(ctx context.Context) context.Context {
+	return context.WithValue(ctx, restOnlyKey{}, true)
+}
+
+// useGraphQL - Whether a CDM call should try GraphQL before REST
+func (r Rubrik) useGraphQL(ctx context.Context) bool {
+	return r.graphqlClient != nil && ctx.Value(restOnlyKey{}) == nil
+}
@@ -0,0 +1,346 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// redacted replaces credentials and session tokens in a capture
+const redacted = "REDACTED"
+
+// sensitiveHeaders are replaced in captured requests and responses
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+var (
+	// sensitiveField matches JSON and form fields holding credentials
+	sensitiveField = regexp.MustCompile(`(?i)token|secret|password`)
+	// operationName extracts the name of a GraphQL query for file names
+	operationName = regexp.MustCompile(`(?:query|mutation)\s+(\w+)`)
+	// unsafeFileChars are replaced in file names
+	unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+)
+
+// exchange - A captured request and the response or error it got. It is
+// written as one JSON file per API call.
+type exchange struct {
+	Key      string            `json:"key"`
+	Request  capturedRequest   `json:"request"`
+	Response *capturedResponse `json:"response,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+type capturedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	capturedBody
+}
+
+type capturedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	capturedBody
+}
+
+// capturedBody - JSON bodies are kept as JSON to make captures readable,
+// anything else as a string
+type capturedBody struct {
+	JSON json.RawMessage `json:"json,omitempty"`
+	Body string          `json:"body,omitempty"`
+}
+
+// recordTransport - Writes every request and its response to dir
+type recordTransport struct {
+	next http.RoundTripper
+	dir  string
+	seq  atomic.Int64
+}
+
+// newRecordTransport ...
+func newRecordTransport(next http.RoundTripper, dir string) *recordTransport {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		slog.Error("Can't create record directory", "err", err)
+	}
+	slog.Info("Recording Rubrik API calls", "dir", dir)
+	return &recordTransport{next: next, dir: dir}
+}
+
+// RoundTrip ...
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	x := exchange{
+		Key: captureKey(req.Method, req.URL, reqBody),
+		Request: capturedRequest{
+			Method:       req.Method,
+			URL:          req.URL.RequestURI(),
+			Header:       redactHeader(req.Header),
+			capturedBody: newCapturedBody(req.Header.Get("Content-Type"), reqBody),
+		},
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		x.Error = err.Error()
+		t.write(x)
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		x.Error = err.Error()
+		t.write(x)
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	x.Response = &capturedResponse{
+		StatusCode:   resp.StatusCode,
+		Header:       redactHeader(resp.Header),
+		capturedBody: newCapturedBody(resp.Header.Get("Content-Type"), respBody),
+	}
+	t.write(x)
+
+	return resp, nil
+}
+
+// write - Store the exchange, failures are logged and don't fail the call
+func (t *recordTransport) write(x exchange) {
+	data, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		slog.Error("Can't encode captured call", "call", x.Key, "err", err)
+		return
+	}
+	name := fmt.Sprintf("%06d-%s.json", t.seq.Add(1), unsafeFileChars.ReplaceAllString(x.Key, "_"))
+	if err := os.WriteFile(filepath.Join(t.dir, name), append(data, '\n'), 0o600); err != nil {
+		slog.Error("Can't write captured call", "call", x.Key, "err", err)
+	}
+}
+
+// replayTransport - Answers requests from a capture written by
+// recordTransport without contacting the API. Calls repeated in the capture
+// get their responses in the recorded order, the last one is served again
+// once they are used up.
+type replayTransport struct {
+	mu        sync.Mutex
+	exchanges map[string][]exchange
+}
+
+// newReplayTransport - Load the capture in dir
+func newReplayTransport(dir string) (*replayTransport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no captured calls in %s", dir)
+	}
+	sort.Strings(files)
+
+	t := &replayTransport{exchanges: make(map[string][]exchange)}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var x exchange
+		if err := json.Unmarshal(data, &x); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		t.exchanges[x.Key] = append(t.exchanges[x.Key], x)
+	}
+	slog.Info("Replaying Rubrik API calls", "calls", len(files), "dir", dir)
+	return t, nil
+}
+
+// RoundTrip ...
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	key := captureKey(req.Method, req.URL, body)
+
+	t.mu.Lock()
+	recorded := t.exchanges[key]
+	if len(recorded) == 0 {
+		t.mu.Unlock()
+		slog.Warn("Call is not in the capture", "call", key)
+		return &http.Response{
+			Status:     "404 Not Found",
+			StatusCode: http.StatusNotFound,
+			Proto:      "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+			Header:  http.Header{"Content-Type": {"text/plain"}},
+			Body:    io.NopCloser(strings.NewReader("not in capture: " + key)),
+			Request: req,
+		}, nil
+	}
+	x := recorded[0]
+	if len(recorded) > 1 {
+		t.exchanges[key] = recorded[1:]
+	}
+	t.mu.Unlock()
+
+	if x.Response == nil {
+		return nil, errors.New(x.Error)
+	}
+	// The body was reformatted when it was written
+	header := x.Response.Header.Clone()
+	header.Del("Content-Length")
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", x.Response.StatusCode, http.StatusText(x.Response.StatusCode)),
+		StatusCode: x.Response.StatusCode,
+		Proto:      "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header:  header,
+		Body:    io.NopCloser(bytes.NewReader(x.Response.bytes())),
+		Request: req,
+	}, nil
+}
+
+// requestBody - Read the body of req without consuming it
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// captureKey - Identifies a call independent of host and credentials.
+// GraphQL calls all go to the same path, they are told apart by the
+// operation name and a hash of query and variables.
+func captureKey(method string, u *url.URL, body []byte) string {
+	key := method + " " + u.Path
+	if u.RawQuery != "" {
+		key += "?" + u.Query().Encode()
+	}
+	if strings.HasSuffix(u.Path, "/graphql") && len(body) > 0 {
+		var gql struct {
+			Query string `json:"query"`
+		}
+		json.Unmarshal(body, &gql)
+		if m := operationName.FindStringSubmatch(gql.Query); m != nil {
+			key += " " + m[1]
+		}
+		sum := sha256.Sum256(body)
+		key += " " + hex.EncodeToString(sum[:6])
+	}
+	return key
+}
+
+// redactHeader - Copy h with credentials and cookies replaced
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range sensitiveHeaders {
+		if _, ok := h[name]; ok {
+			h.Set(name, redacted)
+		}
+	}
+	return h
+}
+
+// newCapturedBody - Redact credentials in the body and store it
+func newCapturedBody(contentType string, body []byte) capturedBody {
+	if len(body) == 0 {
+		return capturedBody{}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			for key := range values {
+				if sensitiveField.MatchString(key) {
+					values.Set(key, redacted)
+				}
+			}
+			return capturedBody{Body: values.Encode()}
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err == nil && !decoder.More() {
+		if redactJSON(v) {
+			if data, err := json.Marshal(v); err == nil {
+				return capturedBody{JSON: data}
+			}
+		}
+		return capturedBody{JSON: body}
+	}
+
+	return capturedBody{Body: string(body)}
+}
+
+// redactJSON - Replace string values of sensitive fields in place. Returns
+// whether anything was replaced.
+func redactJSON(v interface{}) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if _, ok := value.(string); ok && sensitiveField.MatchString(key) {
+				v[key] = redacted
+				changed = true
+				continue
+			}
+			changed = redactJSON(value) || changed
+		}
+	case []interface{}:
+		for _, value := range v {
+			changed = redactJSON(value) || changed
+		}
+	}
+	return changed
+}
+
+// bytes - The body as sent over the wire
+func (b capturedBody) bytes() []byte {
+	if len(b.JSON) > 0 {
+		return b.JSON
+	}
+	return []byte(b.Body)
+}
@@ -0,0 +1,79 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+)
+
+// Cluster - Describe a Rubrik CDM cluster
+type Cluster struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// GetClusters - Returns every cluster reachable through this API instance.
+// A CDM endpoint always returns its own cluster, Rubrik Security Cloud
+// returns all clusters registered with the account.
+func (r Rubrik) GetClusters(ctx context.Context) ([]Cluster, error) {
+	if r.securityCloud {
+		return r.rscGetClusters(ctx)
+	}
+	return []Cluster{r.cluster}, nil
+}
+
+// ForCluster - Returns a copy of the API instance scoped to the given cluster.
+// Only Rubrik Security Cloud needs the scope, a CDM endpoint already is the
+// cluster.
+func (r Rubrik) ForCluster(c Cluster) Rubrik {
+	r.cluster = c
+	return r
+}
+
+// getLocalCluster - Identify the CDM cluster behind r.url
+func (r Rubrik) getLocalCluster(ctx context.Context) Cluster {
+	// Try GraphQL first
+	if r.useGraphQL(ctx) {
+		var response ClusterResponse
+		err := r.graphqlClient.ExecuteQuery(ctx, ClusterInfoQuery, nil, &response)
+		if err == nil && response.Cluster.ID != "" {
+			return Cluster{
+				ID:      response.Cluster.ID,
+				Name:    response.Cluster.Name,
+				Version: response.Cluster.Version,
+			}
+		}
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "getLocalCluster", "err", err)
+	}
+
+	// Fallback to REST API
+	resp, err := r.makeRequest(ctx, "GET", "/api/v1/cluster/me", RequestParams{})
+	if err == nil {
+		defer resp.Body.Close()
+
+		var c Cluster
+		if err := json.NewDecoder(resp.Body).Decode(&c); err == nil && c.Name != "" {
+			return c
+		}
+	}
+
+	// Keep the cluster label usable even if the cluster can't tell us its name
+	name := r.url
+	if u, err := url.Parse(r.url); err == nil && u.Hostname() != "" {
+		name = u.Hostname()
+	}
+	slog.WarnContext(ctx, "Can't query the cluster identity, using the host name as cluster name", "cluster", name)
+	return Cluster{Name: name}
+}
@@ -10,6 +10,8 @@
 
 package rubrik
 
+import "time"
+
 type ResultList struct {
 	HasMore bool `json:"hasMore"`
 	Total   int  `json:"total"`
@@ -35,3 +37,8 @@ type TimeStat struct {
 	Time string `json:"time"`
 	Stat int    `json:"stat"`
 }
+
+// Timestamp - The time of the point, RFC 3339 like 2026-10-18T10:00:00.000Z
+func (s TimeStat) Timestamp() (time.Time, error) {
+	return time.Parse(time.RFC3339, s.Time)
+}
@@ -2,11 +2,13 @@ package rubrik
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/machinebox/graphql"
 )
@@ -15,18 +17,17 @@ import (
 type GraphQLClient struct {
 	client   *graphql.Client
 	endpoint string
-	token    string
-}
 
-// NewGraphQLClient creates a new GraphQL client
-func NewGraphQLClient(endpoint, token string) *GraphQLClient {
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-	}
+	mu    sync.RWMutex
+	token string
+}
 
-	client := graphql.NewClient(endpoint, graphql.WithHTTPClient(httpClient))
+// NewGraphQLClient creates a new GraphQL client sending its requests
+// through httpClient
+func NewGraphQLClient(endpoint, token string, httpClient *http.Client) *GraphQLClient {
+	c := *httpClient
+	c.Transport = statusTransport{next: httpClient.Transport}
+	client := graphql.NewClient(endpoint, graphql.WithHTTPClient(&c))
 
 	return &GraphQLClient{
 		client:   client,
@@ -35,32 +36,75 @@ func NewGraphQLClient(endpoint, token string) *GraphQLClient {
 	}
 }
 
+// statusTransport turns error statuses into errors. The graphql package
+// ignores the status if the body is valid JSON, which hides a missing GraphQL
+// API from the REST fallback.
+type statusTransport struct {
+	next http.RoundTripper
+}
+
+func (t statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		return nil, &APIError{StatusCode: resp.StatusCode, Action: req.URL.Path}
+	}
+	return resp, nil
+}
+
+// SetToken replaces the token after a re-login
+func (g *GraphQLClient) SetToken(token string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.token = token
+}
+
 // ExecuteQuery executes a GraphQL query with authentication
 func (g *GraphQLClient) ExecuteQuery(ctx context.Context, query string, variables map[string]interface{}, result interface{}) error {
+	g.mu.RLock()
+	token := g.token
+	g.mu.RUnlock()
+
 	req := graphql.NewRequest(query)
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", g.token))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
 	// Add variables if provided
 	for key, value := range variables {
 		req.Var(key, value)
 	}
 
-	log.Printf("Executing GraphQL query (first 100 chars): %.100s", query)
+	operation := "anonymous"
+	if m := operationName.FindStringSubmatch(query); m != nil {
+		operation = m[1]
+	}
+	ctx, span := startCall(ctx, BackendGraphQL, operation, "graphql "+operation)
+	defer span.Finish()
+	slog.DebugContext(ctx, "Executing GraphQL query", "url", g.endpoint)
 
 	// Execute query
-	if err := g.client.Run(ctx, req, result); err != nil {
-		log.Printf("GraphQL query failed: %v", err)
-		log.Printf("GraphQL endpoint: %s", g.endpoint)
-		log.Printf("Auth token present: %t", g.token != "")
+	start := time.Now()
+	err := g.client.Run(ctx, req, result)
+	traceCall(ctx, Call{Backend: BackendGraphQL, Endpoint: operation, Duration: time.Since(start), Err: err})
+	if err != nil {
+		// Most callers fall back to REST and log the outcome
+		slog.DebugContext(ctx, "GraphQL query failed", "err", err, "authenticated", token != "")
 		return err
 	}
 
 	// Check if result is empty
 	resultJSON, _ := json.Marshal(result)
 	if len(resultJSON) <= 2 { // {} or null
-		log.Printf("GraphQL query succeeded but result is empty: %s", string(resultJSON))
+		slog.DebugContext(ctx, "GraphQL query returned no data", "result", string(resultJSON))
 	} else {
-		log.Printf("GraphQL query succeeded with data (size: %d bytes)", len(resultJSON))
+		slog.DebugContext(ctx, "GraphQL query succeeded", "bytes", len(resultJSON))
 	}
 
 	return nil
@@ -121,6 +165,10 @@ const (
 						id
 						name
 					}
+					logicalPath {
+						name
+						objectType
+					}
 				}
 			}
 		}
@@ -376,6 +424,7 @@ type VMwareVMsResponse struct {
 					ID   string `json:"id"`
 					Name string `json:"name"`
 				} `json:"effectiveSlaDomain"`
+				LogicalPath []pathNode `json:"logicalPath"`
 			} `json:"node"`
 		} `json:"edges"`
 	} `json:"vmwareVms"`
@@ -0,0 +1,240 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Priority - Order in which requests waiting for the rate limiter are sent
+type Priority int
+
+// Priorities, requests without a priority are PriorityNormal
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+// String ...
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	}
+	return "normal"
+}
+
+type priorityKey struct{}
+
+// WithPriority - Returns a context whose API requests are sent with the
+// given priority when the rate limiter holds them back
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityFrom ...
+func priorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+// limiter - Token bucket limiting the request rate, combined with a cap on
+// concurrent requests. Waiting requests are let through by priority, then
+// in arrival order.
+type limiter struct {
+	mu sync.Mutex
+
+	// rate is the number of requests per second, 0 means unlimited
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// maxConcurrent caps the requests in flight, 0 means unlimited
+	maxConcurrent int
+	active        int
+
+	waiters []*waiter
+	seq     uint64
+	timer   *time.Timer
+}
+
+type waiter struct {
+	priority Priority
+	seq      uint64
+	ready    chan struct{}
+	granted  bool
+}
+
+// newLimiter - Returns nil if neither a rate nor a concurrency limit is set
+func newLimiter(rate float64, burst int, maxConcurrent int) *limiter {
+	if rate <= 0 && maxConcurrent <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{
+		rate:          rate,
+		burst:         float64(burst),
+		tokens:        float64(burst),
+		last:          time.Now(),
+		maxConcurrent: maxConcurrent,
+	}
+}
+
+// acquire - Wait until the request may be sent. The returned function has to
+// be called once the request is done.
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	priority := priorityFrom(ctx)
+	start := time.Now()
+
+	l.mu.Lock()
+	l.seq++
+	w := &waiter{priority: priority, seq: l.seq, ready: make(chan struct{})}
+	l.waiters = append(l.waiters, w)
+	sort.SliceStable(l.waiters, func(i, j int) bool {
+		if l.waiters[i].priority != l.waiters[j].priority {
+			return l.waiters[i].priority > l.waiters[j].priority
+		}
+		return l.waiters[i].seq < l.waiters[j].seq
+	})
+	limiterQueueLength.WithLabelValues(priority.String()).Inc()
+	l.dispatch()
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if !w.granted {
+			l.remove(w)
+			limiterQueueLength.WithLabelValues(priority.String()).Dec()
+			return nil, ctx.Err()
+		}
+		// Granted while giving up, hand the slot on
+		l.active--
+		l.dispatch()
+		return nil, ctx.Err()
+	}
+
+	limiterWait.WithLabelValues(priority.String()).Observe(time.Since(start).Seconds())
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			l.active--
+			l.dispatch()
+			l.mu.Unlock()
+		})
+	}, nil
+}
+
+// dispatch - Let through as many waiters as the limits allow, l.mu must be
+// held
+func (l *limiter) dispatch() {
+	if l.rate > 0 {
+		now := time.Now()
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+	}
+
+	for len(l.waiters) > 0 {
+		if l.maxConcurrent > 0 && l.active >= l.maxConcurrent {
+			// release calls dispatch again
+			return
+		}
+		if l.rate > 0 && l.tokens < 1 {
+			l.schedule(time.Duration((1 - l.tokens) / l.rate * float64(time.Second)))
+			return
+		}
+
+		w := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		if l.rate > 0 {
+			l.tokens--
+		}
+		l.active++
+		w.granted = true
+		limiterQueueLength.WithLabelValues(w.priority.String()).Dec()
+		close(w.ready)
+	}
+}
+
+// schedule - Run dispatch again once the next token is available
+func (l *limiter) schedule(d time.Duration) {
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.timer = time.AfterFunc(d, func() {
+		l.mu.Lock()
+		l.dispatch()
+		l.mu.Unlock()
+	})
+}
+
+// remove ...
+func (l *limiter) remove(w *waiter) {
+	for i, o := range l.waiters {
+		if o == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// limitTransport - Sends requests only when the limiter allows it
+type limitTransport struct {
+	next    http.RoundTripper
+	limiter *limiter
+}
+
+// RoundTrip ...
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.limiter.acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// The request is in flight until its body is read
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releaseBody - Releases the limiter slot when the response body is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+// Close ...
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,176 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitQueued - Wait until n requests wait for l
+func waitQueued(t *testing.T, l *limiter, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		l.mu.Lock()
+		queued := len(l.waiters)
+		l.mu.Unlock()
+		if queued == n {
+			return
+		}
+	}
+	t.Fatalf("%d requests never queued", n)
+}
+
+func TestLimiterPriority(t *testing.T) {
+	l := newLimiter(0, 0, 1)
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The low priority request arrives first
+	order := make(chan Priority, 3)
+	var wg sync.WaitGroup
+	for i, p := range []Priority{PriorityLow, PriorityNormal, PriorityHigh} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := l.acquire(WithPriority(context.Background(), p))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- p
+			release()
+		}()
+		waitQueued(t, l, i+1)
+	}
+
+	release()
+	wg.Wait()
+	close(order)
+	var got []Priority
+	for p := range order {
+		got = append(got, p)
+	}
+	if len(got) != 3 || got[0] != PriorityHigh || got[1] != PriorityNormal || got[2] != PriorityLow {
+		t.Errorf("requests sent in order %v, want high, normal, low", got)
+	}
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+	const limit = 3
+	l := newLimiter(0, 0, limit)
+
+	var active, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := l.acquire(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n := active.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			active.Add(-1)
+			release()
+			release()
+		}()
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p != limit {
+		t.Errorf("%d requests in flight at once, want %d", p, limit)
+	}
+	if l.active != 0 || len(l.waiters) != 0 {
+		t.Errorf("%d requests active, %d waiting after all finished", l.active, len(l.waiters))
+	}
+}
+
+func TestLimiterCanceledWaiter(t *testing.T) {
+	l := newLimiter(0, 0, 1)
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := l.acquire(ctx)
+		done <- err
+	}()
+	waitQueued(t, l, 1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled acquire() = %v", err)
+	}
+	if len(l.waiters) != 0 {
+		t.Fatalf("%d requests still waiting", len(l.waiters))
+	}
+
+	// The slot goes to the next request, not to the canceled one
+	release()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	next, err := l.acquire(ctx)
+	if err != nil {
+		t.Fatalf("slot leaked to the canceled request: %v", err)
+	}
+	next()
+	if l.active != 0 {
+		t.Errorf("%d requests active after all finished", l.active)
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	l := newLimiter(50, 1, 0)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		release, err := l.acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	// The burst covers the first request, the others wait 20ms each
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("3 requests at 50/s with a burst of 1 took %s", d)
+	}
+
+	// A canceled waiter doesn't use up a token
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx); err == nil {
+		t.Fatal("acquire() without a token succeeded before the deadline")
+	}
+	time.Sleep(20 * time.Millisecond)
+	l.mu.Lock()
+	l.dispatch()
+	tokens := l.tokens
+	l.mu.Unlock()
+	if tokens < 0.9 {
+		t.Errorf("%.2f tokens after the refill, the canceled request took one", tokens)
+	}
+}
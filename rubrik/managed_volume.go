@@ -3,7 +3,7 @@ package rubrik
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 )
 
 type ManagedVolumeList struct {
@@ -37,11 +37,14 @@ type ManagedVolume struct {
 /* GetManagedVolumes
  *
  */
-func (r Rubrik) GetManagedVolumes() []ManagedVolume {
+func (r Rubrik) GetManagedVolumes(ctx context.Context) ([]ManagedVolume, error) {
+	if r.securityCloud {
+		return r.rscGetManagedVolumes(ctx)
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
+	if r.useGraphQL(ctx) {
 		var response ManagedVolumesResponse
-		err := r.graphqlClient.ExecuteQuery(context.Background(), ManagedVolumesQuery, nil, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, ManagedVolumesQuery, nil, &response)
 		if err == nil {
 			// Convert GraphQL response to ManagedVolume structs
 			volumes := make([]ManagedVolume, len(response.ManagedVolumes.Edges))
@@ -69,21 +72,23 @@ func (r Rubrik) GetManagedVolumes() []ManagedVolume {
 					IsRelic:                 edge.Node.IsRelic,
 				}
 			}
-			return volumes
+			return volumes, nil
 		}
-		log.Printf("GraphQL GetManagedVolumes failed, falling back to REST: %v", err)
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "GetManagedVolumes", "err", err)
 	}
 
 	// Fallback to REST API
-	resp, err := r.makeRequest("GET", "/api/internal/managed_volume", RequestParams{})
-	if err != nil || resp == nil {
-		return []ManagedVolume{}
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/managed_volume", RequestParams{})
+	if err != nil {
+		return []ManagedVolume{}, err
 	}
 	defer resp.Body.Close()
 
 	var l ManagedVolumeList
 	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(&l)
+	if err := decoder.Decode(&l); err != nil {
+		return []ManagedVolume{}, err
+	}
 
-	return l.Data
+	return l.Data, nil
 }
@@ -0,0 +1,44 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	apiRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rubrik", Subsystem: "api", Name: "retries_total",
+		Help: "Rubrik API requests repeated after a transient error, by reason",
+	}, []string{"reason"})
+
+	limiterWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "rubrik", Subsystem: "api", Name: "limiter_wait_seconds",
+		Help:    "Time Rubrik API requests waited for the rate limiter, by priority",
+		Buckets: []float64{.001, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"priority"})
+	limiterQueueLength = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "rubrik", Subsystem: "api", Name: "limiter_queue_length",
+		Help: "Rubrik API requests currently waiting for the rate limiter, by priority",
+	}, []string{"priority"})
+
+	// Sampled calls carry their trace ID as exemplar
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "rubrik", Subsystem: "api", Name: "request_duration_seconds",
+		Help:    "Duration of Rubrik API calls including retries, by backend and result",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"backend", "result"})
+)
+
+// RegisterMetrics - Register the metrics about the API client itself
+func RegisterMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(apiRetries, limiterWait, limiterQueueLength, requestDuration)
+}
@@ -14,7 +14,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/url"
 )
 
@@ -45,11 +45,14 @@ type NodeStat struct {
 }
 
 // GetNodes - Returns the List of all Rubrik Nodes
-func (r Rubrik) GetNodes() []Node {
+func (r Rubrik) GetNodes(ctx context.Context) ([]Node, error) {
+	if r.securityCloud {
+		return r.rscGetNodes(ctx)
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
+	if r.useGraphQL(ctx) {
 		var response NodesResponse
-		err := r.graphqlClient.ExecuteQuery(context.Background(), NodesQuery, nil, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, NodesQuery, nil, &response)
 		if err == nil {
 			// Convert GraphQL response to Node structs
 			nodes := make([]Node, len(response.Nodes))
@@ -62,39 +65,47 @@ func (r Rubrik) GetNodes() []Node {
 					NeedsInspection: node.NeedsInspection,
 				}
 			}
-			return nodes
+			return nodes, nil
 		}
-		log.Printf("GraphQL GetNodes failed, falling back to REST: %v", err)
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "GetNodes", "err", err)
 	}
 
 	// Fallback to REST API
-	resp, err := r.makeRequest("GET", "/api/internal/node", RequestParams{})
-	if err != nil || resp == nil {
-		return []Node{}
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/node", RequestParams{})
+	if err != nil {
+		return []Node{}, err
 	}
 	defer resp.Body.Close()
 
 	var l NodeList
 	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(&l)
+	if err := decoder.Decode(&l); err != nil {
+		return []Node{}, err
+	}
 
-	return l.Data
+	return l.Data, nil
 }
 
 // GetNodeStats ...
-func (r Rubrik) GetNodeStats(id string) NodeStat {
-	resp, err := r.makeRequest(
+func (r Rubrik) GetNodeStats(ctx context.Context, id string) (NodeStat, error) {
+	// Node performance stats are only available from the CDM API
+	if r.securityCloud {
+		return NodeStat{}, nil
+	}
+	resp, err := r.makeRequest(ctx,
 		"GET",
 		fmt.Sprintf("/api/internal/node/%s/stats", id),
 		RequestParams{params: url.Values{"range": []string{"-10min"}}})
-	if err != nil || resp == nil {
-		return NodeStat{}
+	if err != nil {
+		return NodeStat{}, err
 	}
 	defer resp.Body.Close()
 
 	var result NodeStat
 	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(&result)
+	if err := decoder.Decode(&result); err != nil {
+		return NodeStat{}, err
+	}
 
-	return result
+	return result, nil
 }
@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/url"
 	"strings"
 )
@@ -42,25 +42,29 @@ type ReportDataPoint struct {
 	Value   float64 `json:"value"`
 }
 
-func (r Rubrik) GetReports(params map[string]string) []Report {
+func (r Rubrik) GetReports(ctx context.Context, params map[string]string) ([]Report, error) {
+	// Canned reports are only available from the CDM API
+	if r.securityCloud {
+		return []Report{}, nil
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
+	if r.useGraphQL(ctx) {
 		var response ReportsResponse
-		err := r.graphqlClient.ExecuteQuery(context.Background(), ReportsQuery, nil, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, ReportsQuery, nil, &response)
 		if err == nil {
 			// Convert GraphQL response to Report structs
 			reports := make([]Report, len(response.Reports.Edges))
 			for i, edge := range response.Reports.Edges {
 				reports[i] = Report{
-					ID:         edge.Node.ID,
-					Name:       edge.Node.Name,
-					ReportType: edge.Node.ReportType,
+					ID:           edge.Node.ID,
+					Name:         edge.Node.Name,
+					ReportType:   edge.Node.ReportType,
 					UpdateStatus: edge.Node.Status,
 				}
 			}
-			return reports
+			return reports, nil
 		}
-		log.Printf("GraphQL GetReports failed, falling back to REST: %v", err)
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "GetReports", "err", err)
 	}
 
 	// Fallback to REST API
@@ -69,51 +73,58 @@ func (r Rubrik) GetReports(params map[string]string) []Report {
 		_params.params[k] = []string{v}
 	}
 
-	resp, err := r.makeRequest("GET", "/api/internal/report", *_params)
-	if err != nil || resp == nil {
-		return []Report{}
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/report", *_params)
+	if err != nil {
+		return []Report{}, err
 	}
 	defer resp.Body.Close()
 
 	var l ReportList
 	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(&l)
+	if err := decoder.Decode(&l); err != nil {
+		return []Report{}, err
+	}
 
-	return l.Data
+	return l.Data, nil
 }
 
 // GetTaskDetails - Returned the reported TaskStatus in last 24h
 // returns  map[succeeded:3 failed:1 canceled:2]
-func (r Rubrik) GetTaskDetails() map[string]float64 {
-	reports := r.GetReports(map[string]string{
+func (r Rubrik) GetTaskDetails(ctx context.Context) (map[string]float64, error) {
+	result := make(map[string]float64)
+
+	reports, err := r.GetReports(ctx, map[string]string{
 		"type": "Canned", "report_template": "ProtectionTasksDetails",
 	})
-	
-	result := make(map[string]float64)
-	
+	if err != nil {
+		return result, err
+	}
+
 	// Return empty map if no reports found
 	if len(reports) == 0 {
-		return result
+		return result, nil
 	}
-	
+
 	report := reports[0]
 
 	_params := &RequestParams{params: url.Values{"chart_id": []string{"chart0"}}}
 	_url := fmt.Sprintf("/api/internal/report/%s/chart", report.ID)
 
-	resp, err := r.makeRequest("GET", _url, *_params)
-	if err != nil || resp == nil {
-		return result
+	resp, err := r.makeRequest(ctx, "GET", _url, *_params)
+	if err != nil {
+		return result, err
 	}
 	defer resp.Body.Close()
 
 	var data []ReportData
 	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(&data)
+	if err := decoder.Decode(&data); err != nil {
+		return result, err
+	}
 
 	// Return empty map if no data found
 	if len(data) == 0 {
-		return result
+		return result, nil
 	}
 
 	for _, c := range data[0].DataColumns {
@@ -123,5 +134,5 @@ func (r Rubrik) GetTaskDetails() map[string]float64 {
 		}
 	}
 
-	return result
+	return result, nil
 }
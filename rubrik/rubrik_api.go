@@ -11,99 +11,278 @@
 package rubrik
 
 import (
-	"crypto/tls"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/logging"
 )
 
+// DefaultRequestTimeout is used when Options.RequestTimeout is not set
+const DefaultRequestTimeout = 30 * time.Second
+
 type RequestParams struct {
 	body, header string
 	params       url.Values
+
+	// isRetry is set when the request is repeated after a re-login
+	isRetry bool
+}
+
+// Options - Tunables of the API client
+type Options struct {
+	// RequestTimeout bounds every single API call including reading the
+	// response and retries, independent of the deadline of the calling
+	// context
+	RequestTimeout time.Duration
+
+	// MaxIdleConns is the number of keep-alive connections to the API
+	MaxIdleConns int
+
+	// MaxRetries is how often a request failing with a transient error is
+	// repeated, 0 disables retries
+	MaxRetries int
+
+	// RateLimit is the number of requests per second sent to the API, with
+	// bursts of up to RateBurst requests. 0 disables the limit.
+	RateLimit float64
+	RateBurst int
+
+	// MaxConcurrentRequests caps the requests in flight, 0 disables the cap
+	MaxConcurrentRequests int
+
+	// RecordDir receives every API call with credentials redacted, one
+	// JSON file per call
+	RecordDir string
+
+	// ReplayDir answers all API calls from a capture written to RecordDir
+	// instead of contacting the API
+	ReplayDir string
+}
+
+// APIError - The API answered a request with a non 2xx status
+type APIError struct {
+	StatusCode int
+	Action     string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Action)
 }
 
 type Rubrik struct {
 	url      string
 	username string
-	password string
 
 	// Service account authentication (OAuth2 client credentials)
-	serviceAccountClientID     string
-	serviceAccountClientSecret string
+	serviceAccountClientID string
+
+	// Sources of the password and client secret, read again when a
+	// login fails
+	passwordSource     SecretSource
+	clientSecretSource SecretSource
 
-	sessionToken string
-	isLoggedIn   bool
+	// accessTokenURI overrides the default <url>/api/client_token
+	// endpoint, it comes from the service account JSON file
+	accessTokenURI string
+
+	// Session token and cached secrets, shared with copies of the instance
+	auth *authState
+
+	// client is shared by all requests to the cluster to reuse connections
+	// and to enforce the rate limit on all of them
+	client *http.Client
 
 	// GraphQL client for new API
 	graphqlClient *GraphQLClient
+
+	// securityCloud is set when url points to a Rubrik Security Cloud
+	// account instead of a single CDM cluster
+	securityCloud bool
+
+	// cluster is the CDM cluster the instance talks about. For Security
+	// Cloud it is set by ForCluster.
+	cluster Cluster
 }
 
-func (r *Rubrik) makeRequest(reqType string, action string, p RequestParams) (*http.Response, error) {
-	log.Printf("Is logged in: %t", r.isLoggedIn)
+// httpClient - Returns the client used for all calls to the API
+func (r Rubrik) httpClient() *http.Client {
+	return r.client
+}
 
+func (r *Rubrik) makeRequest(ctx context.Context, reqType string, action string, p RequestParams) (*http.Response, error) {
 	_url := r.url + action
 
-	log.Printf("Requested action: %s", action)
-
-	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	var netClient = http.Client{Transport: tr}
+	netClient := r.httpClient()
 
 	body := p.body
 
 	_url += "?" + p.params.Encode()
-	log.Printf("Request full URL: %s", _url)
 
-	req, err := http.NewRequest(reqType, _url, strings.NewReader(body))
+	ctx, span := startCall(ctx, BackendREST, action, reqType+" "+action)
+	defer span.Finish()
+	slog.DebugContext(ctx, "Rubrik API request", "method", reqType, "url", _url)
+
+	token := r.token()
+	req, err := http.NewRequestWithContext(ctx, reqType, _url, strings.NewReader(body))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "text/JSON")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", r.sessionToken))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
+	start := time.Now()
 	resp, err := netClient.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		slog.WarnContext(ctx, "Rubrik API request failed", "err", err)
+		traceCall(ctx, Call{Backend: BackendREST, Endpoint: action, Duration: time.Since(start), Err: err})
 		return nil, err
 	}
 
+	// The session expired or the credentials were rotated, login again and
+	// repeat the request once with the new token
+	if resp.StatusCode == http.StatusUnauthorized && !p.isRetry && reqType != "DELETE" {
+		resp.Body.Close()
+		if err := r.relogin(ctx, token); err != nil {
+			err = fmt.Errorf("%w, login failed: %v", &APIError{StatusCode: resp.StatusCode, Action: action}, err)
+			traceCall(ctx, Call{Backend: BackendREST, Endpoint: action, Duration: time.Since(start), Err: err})
+			return nil, err
+		}
+		p.isRetry = true
+		return r.makeRequest(ctx, reqType, action, p)
+	}
+
 	// Check HTTP status code
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		log.Printf("API Error: HTTP %d from %s", resp.StatusCode, action)
 		// Read response body for error details
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		log.Printf("API Error Response: %s", string(bodyBytes))
+		slog.WarnContext(ctx, "Rubrik API request failed", "status", resp.StatusCode, "response", logging.Trim(string(bodyBytes), 512))
 		resp.Body.Close()
 		// Return empty response with error to prevent JSON parsing of error pages
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, action)
+		err := &APIError{StatusCode: resp.StatusCode, Action: action}
+		traceCall(ctx, Call{Backend: BackendREST, Endpoint: action, Duration: time.Since(start), Err: err})
+		return nil, err
+	}
+
+	// Read the body here so the duration and the span cover the transfer
+	bodyBytes, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		slog.WarnContext(ctx, "Reading Rubrik API response failed", "err", err)
+		traceCall(ctx, Call{Backend: BackendREST, Endpoint: action, Duration: time.Since(start), Err: err})
+		return nil, err
 	}
+	resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 
+	traceCall(ctx, Call{Backend: BackendREST, Endpoint: action, Duration: time.Since(start)})
 	return resp, nil
 }
 
 // NewRubrik - Creates a new Rubrik API instance and login to it
-func NewRubrik(url string, username string, password string, serviceAccountClientID string, serviceAccountClientSecret string) *Rubrik {
+func NewRubrik(ctx context.Context, url string, username string, password SecretSource, serviceAccountClientID string, serviceAccountClientSecret SecretSource, opts Options) *Rubrik {
 
-	log.Print("Create new API Instance")
+	slog.Info("Creating Rubrik API instance", "url", url)
 	session := &Rubrik{
-		url:                         url,
-		username:                    username,
-		password:                    password,
-		serviceAccountClientID:      serviceAccountClientID,
-		serviceAccountClientSecret:  serviceAccountClientSecret,
-		sessionToken:                "",
-		isLoggedIn:                  false,
+		url:                    url,
+		username:               username,
+		passwordSource:         password,
+		serviceAccountClientID: serviceAccountClientID,
+		clientSecretSource:     serviceAccountClientSecret,
+		auth:                   &authState{},
 	}
-	session.Login()
+	session.connect(ctx, opts)
 
-	// Initialize GraphQL client with session token
-	graphqlEndpoint := strings.TrimSuffix(url, "/") + "/api/graphql"
-	session.graphqlClient = NewGraphQLClient(graphqlEndpoint, session.sessionToken)
+	return session
+}
+
+// NewRubrikSecurityCloud - Creates a new Rubrik Security Cloud API instance
+// and login to it with a service account.
+// url is the account URL, e.g. https://<account>.my.rubrik.com
+func NewRubrikSecurityCloud(ctx context.Context, url string, serviceAccountClientID string, serviceAccountClientSecret SecretSource, opts Options) *Rubrik {
+
+	slog.Info("Creating Rubrik Security Cloud API instance", "url", url)
+	session := &Rubrik{
+		url:                    url,
+		serviceAccountClientID: serviceAccountClientID,
+		clientSecretSource:     serviceAccountClientSecret,
+		securityCloud:          true,
+		auth:                   &authState{},
+	}
+	session.connect(ctx, opts)
+
+	return session
+}
+
+// NewRubrikWithServiceAccount - Creates a new Rubrik API instance for the
+// credentials of a service account file and login to it.
+// If url is empty the endpoint is taken from the access_token_uri.
+func NewRubrikWithServiceAccount(ctx context.Context, url string, sa ServiceAccount, securityCloud bool, opts Options) *Rubrik {
+
+	if url == "" {
+		url = sa.BaseURL()
+	}
 
-	log.Printf("Session-Token: %s", session.sessionToken)
-	log.Printf("GraphQL endpoint: %s", graphqlEndpoint)
+	slog.Info("Creating Rubrik API instance for service account", "url", url, "service_account", sa.Name)
+	session := &Rubrik{
+		url:                    url,
+		serviceAccountClientID: sa.ClientID,
+		clientSecretSource:     sa.secretSource(),
+		accessTokenURI:         sa.AccessTokenURI,
+		securityCloud:          securityCloud,
+		auth:                   &authState{},
+	}
+	session.connect(ctx, opts)
 
 	return session
 }
+
+// connect - Login and set up the GraphQL client. For CDM the cluster
+// identity is looked up once, it is used for the cluster label.
+func (r *Rubrik) connect(ctx context.Context, opts Options) {
+	r.url = strings.TrimSuffix(r.url, "/")
+	if opts.RequestTimeout <= 0 {
+		opts.RequestTimeout = DefaultRequestTimeout
+	}
+	if opts.MaxIdleConns <= 0 {
+		opts.MaxIdleConns = DefaultMaxIdleConns
+	}
+	r.client = newHTTPClient(opts)
+
+	r.auth.mu.Lock()
+	if _, err := r.reloadSecrets(ctx); err != nil {
+		slog.Error("Reading Rubrik credentials failed", "err", err)
+	}
+	r.auth.mu.Unlock()
+	r.Login(ctx)
+
+	// Initialize GraphQL client with session token
+	graphqlEndpoint := r.url + "/api/graphql"
+	r.graphqlClient = NewGraphQLClient(graphqlEndpoint, r.token(), r.httpClient())
+
+	slog.Debug("GraphQL client ready", "url", graphqlEndpoint)
+
+	if !r.securityCloud {
+		r.cluster = r.getLocalCluster(ctx)
+		slog.Info("Connected to cluster", "cluster", r.cluster.Name, "cluster_id", r.cluster.ID)
+	}
+}
+
+// tokenURL - Returns the endpoint service account credentials are
+// exchanged at
+func (r Rubrik) tokenURL() string {
+	if r.accessTokenURI != "" {
+		return r.accessTokenURI
+	}
+	return r.url + "/api/client_token"
+}
+
+// IsSecurityCloud - Reports whether the instance talks to Rubrik Security Cloud
+func (r Rubrik) IsSecurityCloud() bool {
+	return r.securityCloud
+}
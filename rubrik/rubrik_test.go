@@ -0,0 +1,369 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/logging"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubriktest"
+)
+
+func newCDM(t *testing.T, srv *rubriktest.Server, opts rubrik.Options) *rubrik.Rubrik {
+	t.Helper()
+	return rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), opts)
+}
+
+func newRSC(t *testing.T, srv *rubriktest.Server) *rubrik.Rubrik {
+	t.Helper()
+	return rubrik.NewRubrikSecurityCloud(context.Background(), srv.URL, rubriktest.ClientID, rubrik.StaticSecret(rubriktest.ClientSecret), rubrik.Options{})
+}
+
+func TestLocalCluster(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+
+	clusters, err := newCDM(t, srv, rubrik.Options{}).GetClusters(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 || clusters[0].Name != "cdm-lab-01" {
+		t.Errorf("GetClusters() = %+v, want cdm-lab-01", clusters)
+	}
+}
+
+func TestRESTFallback(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject("graphql", rubriktest.GraphQLError)
+
+	api := newCDM(t, srv, rubrik.Options{})
+	nodes, err := api.GetNodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[0].BrikID != "RVM191S012340" {
+		t.Errorf("GetNodes() = %+v", nodes)
+	}
+	if srv.Requests("rest/node") != 1 {
+		t.Errorf("REST endpoint requested %d times, want 1", srv.Requests("rest/node"))
+	}
+}
+
+func TestReloginOnExpiredSession(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject("graphql", rubriktest.NotFound)
+
+	api := newCDM(t, srv, rubrik.Options{})
+	srv.ExpireSession()
+
+	if _, err := api.GetManagedVolumes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Logins() != 2 {
+		t.Errorf("%d logins, want 2", srv.Logins())
+	}
+}
+
+func TestReloginShared(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject("graphql", rubriktest.NotFound)
+
+	api := newCDM(t, srv, rubrik.Options{})
+	srv.ExpireSession()
+	srv.HoldUnauthorized(8)
+
+	// Every request is rejected with the same token, one login replaces it
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := api.GetManagedVolumes(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if srv.Logins() != 2 {
+		t.Errorf("%d logins, want 2", srv.Logins())
+	}
+}
+
+func TestLoginFailure(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject(rubriktest.KeyLogin, rubriktest.Unauthorized)
+
+	api := newCDM(t, srv, rubrik.Options{})
+	_, err := api.GetManagedVolumes(context.Background())
+
+	var apiErr *rubrik.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 401 {
+		t.Errorf("GetManagedVolumes() error = %v, want HTTP 401", err)
+	}
+	if s := api.Status(); s.LoggedIn || !strings.Contains(s.LastError, "401") {
+		t.Errorf("Status() = %+v, want a failed login", s)
+	}
+}
+
+func TestSessionStatus(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+
+	// The CDM session token doesn't tell its expiry
+	s := newCDM(t, srv, rubrik.Options{}).Status()
+	if !s.LoggedIn || s.LoginTime.IsZero() || !s.ExpiresAt.IsZero() || s.URL != srv.URL {
+		t.Errorf("CDM Status() = %+v", s)
+	}
+
+	// The OAuth2 answer has expires_in
+	s = newRSC(t, srv).Status()
+	if until := time.Until(s.ExpiresAt); !s.LoggedIn || !s.SecurityCloud || until < 59*time.Minute || until > time.Hour {
+		t.Errorf("RSC Status() = %+v, want a token expiring in 1h", s)
+	}
+
+	var found bool
+	for _, e := range rubrik.Endpoints() {
+		if e.Backend == rubrik.BackendGraphQL && e.Endpoint == "ClusterInfo" && e.Calls > 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Endpoints() = %+v misses the cluster lookup", rubrik.Endpoints())
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+
+	api := newCDM(t, srv, rubrik.Options{})
+	srv.Inject("graphql/SystemStorage", rubriktest.Timeout)
+	srv.Inject("rest/system_storage", rubriktest.Timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := api.GetSystemStorage(ctx); err == nil {
+		t.Error("GetSystemStorage() succeeded against a hanging server")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("GetSystemStorage() returned after %s, want the context deadline", d)
+	}
+}
+
+func TestRetryTransientError(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject("graphql", rubriktest.NotFound)
+
+	api := newCDM(t, srv, rubrik.Options{MaxRetries: 2})
+	srv.InjectOnce("rest/streams_count", rubriktest.ServerError)
+
+	count, err := api.GetStreamCount(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 6 {
+		t.Errorf("GetStreamCount() = %d, want 6", count)
+	}
+	if srv.Requests("rest/streams_count") != 2 {
+		t.Errorf("REST endpoint requested %d times, want 2", srv.Requests("rest/streams_count"))
+	}
+}
+
+func TestMalformedPayload(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+
+	api := newCDM(t, srv, rubrik.Options{})
+	srv.Inject("graphql/ArchiveLocations", rubriktest.Malformed)
+	srv.Inject("rest/archive_location", rubriktest.Malformed)
+
+	if _, err := api.GetArchiveLocations(context.Background()); err == nil {
+		t.Error("GetArchiveLocations() succeeded on a malformed payload")
+	}
+}
+
+func TestSecurityCloudPagination(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.PageSize = 1
+
+	api := newRSC(t, srv)
+	clusters, err := api.GetClusters(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 1 {
+		t.Fatalf("GetClusters() = %+v, want the null cluster skipped", clusters)
+	}
+
+	vms, err := api.ForCluster(clusters[0]).ListVmwareVM(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vms) != 3 {
+		t.Errorf("ListVmwareVM() returned %d VMs, want 3", len(vms))
+	}
+	if srv.Requests("graphql/RscVsphereVms") != 3 {
+		t.Errorf("%d pages requested, want 3", srv.Requests("graphql/RscVsphereVms"))
+	}
+}
+
+func TestSecurityCloudArchiveLocations(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+
+	api := newRSC(t, srv)
+	clusters, err := api.GetClusters(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fixture holds a target of another cluster, the server drops it
+	// only for a CLUSTER_ID filter on texts
+	locations, err := api.ForCluster(clusters[0]).GetArchiveLocations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, l := range locations {
+		names = append(names, l.Name)
+	}
+	if strings.Join(names, ",") != "s3-archive,nfs-archive" {
+		t.Errorf("archive locations of %s = %v, want s3-archive and nfs-archive", clusters[0].Name, names)
+	}
+}
+
+func TestVMTags(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.PageSize = 1
+
+	for _, tc := range []struct {
+		name string
+		key  string
+		id   string
+	}{
+		{"cdm", "graphql/VMwareVMTags", "VirtualMachine:::7b8a9c0d-1e2f-4a3b-9c4d-5e6f7a8b9c0d-vm-101"},
+		{"rsc", "graphql/RscVsphereVmTags", "7b8a9c0d-1e2f-4a3b-9c4d-5e6f7a8b9c0d-vm-101"},
+	} {
+		api := *newCDM(t, srv, rubrik.Options{})
+		if tc.name == "rsc" {
+			api = *newRSC(t, srv)
+			clusters, err := api.GetClusters(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			api = api.ForCluster(clusters[0])
+		}
+		tags, err := api.GetVMTags(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(tags) != 3 {
+			t.Errorf("%s: tags of %d VMs, want 3", tc.name, len(tags))
+		}
+		if n := srv.Requests(tc.key); n != 3 {
+			t.Errorf("%s: %d pages of tags requested, want 3", tc.name, n)
+		}
+		if got := tags[tc.id]; got["Owner"] != "alice" || got["Team"] != "web" || got["Environment"] != "prod" {
+			t.Errorf("%s: tags of %s = %v", tc.name, tc.id, got)
+		}
+	}
+
+	// REST has no tags
+	srv.Inject("graphql", rubriktest.GraphQLError)
+	if _, err := newCDM(t, srv, rubrik.Options{}).GetVMTags(context.Background()); err == nil {
+		t.Error("GetVMTags() without GraphQL succeeded")
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	srv := rubriktest.NewServer()
+	dir := t.TempDir()
+
+	recorded, err := newCDM(t, srv, rubrik.Options{RecordDir: dir}).GetNodes(context.Background())
+	srv.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) == 0 {
+		t.Fatal("nothing recorded")
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{rubriktest.Password, "token-1"} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("%s contains %q", filepath.Base(file), secret)
+			}
+		}
+	}
+
+	// The server is gone, everything has to come from the capture
+	api := rubrik.NewRubrik(context.Background(), "https://replay.invalid", "", rubrik.StaticSecret(""), "", rubrik.StaticSecret(""), rubrik.Options{ReplayDir: dir})
+	replayed, err := api.GetNodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != len(recorded) || replayed[0].ID != recorded[0].ID {
+		t.Errorf("replayed nodes %+v, recorded %+v", replayed, recorded)
+	}
+}
+
+func TestLogsWithoutSecrets(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject("graphql", rubriktest.NotFound)
+
+	var buf bytes.Buffer
+	h, err := logging.NewHandler(&buf, slog.LevelDebug, logging.FormatLogfmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(h))
+
+	api := newCDM(t, srv, rubrik.Options{})
+	srv.ExpireSession()
+	if _, err := api.GetNodes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := buf.String()
+	for _, secret := range []string{rubriktest.Password, "token-1", "token-2"} {
+		if strings.Contains(logs, secret) {
+			t.Errorf("logs contain %q:\n%s", secret, logs)
+		}
+	}
+	if !strings.Contains(logs, "backend=rest endpoint=/api/internal/node") {
+		t.Errorf("API request logs carry no backend and endpoint:\n%s", logs)
+	}
+}
@@ -0,0 +1,65 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"context"
+	"sync"
+)
+
+// scrapeCache - Answers of queries several calls of one scrape are derived
+// from, by query and cluster
+type scrapeCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	once  sync.Once
+	value interface{}
+	err   error
+}
+
+type scrapeCacheKey struct{}
+
+// WithScrapeCache - Returns a context whose calls share the queries they
+// have in common, e.g. the Rubrik Security Cloud cluster stats behind the
+// system storage, the runway and the storage growth. A ctx that already
+// shares them is kept.
+func WithScrapeCache(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(scrapeCacheKey{}).(*scrapeCache); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, scrapeCacheKey{}, &scrapeCache{entries: map[string]*cacheEntry{}})
+}
+
+// cached - Run fn once per key for a ctx from WithScrapeCache, every time
+// without one. A failure is shared as well, the calls after it would most
+// likely fail the same way.
+func cached[T any](ctx context.Context, key string, fn func() (T, error)) (T, error) {
+	c, ok := ctx.Value(scrapeCacheKey{}).(*scrapeCache)
+	if !ok {
+		return fn()
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = &cacheEntry{}
+		c.entries[key] = e
+	}
+	c.mu.Unlock()
+
+	e.once.Do(func() {
+		e.value, e.err = fn()
+	})
+	return e.value.(T), e.err
+}
@@ -0,0 +1,179 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SecretSource - Provides a password or client secret. Login asks the source
+// again whenever authentication fails, so a rotated secret is picked up
+// without restarting the exporter. Login holds the session lock while it
+// reads the secret, a source reaching out to a server has to give up once
+// ctx ends.
+type SecretSource interface {
+	Secret(ctx context.Context) (string, error)
+}
+
+// StaticSecret - A secret given directly, e.g. on the command line
+type StaticSecret string
+
+// Secret ...
+func (s StaticSecret) Secret(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// FileSecret - A secret read from a file, surrounding whitespace is removed
+type FileSecret string
+
+// Secret ...
+func (s FileSecret) Secret(ctx context.Context) (string, error) {
+	data, err := os.ReadFile(string(s))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// EnvSecret - A secret read from an environment variable
+type EnvSecret string
+
+// Secret ...
+func (s EnvSecret) Secret(ctx context.Context) (string, error) {
+	value, ok := os.LookupEnv(string(s))
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", string(s))
+	}
+	return value, nil
+}
+
+// SystemdCredential - A secret passed with LoadCredential= or
+// SetCredentialEncrypted= in the systemd unit, read from
+// $CREDENTIALS_DIRECTORY
+type SystemdCredential string
+
+// Secret ...
+func (s SystemdCredential) Secret(ctx context.Context) (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", fmt.Errorf("systemd credential %s requested but $CREDENTIALS_DIRECTORY is not set", string(s))
+	}
+	return FileSecret(filepath.Join(dir, string(s))).Secret(ctx)
+}
+
+// VaultSecret - A secret stored in a HashiCorp Vault KV engine. Both KV
+// version 1 and 2 responses are understood, for version 2 Path has to
+// include the data/ segment, e.g. secret/data/rubrik.
+type VaultSecret struct {
+	// Address of the Vault server, defaults to $VAULT_ADDR
+	Address string
+	// Token used to authenticate, defaults to $VAULT_TOKEN
+	Token string
+	Path  string
+	Field string
+}
+
+// Secret ...
+func (s VaultSecret) Secret(ctx context.Context) (string, error) {
+	address := s.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	token := s.Token
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if address == "" {
+		return "", fmt.Errorf("no Vault address for %s, set $VAULT_ADDR", s.Path)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(address, "/")+"/v1/"+strings.TrimPrefix(s.Path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	netClient := http.Client{Timeout: 10 * time.Second}
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Vault returned HTTP %d for %s", resp.StatusCode, s.Path)
+	}
+
+	var result struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode Vault response for %s: %v", s.Path, err)
+	}
+
+	data := result.Data
+	// KV version 2 nests the secret in data.data
+	if nested, ok := data["data"]; ok {
+		var inner map[string]json.RawMessage
+		if err := json.Unmarshal(nested, &inner); err == nil {
+			data = inner
+		}
+	}
+
+	raw, ok := data[s.Field]
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s has no field %s", s.Path, s.Field)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("Vault secret %s field %s is not a string", s.Path, s.Field)
+	}
+	return value, nil
+}
+
+// ParseSecretSource - Create a SecretSource from a flag value.
+//
+//	file:///run/secrets/rubrik    read the file
+//	env://RUBRIK_PASSWORD         read the environment variable
+//	systemd://rubrik-password     read the systemd credential
+//	vault://secret/data/rubrik#password
+//	                              read field password from Vault
+//
+// Any other value is used as the secret itself.
+func ParseSecretSource(spec string) (SecretSource, error) {
+	scheme, ref, found := strings.Cut(spec, "://")
+	if !found {
+		return StaticSecret(spec), nil
+	}
+
+	switch scheme {
+	case "file":
+		return FileSecret(ref), nil
+	case "env":
+		return EnvSecret(ref), nil
+	case "systemd":
+		return SystemdCredential(ref), nil
+	case "vault":
+		path, field, found := strings.Cut(ref, "#")
+		if !found || path == "" || field == "" {
+			return nil, fmt.Errorf("vault secret %q must look like vault://<path>#<field>", spec)
+		}
+		return VaultSecret{Path: path, Field: field}, nil
+	}
+	return StaticSecret(spec), nil
+}
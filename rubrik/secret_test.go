@@ -0,0 +1,143 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubriktest"
+)
+
+func TestParseSecretSource(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want rubrik.SecretSource
+		err  bool
+	}{
+		{spec: "", want: rubrik.StaticSecret("")},
+		{spec: "s3cr3t", want: rubrik.StaticSecret("s3cr3t")},
+		{spec: "pa://ss", want: rubrik.StaticSecret("pa://ss")},
+		{spec: "file:///run/secrets/rubrik", want: rubrik.FileSecret("/run/secrets/rubrik")},
+		{spec: "env://RUBRIK_PASSWORD", want: rubrik.EnvSecret("RUBRIK_PASSWORD")},
+		{spec: "systemd://rubrik-password", want: rubrik.SystemdCredential("rubrik-password")},
+		{spec: "vault://secret/data/rubrik#password", want: rubrik.VaultSecret{Path: "secret/data/rubrik", Field: "password"}},
+		{spec: "vault://secret/data/rubrik", err: true},
+		{spec: "vault://#password", err: true},
+		{spec: "vault://secret/data/rubrik#", err: true},
+	} {
+		got, err := rubrik.ParseSecretSource(tc.spec)
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("ParseSecretSource(%q) = %#v, %v", tc.spec, got, err)
+		}
+	}
+}
+
+func TestVaultSecret(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/rubrik":
+			w.Write([]byte(`{"data":{"password":"v1-secret","port":443}}`))
+		case "/v1/secret/data/rubrik":
+			w.Write([]byte(`{"data":{"data":{"password":"v2-secret"},"metadata":{"version":3}}}`))
+		default:
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name        string
+		path, field string
+		token       string
+		want        string
+		err         string
+	}{
+		{name: "KV v1", path: "kv/rubrik", field: "password", want: "v1-secret"},
+		{name: "KV v2", path: "secret/data/rubrik", field: "password", want: "v2-secret"},
+		{name: "missing field", path: "secret/data/rubrik", field: "client_secret", err: "has no field client_secret"},
+		{name: "not a string", path: "kv/rubrik", field: "port", err: "is not a string"},
+		{name: "not found", path: "kv/other", field: "password", err: "HTTP 404"},
+		{name: "wrong token", path: "kv/rubrik", field: "password", token: "other", err: "HTTP 403"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			token := tc.token
+			if token == "" {
+				token = "vault-token"
+			}
+			got, err := rubrik.VaultSecret{Address: srv.URL, Token: token, Path: tc.path, Field: tc.field}.Secret(context.Background())
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("Secret() = %q, %v, want error %q", got, err, tc.err)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("Secret() = %q, %v, want %q", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestVaultSecretCanceled(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := (rubrik.VaultSecret{Address: srv.URL, Path: "kv/rubrik", Field: "password"}).Secret(ctx); err == nil {
+		t.Fatal("Secret() of a hanging Vault succeeded")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Secret() returned after %s, not when ctx ended", d)
+	}
+}
+
+func TestRotatedFileSecret(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("outdated\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	api := rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.FileSecret(path), "", rubrik.StaticSecret(""), rubrik.Options{})
+	if api.Status().LoggedIn || srv.Logins() != 0 {
+		t.Fatal("logged in with the outdated password")
+	}
+
+	// The next login reads the file again
+	if err := os.WriteFile(path, []byte(rubriktest.Password+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Login(context.Background()); err != nil {
+		t.Fatalf("Login() after the rotation: %v", err)
+	}
+	if !api.Status().LoggedIn || srv.Logins() != 1 {
+		t.Errorf("not logged in after the rotation, %d logins", srv.Logins())
+	}
+}
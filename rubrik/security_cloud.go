@@ -0,0 +1,441 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+// rscNullClusterID is the pseudo cluster RSC uses for cloud native workloads
+const rscNullClusterID = "00000000-0000-0000-0000-000000000000"
+
+// rscPageSize is the number of objects requested per page of a connection
+const rscPageSize = 500
+
+// GraphQL queries for Rubrik Security Cloud. The RSC schema spans all
+// clusters of an account, so every object query is filtered by cluster.
+const (
+	// Get all clusters registered with the account
+	RSCClustersQuery = `
+	query RscClusters($first: Int, $after: String) {
+		clusterConnection(first: $first, after: $after) {
+			nodes {
+				id
+				name
+				version
+			}
+			pageInfo {
+				hasNextPage
+				endCursor
+			}
+		}
+	}`
+
+	// Get capacity and runway of a cluster
+	RSCClusterStatsQuery = `
+	query RscClusterStats($clusterUuid: UUID!) {
+		cluster(clusterUuid: $clusterUuid) {
+			estimatedRunway
+			metric {
+				totalCapacity
+				usedCapacity
+				availableCapacity
+				snapshotCapacity
+				liveSnapshotCapacity
+				miscellaneousCapacity
+				averageDailyGrowth
+			}
+		}
+	}`
+
+	// Get nodes of a cluster
+	RSCClusterNodesQuery = `
+	query RscClusterNodes($clusterUuid: UUID!) {
+		cluster(clusterUuid: $clusterUuid) {
+			clusterNodeConnection {
+				nodes {
+					id
+					brikId
+					status
+					ipAddress
+				}
+			}
+		}
+	}`
+
+	// Get vSphere VMs of a cluster
+	RSCVsphereVMsQuery = `
+	query RscVsphereVms($first: Int, $after: String, $clusterId: String!) {
+		vSphereVmNewConnection(first: $first, after: $after, filter: [
+			{field: CLUSTER_ID, texts: [$clusterId]},
+			{field: IS_RELIC, texts: ["false"]},
+			{field: IS_REPLICATED, texts: ["false"]}
+		]) {
+			nodes {
+				id
+				name
+				effectiveSlaDomain {
+					id
+					name
+				}
+				logicalPath {
+					name
+					objectType
+				}
+			}
+			pageInfo {
+				hasNextPage
+				endCursor
+			}
+		}
+	}`
+
+	// Get Nutanix VMs of a cluster
+	RSCNutanixVMsQuery = `
+	query RscNutanixVms($first: Int, $after: String, $clusterId: String!) {
+		nutanixVms(first: $first, after: $after, filter: [
+			{field: CLUSTER_ID, texts: [$clusterId]},
+			{field: IS_RELIC, texts: ["false"]},
+			{field: IS_REPLICATED, texts: ["false"]}
+		]) {
+			nodes {
+				id
+				name
+				effectiveSlaDomain {
+					id
+					name
+				}
+			}
+			pageInfo {
+				hasNextPage
+				endCursor
+			}
+		}
+	}`
+
+	// Get Hyper-V VMs of a cluster
+	RSCHypervVMsQuery = `
+	query RscHypervVms($first: Int, $after: String, $clusterId: String!) {
+		hypervVirtualMachines(first: $first, after: $after, filter: [
+			{field: CLUSTER_ID, texts: [$clusterId]},
+			{field: IS_RELIC, texts: ["false"]},
+			{field: IS_REPLICATED, texts: ["false"]}
+		]) {
+			nodes {
+				id
+				name
+				effectiveSlaDomain {
+					id
+					name
+				}
+			}
+			pageInfo {
+				hasNextPage
+				endCursor
+			}
+		}
+	}`
+
+	// Get managed volumes of a cluster
+	RSCManagedVolumesQuery = `
+	query RscManagedVolumes($first: Int, $after: String, $clusterId: String!) {
+		managedVolumes(first: $first, after: $after, filter: [
+			{field: CLUSTER_ID, texts: [$clusterId]},
+			{field: IS_RELIC, texts: ["false"]}
+		]) {
+			nodes {
+				id
+				name
+				state
+				numChannels
+				volumeSize
+				usedSize
+				snapshotCount
+				isRelic
+				effectiveSlaDomain {
+					id
+					name
+				}
+			}
+			pageInfo {
+				hasNextPage
+				endCursor
+			}
+		}
+	}`
+
+	// Get archival locations of a cluster
+	RSCArchiveLocationsQuery = `
+	query RscArchiveLocations($first: Int, $after: String, $clusterId: String!) {
+		targets(first: $first, after: $after, filter: [
+			{field: CLUSTER_ID, texts: [$clusterId]}
+		]) {
+			nodes {
+				id
+				name
+				targetType
+				status
+			}
+			pageInfo {
+				hasNextPage
+				endCursor
+			}
+		}
+	}`
+)
+
+type rscPageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+type rscConnection struct {
+	Nodes    json.RawMessage `json:"nodes"`
+	PageInfo rscPageInfo     `json:"pageInfo"`
+}
+
+type rscSLADomain struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type rscVirtualMachine struct {
+	ID                 string        `json:"id"`
+	Name               string        `json:"name"`
+	EffectiveSlaDomain *rscSLADomain `json:"effectiveSlaDomain"`
+	// Only queried for vSphere VMs
+	LogicalPath []pathNode `json:"logicalPath"`
+}
+
+// RSC cluster stats response
+type RSCClusterStatsResponse struct {
+	Cluster struct {
+		EstimatedRunway int `json:"estimatedRunway"`
+		Metric          struct {
+			TotalCapacity         int     `json:"totalCapacity"`
+			UsedCapacity          int     `json:"usedCapacity"`
+			AvailableCapacity     int     `json:"availableCapacity"`
+			SnapshotCapacity      int     `json:"snapshotCapacity"`
+			LiveSnapshotCapacity  int     `json:"liveSnapshotCapacity"`
+			MiscellaneousCapacity int     `json:"miscellaneousCapacity"`
+			AverageDailyGrowth    float64 `json:"averageDailyGrowth"`
+		} `json:"metric"`
+	} `json:"cluster"`
+}
+
+// RSC cluster nodes response
+type RSCClusterNodesResponse struct {
+	Cluster struct {
+		ClusterNodeConnection struct {
+			Nodes []Node `json:"nodes"`
+		} `json:"clusterNodeConnection"`
+	} `json:"cluster"`
+}
+
+// rscPaginate runs a connection query page by page and hands the nodes of
+// every page to fn. root is the name of the connection field in the query.
+func (r Rubrik) rscPaginate(ctx context.Context, query string, root string, variables map[string]interface{}, fn func(nodes json.RawMessage) error) error {
+	vars := map[string]interface{}{"first": rscPageSize}
+	for k, v := range variables {
+		vars[k] = v
+	}
+
+	for {
+		var response map[string]rscConnection
+		if err := r.graphqlClient.ExecuteQuery(ctx, query, vars, &response); err != nil {
+			return err
+		}
+		conn, ok := response[root]
+		if !ok {
+			return fmt.Errorf("response has no %s field", root)
+		}
+		if len(conn.Nodes) > 0 {
+			if err := fn(conn.Nodes); err != nil {
+				return err
+			}
+		}
+		if !conn.PageInfo.HasNextPage || conn.PageInfo.EndCursor == "" {
+			return nil
+		}
+		vars["after"] = conn.PageInfo.EndCursor
+	}
+}
+
+// rscGetClusters ...
+func (r Rubrik) rscGetClusters(ctx context.Context) ([]Cluster, error) {
+	var clusters []Cluster
+	err := r.rscPaginate(ctx, RSCClustersQuery, "clusterConnection", nil, func(nodes json.RawMessage) error {
+		var page []Cluster
+		if err := json.Unmarshal(nodes, &page); err != nil {
+			return err
+		}
+		for _, c := range page {
+			if c.ID != rscNullClusterID {
+				clusters = append(clusters, c)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Listing the clusters failed", "err", err)
+		return []Cluster{}, err
+	}
+	return clusters, nil
+}
+
+// rscGetClusterStats - System storage, runway and growth come from the same
+// query, it is sent once per cluster and scrape
+func (r Rubrik) rscGetClusterStats(ctx context.Context) (RSCClusterStatsResponse, error) {
+	return cached(ctx, "RscClusterStats/"+r.cluster.ID, func() (RSCClusterStatsResponse, error) {
+		var response RSCClusterStatsResponse
+		err := r.graphqlClient.ExecuteQuery(ctx, RSCClusterStatsQuery,
+			map[string]interface{}{"clusterUuid": r.cluster.ID}, &response)
+		if err != nil {
+			slog.WarnContext(ctx, "Querying the cluster stats failed", "err", err)
+		}
+		return response, err
+	})
+}
+
+// rscGetSystemStorage ...
+func (r Rubrik) rscGetSystemStorage(ctx context.Context) (SystemStorage, error) {
+	response, err := r.rscGetClusterStats(ctx)
+	if err != nil {
+		return SystemStorage{}, err
+	}
+	m := response.Cluster.Metric
+	return SystemStorage{
+		Total:         m.TotalCapacity,
+		Used:          m.UsedCapacity,
+		Available:     m.AvailableCapacity,
+		Snapshot:      m.SnapshotCapacity,
+		LiveMount:     m.LiveSnapshotCapacity,
+		Miscellaneous: m.MiscellaneousCapacity,
+	}, nil
+}
+
+// rscGetNodes ...
+func (r Rubrik) rscGetNodes(ctx context.Context) ([]Node, error) {
+	var response RSCClusterNodesResponse
+	err := r.graphqlClient.ExecuteQuery(ctx, RSCClusterNodesQuery,
+		map[string]interface{}{"clusterUuid": r.cluster.ID}, &response)
+	if err != nil {
+		slog.WarnContext(ctx, "Querying the nodes failed", "err", err)
+		return []Node{}, err
+	}
+	return response.Cluster.ClusterNodeConnection.Nodes, nil
+}
+
+// rscListVM ...
+func (r Rubrik) rscListVM(ctx context.Context, query string, root string) ([]VirtualMachine, error) {
+	var vms []VirtualMachine
+	err := r.rscPaginate(ctx, query, root, map[string]interface{}{"clusterId": r.cluster.ID}, func(nodes json.RawMessage) error {
+		var page []rscVirtualMachine
+		if err := json.Unmarshal(nodes, &page); err != nil {
+			return err
+		}
+		for _, vm := range page {
+			slaID, slaName := "", ""
+			if vm.EffectiveSlaDomain != nil {
+				slaID, slaName = vm.EffectiveSlaDomain.ID, vm.EffectiveSlaDomain.Name
+			}
+			vms = append(vms, VirtualMachine{
+				ID:                     vm.ID,
+				Name:                   vm.Name,
+				EffectiveSLADomainID:   slaID,
+				EffectiveSLADomainName: slaName,
+				Folder:                 folderPath(vm.LogicalPath),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Listing the VMs failed", "query", root, "err", err)
+		return []VirtualMachine{}, err
+	}
+	return vms, nil
+}
+
+// rscGetManagedVolumes ...
+func (r Rubrik) rscGetManagedVolumes(ctx context.Context) ([]ManagedVolume, error) {
+	var volumes []ManagedVolume
+	err := r.rscPaginate(ctx, RSCManagedVolumesQuery, "managedVolumes", map[string]interface{}{"clusterId": r.cluster.ID}, func(nodes json.RawMessage) error {
+		var page []struct {
+			ID                 string        `json:"id"`
+			Name               string        `json:"name"`
+			State              string        `json:"state"`
+			NumChannels        float64       `json:"numChannels"`
+			VolumeSize         float64       `json:"volumeSize"`
+			UsedSize           float64       `json:"usedSize"`
+			SnapshotCount      float64       `json:"snapshotCount"`
+			EffectiveSlaDomain *rscSLADomain `json:"effectiveSlaDomain"`
+		}
+		if err := json.Unmarshal(nodes, &page); err != nil {
+			return err
+		}
+		for _, mv := range page {
+			volume := ManagedVolume{
+				ID:               mv.ID,
+				Name:             mv.Name,
+				State:            mv.State,
+				NumChannels:      mv.NumChannels,
+				VolumeSize:       mv.VolumeSize,
+				UsedSize:         mv.UsedSize,
+				SnapshotCount:    mv.SnapshotCount,
+				PrimaryClusterID: r.cluster.ID,
+			}
+			if mv.EffectiveSlaDomain != nil {
+				volume.EffectiveSLADomainID = mv.EffectiveSlaDomain.ID
+				volume.EffectiveSLADomainName = mv.EffectiveSlaDomain.Name
+			}
+			volumes = append(volumes, volume)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Listing the managed volumes failed", "err", err)
+		return []ManagedVolume{}, err
+	}
+	return volumes, nil
+}
+
+// rscGetArchiveLocations ...
+func (r Rubrik) rscGetArchiveLocations(ctx context.Context) ([]Location, error) {
+	var locations []Location
+	err := r.rscPaginate(ctx, RSCArchiveLocationsQuery, "targets", map[string]interface{}{"clusterId": r.cluster.ID}, func(nodes json.RawMessage) error {
+		var page []struct {
+			ID         string `json:"id"`
+			Name       string `json:"name"`
+			TargetType string `json:"targetType"`
+			Status     string `json:"status"`
+		}
+		if err := json.Unmarshal(nodes, &page); err != nil {
+			return err
+		}
+		for _, t := range page {
+			locations = append(locations, Location{
+				ID:           t.ID,
+				Name:         t.Name,
+				LocationType: t.TargetType,
+				IsActive:     t.Status == "ACTIVE",
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Listing the archive locations failed", "err", err)
+		return []Location{}, err
+	}
+	return locations, nil
+}
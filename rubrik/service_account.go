@@ -0,0 +1,96 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ServiceAccount - Credentials file Rubrik offers for download when a
+// service account is created
+type ServiceAccount struct {
+	ClientID       string `json:"client_id"`
+	ClientSecret   string `json:"client_secret"`
+	Name           string `json:"name"`
+	AccessTokenURI string `json:"access_token_uri"`
+
+	// path of the file the credentials were read from
+	path string
+}
+
+// ReadServiceAccountFile - Load service account credentials from a JSON file
+func ReadServiceAccountFile(path string) (ServiceAccount, error) {
+	var sa ServiceAccount
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sa, err
+	}
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return sa, fmt.Errorf("failed to parse service account file %s: %v", path, err)
+	}
+	sa.path = path
+	if sa.ClientID == "" || sa.ClientSecret == "" {
+		return sa, fmt.Errorf("service account file %s has no client_id or client_secret", path)
+	}
+	if sa.AccessTokenURI != "" {
+		if _, err := url.ParseRequestURI(sa.AccessTokenURI); err != nil {
+			return sa, fmt.Errorf("service account file %s has an invalid access_token_uri: %v", path, err)
+		}
+	}
+	return sa, nil
+}
+
+// secretSource - A file based account re-reads its file so a rotated
+// client secret is picked up
+func (sa ServiceAccount) secretSource() SecretSource {
+	if sa.path != "" {
+		return serviceAccountFileSecret(sa.path)
+	}
+	return StaticSecret(sa.ClientSecret)
+}
+
+// serviceAccountFileSecret - The client secret of a service account file
+type serviceAccountFileSecret string
+
+// Secret ...
+func (s serviceAccountFileSecret) Secret(ctx context.Context) (string, error) {
+	sa, err := ReadServiceAccountFile(string(s))
+	if err != nil {
+		return "", err
+	}
+	return sa.ClientSecret, nil
+}
+
+// BaseURL - Returns scheme and host of the access token URI, which is the
+// API endpoint the service account belongs to
+func (sa ServiceAccount) BaseURL() string {
+	u, err := url.Parse(sa.AccessTokenURI)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// IsSecurityCloud - Reports whether the service account was issued by
+// Rubrik Security Cloud rather than a CDM cluster
+func (sa ServiceAccount) IsSecurityCloud() bool {
+	u, err := url.Parse(sa.AccessTokenURI)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(u.Hostname(), ".my.rubrik.com")
+}
@@ -11,13 +11,18 @@
 package rubrik
 
 import (
-	"crypto/tls"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/logging"
 )
 
 type Session struct {
@@ -34,40 +39,153 @@ type OAuth2TokenResponse struct {
 	Scope       string `json:"scope"`
 }
 
-func (r *Rubrik) Login() error {
+// authState is shared by every copy of a Rubrik instance (see ForCluster),
+// so a re-login done by one collector is seen by all of them
+type authState struct {
+	mu sync.Mutex
+
+	sessionToken string
+	isLoggedIn   bool
+	status       SessionStatus
+
+	// Last values read from the secret sources
+	password     string
+	clientSecret string
+}
+
+// Login - Authenticate with the configured method. If that fails the
+// password or client secret is read again from its SecretSource and the
+// login is retried, so a rotated secret doesn't need an exporter restart.
+func (r *Rubrik) Login(ctx context.Context) error {
+	r.auth.mu.Lock()
+	defer r.auth.mu.Unlock()
+	return r.login(ctx)
+}
+
+// login - Login and retry with re-read secrets. Must be called with auth.mu
+// held.
+func (r *Rubrik) login(ctx context.Context) error {
+	err := r.authenticate(ctx)
+	if err == nil {
+		return nil
+	}
+
+	changed, rerr := r.reloadSecrets(ctx)
+	if rerr != nil {
+		slog.ErrorContext(ctx, "Login failed and the credentials could not be re-read", "err", rerr)
+	} else if changed {
+		slog.InfoContext(ctx, "Login failed, retrying with re-read credentials")
+		err = r.authenticate(ctx)
+	}
+
+	if err != nil {
+		r.auth.status.LoggedIn = false
+		r.auth.status.LastError = logging.Redact(err.Error())
+		r.auth.status.LastErrorTime = time.Now()
+	}
+	return err
+}
+
+// relogin - Login again after the API rejected token. Concurrent callers
+// that saw the same expired token share a single login.
+func (r *Rubrik) relogin(ctx context.Context, token string) error {
+	r.auth.mu.Lock()
+	defer r.auth.mu.Unlock()
+
+	// Another caller already logged in while this one waited for the lock
+	if r.auth.sessionToken != token {
+		return nil
+	}
+	slog.InfoContext(ctx, "Session token rejected, logging in again")
+	return r.login(ctx)
+}
+
+// reloadSecrets - Read password and client secret from their sources.
+// Reports whether any value changed. Must be called with auth.mu held.
+func (r *Rubrik) reloadSecrets(ctx context.Context) (bool, error) {
+	changed := false
+	if r.passwordSource != nil {
+		password, err := r.passwordSource.Secret(ctx)
+		if err != nil {
+			return changed, fmt.Errorf("failed to read password: %v", err)
+		}
+		changed = changed || password != r.auth.password
+		r.auth.password = password
+	}
+	if r.clientSecretSource != nil {
+		secret, err := r.clientSecretSource.Secret(ctx)
+		if err != nil {
+			return changed, fmt.Errorf("failed to read client secret: %v", err)
+		}
+		changed = changed || secret != r.auth.clientSecret
+		r.auth.clientSecret = secret
+	}
+	return changed, nil
+}
+
+// setSession - Store the token of a successful login, expiresIn is its
+// lifetime in seconds if the login answer tells it. Must be called with
+// auth.mu held.
+func (r *Rubrik) setSession(token string, expiresIn int) {
+	r.auth.sessionToken = token
+	r.auth.isLoggedIn = true
+	r.auth.status.LoggedIn = true
+	r.auth.status.LoginTime = time.Now()
+	r.auth.status.ExpiresAt = tokenExpiry(token, expiresIn)
+	if r.graphqlClient != nil {
+		r.graphqlClient.SetToken(token)
+	}
+}
+
+// token - Returns the current session token
+func (r *Rubrik) token() string {
+	r.auth.mu.Lock()
+	defer r.auth.mu.Unlock()
+	return r.auth.sessionToken
+}
+
+// authenticate - Run the login method matching the configured credentials.
+// Must be called with auth.mu held.
+func (r *Rubrik) authenticate(ctx context.Context) error {
+	// Rubrik Security Cloud only supports service accounts
+	if r.securityCloud {
+		slog.DebugContext(ctx, "Logging in", "method", "security_cloud_service_account")
+		return r.loginWithSecurityCloud(ctx)
+	}
+
 	// Check if service account authentication is being used
-	if r.serviceAccountClientID != "" && r.serviceAccountClientSecret != "" {
-		log.Print("Using service account authentication")
-		return r.loginWithServiceAccount()
+	if r.serviceAccountClientID != "" && r.auth.clientSecret != "" {
+		slog.DebugContext(ctx, "Logging in", "method", "service_account")
+		return r.loginWithServiceAccount(ctx)
 	}
 
 	// Fall back to username/password authentication
-	log.Print("Using username/password authentication")
-	return r.loginWithUsernamePassword()
+	slog.DebugContext(ctx, "Logging in", "method", "username_password")
+	return r.loginWithUsernamePassword(ctx)
 }
 
-func (r *Rubrik) loginWithServiceAccount() error {
+func (r *Rubrik) loginWithServiceAccount(ctx context.Context) error {
 	// Try OAuth2 client credentials flow first
-	if err := r.tryOAuth2ClientCredentials(); err == nil {
+	err := r.tryOAuth2ClientCredentials(ctx)
+	if err == nil {
 		return nil
 	}
 
-	log.Print("OAuth2 client credentials failed, trying basic auth with service account credentials")
+	slog.InfoContext(ctx, "OAuth2 client credentials failed, trying basic auth with the service account credentials", "err", err)
 	// Fall back to basic auth with client_id/client_secret
-	return r.tryServiceAccountBasicAuth()
+	return r.tryServiceAccountBasicAuth(ctx)
 }
 
-func (r *Rubrik) tryOAuth2ClientCredentials() error {
-	_url := r.url + "/api/client_token"
+func (r *Rubrik) tryOAuth2ClientCredentials(ctx context.Context) error {
+	_url := r.tokenURL()
 
-	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	var netClient = http.Client{Transport: tr}
+	netClient := r.httpClient()
 
 	// Prepare Rubrik service account request
 	data := map[string]string{
 		"grant_type":    "client_credentials",
 		"client_id":     r.serviceAccountClientID,
-		"client_secret": r.serviceAccountClientSecret,
+		"client_secret": r.auth.clientSecret,
 	}
 
 	// Create form-encoded body
@@ -76,7 +194,7 @@ func (r *Rubrik) tryOAuth2ClientCredentials() error {
 		values.Set(key, value)
 	}
 
-	req, err := http.NewRequest("POST", _url, strings.NewReader(values.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", _url, strings.NewReader(values.Encode()))
 	if err != nil {
 		return err
 	}
@@ -100,26 +218,70 @@ func (r *Rubrik) tryOAuth2ClientCredentials() error {
 		return fmt.Errorf("failed to decode token response: %v", err)
 	}
 
-	r.sessionToken = tokenResp.AccessToken
-	r.isLoggedIn = true
+	r.setSession(tokenResp.AccessToken, tokenResp.ExpiresIn)
+
+	slog.InfoContext(ctx, "Logged in", "method", "service_account", "url", _url)
+	return nil
+}
+
+// loginWithSecurityCloud - Exchange the service account credentials for an
+// access token. Unlike CDM, Rubrik Security Cloud expects a JSON body.
+func (r *Rubrik) loginWithSecurityCloud(ctx context.Context) error {
+	_url := r.tokenURL()
+
+	netClient := r.httpClient()
+
+	body, err := json.Marshal(map[string]string{
+		"client_id":     r.serviceAccountClientID,
+		"client_secret": r.auth.clientSecret,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", _url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := netClient.Do(req)
+	if err != nil {
+		slog.ErrorContext(ctx, "Login failed", "method", "security_cloud_service_account", "err", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		slog.ErrorContext(ctx, "Login failed", "method", "security_cloud_service_account", "status", resp.StatusCode)
+		return fmt.Errorf("Rubrik Security Cloud authentication failed: HTTP %d", resp.StatusCode)
+	}
+
+	var tokenResp OAuth2TokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&tokenResp)
+	if err != nil {
+		return fmt.Errorf("failed to decode token response: %v", err)
+	}
+
+	r.setSession(tokenResp.AccessToken, tokenResp.ExpiresIn)
 
-	log.Printf("Successfully authenticated with Rubrik service account (/api/client_token)")
+	slog.InfoContext(ctx, "Logged in", "method", "security_cloud_service_account")
 	return nil
 }
 
-func (r *Rubrik) tryServiceAccountBasicAuth() error {
+func (r *Rubrik) tryServiceAccountBasicAuth(ctx context.Context) error {
 	_url := r.url + "/api/v1/session"
 
-	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	var netClient = http.Client{Transport: tr}
+	netClient := r.httpClient()
 
-	req, err := http.NewRequest("POST", _url, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", _url, nil)
 	if err != nil {
 		return err
 	}
 
 	// Use client_id as username and client_secret as password
-	req.SetBasicAuth(r.serviceAccountClientID, r.serviceAccountClientSecret)
+	req.SetBasicAuth(r.serviceAccountClientID, r.auth.clientSecret)
 
 	resp, err := netClient.Do(req)
 	if err != nil {
@@ -138,46 +300,83 @@ func (r *Rubrik) tryServiceAccountBasicAuth() error {
 		return fmt.Errorf("failed to decode session response: %v", err)
 	}
 
-	r.sessionToken = s.Token
-	r.isLoggedIn = true
+	r.setSession(s.Token, 0)
 
-	log.Printf("Successfully authenticated with service account basic auth")
+	slog.InfoContext(ctx, "Logged in", "method", "service_account_basic_auth")
 	return nil
 }
 
-func (r *Rubrik) loginWithUsernamePassword() error {
+func (r *Rubrik) loginWithUsernamePassword(ctx context.Context) error {
 	_url := r.url + "/api/v1/session"
 
-	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	var netClient = http.Client{Transport: tr}
-	req, err := http.NewRequest("POST", _url, nil)
+	netClient := r.httpClient()
+	req, err := http.NewRequestWithContext(ctx, "POST", _url, nil)
 
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
-	req.SetBasicAuth(r.username, r.password)
+	req.SetBasicAuth(r.username, r.auth.password)
 
 	resp, err := netClient.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		slog.ErrorContext(ctx, "Login failed", "method", "username_password", "err", err)
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		slog.ErrorContext(ctx, "Login failed", "method", "username_password", "status", resp.StatusCode)
+		return fmt.Errorf("username/password authentication failed: HTTP %d", resp.StatusCode)
+	}
+
 	data := json.NewDecoder(resp.Body)
 	var s Session
 	err = data.Decode(&s)
+	if err != nil {
+		return fmt.Errorf("failed to decode session response: %v", err)
+	}
 
-	r.sessionToken = s.Token
-	r.isLoggedIn = true
+	r.setSession(s.Token, 0)
 
 	return nil
 }
 
-func (r *Rubrik) Logout() {
-	resp, _ := r.makeRequest("DELETE", "/api/v1/session", RequestParams{})
-	if resp != nil {
-		resp.Body.Close()
+// Logout - Delete the session on the cluster
+func (r *Rubrik) Logout(ctx context.Context) error {
+	action := "/api/v1/session"
+	if r.securityCloud {
+		action = "/api/session"
+	}
+
+	r.auth.mu.Lock()
+	defer r.auth.mu.Unlock()
+
+	if !r.auth.isLoggedIn {
+		return nil
+	}
+
+	netClient := r.httpClient()
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", r.url+action, nil)
+	if err != nil {
+		return err
 	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", r.auth.sessionToken))
+
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("logout failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("logout failed: HTTP %d", resp.StatusCode)
+	}
+
+	r.auth.sessionToken = ""
+	r.auth.isLoggedIn = false
+	r.auth.status.LoggedIn = false
+
+	slog.InfoContext(ctx, "Logged out from Rubrik")
+	return nil
 }
@@ -0,0 +1,100 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/logging"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// startCall - Begin the span of an API call, ended by the caller. traceCall
+// with the returned context records the outcome on it, and its log records
+// carry the backend and the endpoint.
+func startCall(ctx context.Context, backend Backend, endpoint, name string) (context.Context, *tracing.Span) {
+	ctx = logging.With(ctx, "backend", string(backend), "endpoint", endpoint)
+	return tracing.Start(ctx, name, tracing.KindClient,
+		tracing.Attribute{Key: "rubrik.backend", Value: string(backend)},
+		tracing.Attribute{Key: "rubrik.endpoint", Value: endpoint},
+	)
+}
+
+// observeCall - Add c to the request duration histogram, with the trace ID
+// as exemplar if the call is sampled
+func observeCall(c Call, span *tracing.Span) {
+	result := "success"
+	if c.Err != nil {
+		result = "error"
+	}
+	observer := requestDuration.WithLabelValues(string(c.Backend), result)
+	if span.Sampled() {
+		observer.(prometheus.ExemplarObserver).ObserveWithExemplar(c.Duration.Seconds(), prometheus.Labels{"trace_id": span.TraceID()})
+		return
+	}
+	observer.Observe(c.Duration.Seconds())
+}
+
+// spanTransport - Sets the status and the body sizes of a request on the span
+// of its context. Retries happen below it, the span shows the final answer.
+type spanTransport struct {
+	next http.RoundTripper
+}
+
+func (t spanTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	span := tracing.SpanFromContext(req.Context())
+	if !span.Sampled() {
+		return t.next.RoundTrip(req)
+	}
+
+	span.SetAttribute("http.request.method", req.Method)
+	if req.ContentLength > 0 {
+		span.SetAttribute("http.request.body.size", req.ContentLength)
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttribute("http.response.status_code", int64(resp.StatusCode))
+	resp.Body = &countingBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+// countingBody - Sets the number of bytes read on the span once the body is
+// read to the end or closed
+type countingBody struct {
+	io.ReadCloser
+	span *tracing.Span
+	n    int64
+	once sync.Once
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.done()
+	}
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	b.done()
+	return b.ReadCloser.Close()
+}
+
+func (b *countingBody) done() {
+	b.once.Do(func() { b.span.SetAttribute("http.response.body.size", b.n) })
+}
@@ -13,8 +13,7 @@ package rubrik
 import (
 	"context"
 	"encoding/json"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/url"
 )
 
@@ -62,26 +61,25 @@ type DataLocationUsage struct {
 }
 
 // GetSystemStorage ...
-func (r Rubrik) GetSystemStorage() SystemStorage {
-	log.Printf("GetSystemStorage: Starting API call")
+func (r Rubrik) GetSystemStorage(ctx context.Context) (SystemStorage, error) {
+	if r.securityCloud {
+		return r.rscGetSystemStorage(ctx)
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
-		log.Printf("GetSystemStorage: Trying GraphQL")
+	if r.useGraphQL(ctx) {
 		var response SystemStorageResponse
-		err := r.graphqlClient.ExecuteQuery(context.Background(), SystemStorageQuery, nil, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, SystemStorageQuery, nil, &response)
 		if err == nil {
-			log.Printf("GetSystemStorage: GraphQL succeeded, total: %d, used: %d", response.System.Storage.Total, response.System.Storage.Used)
-			return response.System.Storage
+			slog.DebugContext(ctx, "System storage", "backend", BackendGraphQL, "total", response.System.Storage.Total, "used", response.System.Storage.Used)
+			return response.System.Storage, nil
 		}
-		log.Printf("GraphQL GetSystemStorage failed, falling back to REST: %v", err)
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "GetSystemStorage", "err", err)
 	}
 
 	// Fallback to REST API
-	log.Printf("GetSystemStorage: Falling back to REST API")
-	resp, err := r.makeRequest("GET", "/api/internal/stats/system_storage", RequestParams{})
-	if err != nil || resp == nil {
-		log.Printf("GetSystemStorage: REST API failed: %v", err)
-		return SystemStorage{}
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/stats/system_storage", RequestParams{})
+	if err != nil {
+		return SystemStorage{}, err
 	}
 	defer resp.Body.Close()
 
@@ -89,19 +87,23 @@ func (r Rubrik) GetSystemStorage() SystemStorage {
 	var d SystemStorage
 	err = data.Decode(&d)
 	if err != nil {
-		log.Printf("GetSystemStorage: Failed to decode REST response: %v", err)
-		return SystemStorage{}
+		slog.WarnContext(ctx, "Decoding Rubrik API response failed", "call", "GetSystemStorage", "err", err)
+		return SystemStorage{}, err
 	}
-	log.Printf("GetSystemStorage: REST succeeded, total: %d, used: %d", d.Total, d.Used)
-	return d
+	slog.DebugContext(ctx, "System storage", "backend", BackendREST, "total", d.Total, "used", d.Used)
+	return d, nil
 }
 
 // GetPerVMStorage ...
-func (r Rubrik) GetPerVMStorage() []VmStorage {
+func (r Rubrik) GetPerVMStorage(ctx context.Context) ([]VmStorage, error) {
+	// Per VM storage stats are only available from the CDM API
+	if r.securityCloud {
+		return []VmStorage{}, nil
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
+	if r.useGraphQL(ctx) {
 		var response PerVMStorageResponse
-		err := r.graphqlClient.ExecuteQuery(context.Background(), PerVMStorageQuery, nil, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, PerVMStorageQuery, nil, &response)
 		if err == nil {
 			// Convert GraphQL response to VmStorage structs
 			storages := make([]VmStorage, len(response.VmwareVms.Edges))
@@ -115,65 +117,71 @@ func (r Rubrik) GetPerVMStorage() []VmStorage {
 					IndexStorageBytes:      edge.Node.IndexStorageBytes,
 				}
 			}
-			return storages
+			return storages, nil
 		}
-		log.Printf("GraphQL GetPerVMStorage failed, falling back to REST: %v", err)
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "GetPerVMStorage", "err", err)
 	}
 
 	// Fallback to REST API
-	resp, err := r.makeRequest("GET", "/api/internal/stats/per_vm_storage", RequestParams{})
-	if err != nil || resp == nil {
-		return []VmStorage{}
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/stats/per_vm_storage", RequestParams{})
+	if err != nil {
+		return []VmStorage{}, err
 	}
 	defer resp.Body.Close()
 
 	data := json.NewDecoder(resp.Body)
 	var d VmStorageList
-	data.Decode(&d)
+	if err := data.Decode(&d); err != nil {
+		return []VmStorage{}, err
+	}
 
-	return d.Data
+	return d.Data, nil
 }
 
 // GetStreamCount ...
-func (r Rubrik) GetStreamCount() int {
-	log.Printf("GetStreamCount: Starting API call")
+func (r Rubrik) GetStreamCount(ctx context.Context) (int, error) {
+	// Stream count is only available from the CDM API
+	if r.securityCloud {
+		return 0, nil
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
-		log.Printf("GetStreamCount: Trying GraphQL")
+	if r.useGraphQL(ctx) {
 		var response StreamsCountResponse
-		err := r.graphqlClient.ExecuteQuery(context.Background(), StreamsCountQuery, nil, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, StreamsCountQuery, nil, &response)
 		if err == nil {
-			log.Printf("GetStreamCount: GraphQL succeeded, count: %d", response.System.Streams.Count)
-			return response.System.Streams.Count
+			slog.DebugContext(ctx, "Stream count", "backend", BackendGraphQL, "count", response.System.Streams.Count)
+			return response.System.Streams.Count, nil
 		}
-		log.Printf("GetStreamCount: GraphQL failed, falling back to REST: %v", err)
-	} else {
-		log.Printf("GetStreamCount: GraphQL client not available, using REST")
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "GetStreamCount", "err", err)
 	}
 
 	// Fallback to REST API
-	log.Printf("GetStreamCount: Trying REST API")
-	resp, err := r.makeRequest("GET", "/api/internal/stats/streams/count", RequestParams{})
-	if err != nil || resp == nil {
-		log.Printf("GetStreamCount: REST API failed: %v", err)
-		return 0
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/stats/streams/count", RequestParams{})
+	if err != nil {
+		return 0, err
 	}
 	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
 
 	var data map[string]int
-	json.Unmarshal(body, &data)
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		slog.WarnContext(ctx, "Decoding Rubrik API response failed", "call", "GetStreamCount", "err", err)
+		return 0, err
+	}
 	count := data["count"]
-	log.Printf("GetStreamCount: REST succeeded, count: %d", count)
-	return count
+	slog.DebugContext(ctx, "Stream count", "backend", BackendREST, "count", count)
+	return count, nil
 }
 
 // GetDataLocationUsage ...
-func (r Rubrik) GetDataLocationUsage() []DataLocationUsage {
+func (r Rubrik) GetDataLocationUsage(ctx context.Context) ([]DataLocationUsage, error) {
+	// Data location usage is only available from the CDM API
+	if r.securityCloud {
+		return []DataLocationUsage{}, nil
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
+	if r.useGraphQL(ctx) {
 		var response DataLocationUsageResponse
-		err := r.graphqlClient.ExecuteQuery(context.Background(), DataLocationUsageQuery, nil, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, DataLocationUsageQuery, nil, &response)
 		if err == nil {
 			// Convert GraphQL response to DataLocationUsage structs
 			usages := make([]DataLocationUsage, len(response.ArchiveLocations.Edges))
@@ -193,33 +201,39 @@ func (r Rubrik) GetDataLocationUsage() []DataLocationUsage {
 					NumManagedVolumesArchived:  edge.Node.NumManagedVolumesArchived,
 				}
 			}
-			return usages
+			return usages, nil
 		}
-		log.Printf("GraphQL GetDataLocationUsage failed, falling back to REST: %v", err)
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "GetDataLocationUsage", "err", err)
 	}
 
 	// Fallback to REST API
-	resp, err := r.makeRequest("GET", "/api/internal/stats/data_location/usage", RequestParams{})
-	if err != nil || resp == nil {
-		return []DataLocationUsage{}
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/stats/data_location/usage", RequestParams{})
+	if err != nil {
+		return []DataLocationUsage{}, err
 	}
 	defer resp.Body.Close()
 
 	var data DataLocationUsageList
 	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(&data)
+	if err := decoder.Decode(&data); err != nil {
+		return []DataLocationUsage{}, err
+	}
 
-	return data.Data
+	return data.Data, nil
 }
 
-func (r Rubrik) GetPhysicalIngest() []TimeStat {
+func (r Rubrik) GetPhysicalIngest(ctx context.Context) ([]TimeStat, error) {
+	// Time series are only available from the CDM API
+	if r.securityCloud {
+		return []TimeStat{}, nil
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
+	if r.useGraphQL(ctx) {
 		var response PhysicalIngestTimeSeriesResponse
 		variables := map[string]interface{}{
 			"range": "-10min",
 		}
-		err := r.graphqlClient.ExecuteQuery(context.Background(), PhysicalIngestTimeSeriesQuery, variables, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, PhysicalIngestTimeSeriesQuery, variables, &response)
 		if err == nil {
 			// Convert GraphQL response to TimeStat structs
 			stats := make([]TimeStat, len(response.System.PhysicalIngest.TimeSeries))
@@ -229,37 +243,44 @@ func (r Rubrik) GetPhysicalIngest() []TimeStat {
 					Stat: int(point.Value),
 				}
 			}
-			return stats
+			return stats, nil
 		}
-		log.Printf("GraphQL GetPhysicalIngest failed, falling back to REST: %v", err)
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "GetPhysicalIngest", "err", err)
 	}
 
 	// Fallback to REST API
-	resp, err := r.makeRequest("GET", "/api/internal/stats/physical_ingest/time_series", RequestParams{params: url.Values{"range": []string{"-10min"}}})
-	if err != nil || resp == nil {
-		return []TimeStat{}
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/stats/physical_ingest/time_series", RequestParams{params: url.Values{"range": []string{"-10min"}}})
+	if err != nil {
+		return []TimeStat{}, err
 	}
 	defer resp.Body.Close()
 
 	var data []TimeStat
 	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(&data)
+	if err := decoder.Decode(&data); err != nil {
+		return []TimeStat{}, err
+	}
 
-	return data
+	return data, nil
 }
 
-func (r Rubrik) GetArchivalBandwith(locationID string, timerange string) []TimeStat {
+func (r Rubrik) GetArchivalBandwith(ctx context.Context, locationID string, timerange string) ([]TimeStat, error) {
 	if timerange == "" {
 		timerange = "-1h"
 	}
 
+	// Time series are only available from the CDM API
+	if r.securityCloud {
+		return []TimeStat{}, nil
+	}
+
 	// Try GraphQL first
-	if r.graphqlClient != nil {
+	if r.useGraphQL(ctx) {
 		var response ArchivalBandwidthTimeSeriesResponse
 		variables := map[string]interface{}{
 			"range": timerange,
 		}
-		err := r.graphqlClient.ExecuteQuery(context.Background(), ArchivalBandwidthTimeSeriesQuery, variables, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, ArchivalBandwidthTimeSeriesQuery, variables, &response)
 		if err == nil {
 			// Convert GraphQL response to TimeStat structs
 			stats := make([]TimeStat, len(response.System.ArchivalBandwidth.TimeSeries))
@@ -269,74 +290,88 @@ func (r Rubrik) GetArchivalBandwith(locationID string, timerange string) []TimeS
 					Stat: int(point.Value),
 				}
 			}
-			return stats
+			return stats, nil
 		}
-		log.Printf("GraphQL GetArchivalBandwith failed, falling back to REST: %v", err)
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "GetArchivalBandwith", "err", err)
 	}
 
 	// Fallback to REST API
-	resp, err := r.makeRequest("GET", "/api/internal/stats/archival/bandwidth/time_series",
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/stats/archival/bandwidth/time_series",
 		RequestParams{params: url.Values{"data_location_id": []string{locationID}, "range": []string{timerange}}})
-	if err != nil || resp == nil {
-		return []TimeStat{}
+	if err != nil {
+		return []TimeStat{}, err
 	}
 	defer resp.Body.Close()
 
 	var data []TimeStat
 	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(&data)
+	if err := decoder.Decode(&data); err != nil {
+		return []TimeStat{}, err
+	}
 
-	return data
+	return data, nil
 }
 
 // GetRunawayRemaining - Get the number of days remaining before the system fills up.
-func (r Rubrik) GetRunawayRemaining() int {
+func (r Rubrik) GetRunawayRemaining(ctx context.Context) (int, error) {
+	if r.securityCloud {
+		response, err := r.rscGetClusterStats(ctx)
+		return response.Cluster.EstimatedRunway, err
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
+	if r.useGraphQL(ctx) {
 		var response RunwayRemainingResponse
-		err := r.graphqlClient.ExecuteQuery(context.Background(), RunwayRemainingQuery, nil, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, RunwayRemainingQuery, nil, &response)
 		if err == nil {
-			return response.System.RunwayRemaining
+			return response.System.RunwayRemaining, nil
 		}
-		log.Printf("GraphQL GetRunawayRemaining failed, falling back to REST: %v", err)
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "GetRunawayRemaining", "err", err)
 	}
 
 	// Fallback to REST API
-	resp, err := r.makeRequest("GET", "/api/internal/stats/runway_remaining", RequestParams{})
-	if err != nil || resp == nil {
-		return 0
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/stats/runway_remaining", RequestParams{})
+	if err != nil {
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	var data map[string]int
 	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(&data)
+	if err := decoder.Decode(&data); err != nil {
+		return 0, err
+	}
 
-	return data["days"]
+	return data["days"], nil
 }
 
 // GetAverageStorageGrowthPerDay - Get average storage growth per day.
-func (r Rubrik) GetAverageStorageGrowthPerDay() int {
+func (r Rubrik) GetAverageStorageGrowthPerDay(ctx context.Context) (int, error) {
+	if r.securityCloud {
+		response, err := r.rscGetClusterStats(ctx)
+		return int(response.Cluster.Metric.AverageDailyGrowth), err
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
+	if r.useGraphQL(ctx) {
 		var response AverageStorageGrowthResponse
-		err := r.graphqlClient.ExecuteQuery(context.Background(), AverageStorageGrowthQuery, nil, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, AverageStorageGrowthQuery, nil, &response)
 		if err == nil {
-			return int(response.System.AverageStorageGrowthPerDay)
+			return int(response.System.AverageStorageGrowthPerDay), nil
 		}
-		log.Printf("GraphQL GetAverageStorageGrowthPerDay failed, falling back to REST: %v", err)
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "GetAverageStorageGrowthPerDay", "err", err)
 	}
 
 	// Fallback to REST API
-	resp, err := r.makeRequest("GET", "/api/internal/stats/average_storage_growth_per_day", RequestParams{})
-	if err != nil || resp == nil {
-		return 0
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/stats/average_storage_growth_per_day", RequestParams{})
+	if err != nil {
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	var data map[string]int
 	decoder := json.NewDecoder(resp.Body)
-	decoder.Decode(&data)
+	if err := decoder.Decode(&data); err != nil {
+		return 0, err
+	}
 
-	return data["bytes"]
+	return data["bytes"], nil
 }
@@ -0,0 +1,124 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/logging"
+)
+
+// SessionStatus - State of the login to the API
+type SessionStatus struct {
+	URL           string `json:"url"`
+	SecurityCloud bool   `json:"security_cloud"`
+
+	LoggedIn  bool      `json:"logged_in"`
+	LoginTime time.Time `json:"login_time,omitzero"`
+	// ExpiresAt is zero if the token doesn't tell its expiry
+	ExpiresAt     time.Time `json:"expires_at,omitzero"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitzero"`
+}
+
+// Status - Returns the state of the login
+func (r Rubrik) Status() SessionStatus {
+	r.auth.mu.Lock()
+	s := r.auth.status
+	r.auth.mu.Unlock()
+
+	s.URL, s.SecurityCloud = r.url, r.securityCloud
+	return s
+}
+
+// tokenExpiry - When a token expires. OAuth2 answers tell the lifetime,
+// CDM session tokens are JWTs with an exp claim. Zero if neither is known.
+func tokenExpiry(token string, expiresIn int) time.Time {
+	if expiresIn > 0 {
+		return time.Now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
+
+// EndpointStatus - The calls to one endpoint of a backend
+type EndpointStatus struct {
+	Backend  Backend `json:"backend"`
+	Endpoint string  `json:"endpoint"`
+	Calls    int     `json:"calls"`
+	Errors   int     `json:"errors"`
+	// Last call
+	Time      time.Time     `json:"time"`
+	Duration  time.Duration `json:"duration_ns"`
+	LastError string        `json:"last_error,omitempty"`
+}
+
+var endpoints = struct {
+	mu sync.Mutex
+	m  map[[2]string]*EndpointStatus
+}{m: make(map[[2]string]*EndpointStatus)}
+
+// recordEndpoint - Remember c as the last call to its endpoint
+func recordEndpoint(c Call) {
+	endpoints.mu.Lock()
+	defer endpoints.mu.Unlock()
+
+	key := [2]string{string(c.Backend), c.Endpoint}
+	e, ok := endpoints.m[key]
+	if !ok {
+		e = &EndpointStatus{Backend: c.Backend, Endpoint: c.Endpoint}
+		endpoints.m[key] = e
+	}
+	e.Calls++
+	e.Time = time.Now()
+	e.Duration = c.Duration
+	e.LastError = ""
+	if c.Err != nil {
+		e.Errors++
+		e.LastError = logging.Redact(c.Err.Error())
+	}
+}
+
+// Endpoints - Returns the endpoints called so far, by backend and endpoint
+func Endpoints() []EndpointStatus {
+	endpoints.mu.Lock()
+	list := make([]EndpointStatus, 0, len(endpoints.m))
+	for _, e := range endpoints.m {
+		list = append(list, *e)
+	}
+	endpoints.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Backend != list[j].Backend {
+			return list[i].Backend < list[j].Backend
+		}
+		return list[i].Endpoint < list[j].Endpoint
+	})
+	return list
+}
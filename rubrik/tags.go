@@ -0,0 +1,144 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+)
+
+const (
+	// Get the vSphere tags of the VMs, page by page
+	VMwareVMTagsQuery = `
+	query VMwareVMTags($first: Int, $after: String) {
+		vmwareVms(first: $first, after: $after) {
+			edges {
+				node {
+					id
+					vsphereTagPath {
+						name
+						objectType
+					}
+				}
+			}
+			pageInfo {
+				hasNextPage
+				endCursor
+			}
+		}
+	}`
+
+	// Get the vSphere tags of the VMs of a cluster
+	RSCVsphereVMTagsQuery = `
+	query RscVsphereVmTags($first: Int, $after: String, $clusterId: String!) {
+		vSphereVmNewConnection(first: $first, after: $after, filter: [
+			{field: CLUSTER_ID, texts: [$clusterId]},
+			{field: IS_RELIC, texts: ["false"]},
+			{field: IS_REPLICATED, texts: ["false"]}
+		]) {
+			nodes {
+				id
+				vsphereTagPath {
+					name
+					objectType
+				}
+			}
+			pageInfo {
+				hasNextPage
+				endCursor
+			}
+		}
+	}`
+)
+
+// Object types of a vsphereTagPath
+const (
+	pathTagCategory = "VSphereTagCategory"
+	pathTag         = "VSphereTag"
+)
+
+// vmTagNode - A VM with its vSphere tags
+type vmTagNode struct {
+	ID      string     `json:"id"`
+	TagPath []pathNode `json:"vsphereTagPath"`
+}
+
+// VMwareVMTagsResponse ...
+type VMwareVMTagsResponse struct {
+	VmwareVms struct {
+		Edges []struct {
+			Node vmTagNode `json:"node"`
+		} `json:"edges"`
+		PageInfo rscPageInfo `json:"pageInfo"`
+	} `json:"vmwareVms"`
+}
+
+// tags - The tag path as category to tag name. A tag path lists each
+// category before its tags.
+func (n vmTagNode) tags() map[string]string {
+	tags := make(map[string]string)
+	category := ""
+	for _, p := range n.TagPath {
+		switch p.ObjectType {
+		case pathTagCategory:
+			category = p.Name
+		case pathTag:
+			tags[category] = p.Name
+		}
+	}
+	return tags
+}
+
+// GetVMTags - The vSphere tags of the VMware VMs by VM ID, as category to
+// tag name. Tags are only available with GraphQL, CDM pages through the VMs
+// like Security Cloud.
+func (r Rubrik) GetVMTags(ctx context.Context) (map[string]map[string]string, error) {
+	tags := make(map[string]map[string]string)
+	if r.securityCloud {
+		err := r.rscPaginate(ctx, RSCVsphereVMTagsQuery, "vSphereVmNewConnection", map[string]interface{}{"clusterId": r.cluster.ID}, func(nodes json.RawMessage) error {
+			var page []vmTagNode
+			if err := json.Unmarshal(nodes, &page); err != nil {
+				return err
+			}
+			for _, n := range page {
+				tags[n.ID] = n.tags()
+			}
+			return nil
+		})
+		if err != nil {
+			slog.WarnContext(ctx, "Getting the VM tags failed", "err", err)
+			return nil, err
+		}
+		return tags, nil
+	}
+
+	if !r.useGraphQL(ctx) {
+		return nil, errors.New("VM tags need the GraphQL API")
+	}
+	vars := map[string]interface{}{"first": rscPageSize}
+	for {
+		var response VMwareVMTagsResponse
+		if err := r.graphqlClient.ExecuteQuery(ctx, VMwareVMTagsQuery, vars, &response); err != nil {
+			slog.WarnContext(ctx, "Getting the VM tags failed", "err", err)
+			return nil, err
+		}
+		for _, edge := range response.VmwareVms.Edges {
+			tags[edge.Node.ID] = edge.Node.tags()
+		}
+		page := response.VmwareVms.PageInfo
+		if !page.HasNextPage || page.EndCursor == "" {
+			return tags, nil
+		}
+		vars["after"] = page.EndCursor
+	}
+}
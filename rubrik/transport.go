@@ -0,0 +1,205 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultMaxIdleConns is used when Options.MaxIdleConns is not set
+	DefaultMaxIdleConns = 10
+	// DefaultMaxRetries is the recommended value of Options.MaxRetries
+	DefaultMaxRetries = 3
+
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// newHTTPClient - Create the client shared by all calls of an API instance.
+// Connections are kept alive, requests are rate limited and transient errors
+// are retried.
+func newHTTPClient(opts Options) *http.Client {
+	if opts.ReplayDir != "" {
+		replay, err := newReplayTransport(opts.ReplayDir)
+		if err != nil {
+			slog.Error("Can't load capture", "err", err)
+			return &http.Client{Transport: errorTransport{err}}
+		}
+		return &http.Client{Transport: spanTransport{replay}, Timeout: opts.RequestTimeout}
+	}
+
+	tr := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+		MaxIdleConns:        opts.MaxIdleConns,
+		MaxIdleConnsPerHost: opts.MaxIdleConns,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	var next http.RoundTripper = tr
+	// Every attempt of a retried request waits for the limiter again
+	if l := newLimiter(opts.RateLimit, opts.RateBurst, opts.MaxConcurrentRequests); l != nil {
+		next = &limitTransport{next: next, limiter: l}
+	}
+	next = &retryTransport{next: next, maxRetries: opts.MaxRetries}
+	// Record what the exporter got in the end, not each attempt
+	if opts.RecordDir != "" {
+		next = newRecordTransport(next, opts.RecordDir)
+	}
+	return &http.Client{
+		Transport: spanTransport{next},
+		Timeout:   opts.RequestTimeout,
+	}
+}
+
+// errorTransport - Fails every request with err
+type errorTransport struct {
+	err error
+}
+
+// RoundTrip ...
+func (t errorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// retryTransport - Repeats requests failing with 5xx, 429 or a reset
+// connection, with exponential backoff and jitter
+type retryTransport struct {
+	next       http.RoundTripper
+	maxRetries int
+}
+
+// RoundTrip ...
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	send := req
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(send)
+
+		reason := retryReason(resp, err)
+		if reason == "" || attempt >= t.maxRetries {
+			return resp, err
+		}
+		retry, ok := rewindBody(req)
+		if !ok {
+			return resp, err
+		}
+
+		delay := backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp); ok {
+				delay = after
+			}
+		}
+
+		// Don't wait for a retry that can't finish in time, give back
+		// what we have
+		if delay > retryMaxDelay {
+			return resp, err
+		}
+		if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < delay {
+			return resp, err
+		}
+
+		if resp != nil {
+			// Drain the body so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		slog.InfoContext(req.Context(), "Retrying Rubrik API request", "path", req.URL.Path, "reason", reason, "delay", delay, "attempt", attempt+1, "max_retries", t.maxRetries)
+		apiRetries.WithLabelValues(reason).Inc()
+
+		if err := sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+		send = retry
+	}
+}
+
+// retryReason - Returns why a request should be repeated, or "" if it
+// shouldn't
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return "connection"
+		}
+		return ""
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return strconv.Itoa(resp.StatusCode)
+	}
+	return ""
+}
+
+// rewindBody - A copy of req to send again with a fresh body, req itself
+// is left alone. Returns false if the body can't be replayed.
+func rewindBody(req *http.Request) (*http.Request, bool) {
+	retry := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return retry, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	retry.Body = body
+	return retry, true
+}
+
+// backoff - Exponential delay for the given attempt, randomized between
+// half and the full delay so retries of parallel scrapes spread out
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << attempt
+	if d <= 0 || d > retryMaxDelay {
+		d = retryMaxDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryAfter - Parse the Retry-After header, either seconds or an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// sleep - Wait for d or until ctx ends
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,65 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package rubrik
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// roundTripFunc ...
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip ...
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRetryKeepsRequest(t *testing.T) {
+	var sent []*http.Request
+	var bodies []string
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent = append(sent, req)
+		body, _ := io.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		status := http.StatusOK
+		if len(sent) == 1 {
+			status = http.StatusServiceUnavailable
+		}
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{"Retry-After": []string{"0"}},
+			Body:       io.NopCloser(strings.NewReader("")),
+		}, nil
+	})
+
+	req, err := http.NewRequest(http.MethodPost, "https://rubrik.invalid/api/graphql", strings.NewReader(`{"query":"q"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := req.Body
+	resp, err := (&retryTransport{next: next, maxRetries: 1}).RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("RoundTrip() = %v, %v", resp, err)
+	}
+
+	if len(sent) != 2 || sent[1] == req {
+		t.Fatalf("the retry wasn't sent as a copy of the request: %d attempts", len(sent))
+	}
+	if req.Body != body {
+		t.Error("the body of the caller's request was replaced")
+	}
+	if bodies[0] != `{"query":"q"}` || bodies[1] != bodies[0] {
+		t.Errorf("sent bodies %q, want the same body twice", bodies)
+	}
+}
@@ -13,13 +13,43 @@ package rubrik
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"strings"
 )
 
 type VirtualMachine struct {
-	ID                   string `json:"id"`
-	Name                 string `json:"name"`
-	EffectiveSLADomainID string `json:"effectiveSlaDomainId"`
+	ID                     string `json:"id"`
+	Name                   string `json:"name"`
+	EffectiveSLADomainID   string `json:"effectiveSlaDomainId"`
+	EffectiveSLADomainName string `json:"effectiveSlaDomainName"`
+	// Hypervisor is set by ListAllVM
+	Hypervisor string `json:"-"`
+	// Folder is the vCenter path of the VM, vcenter/datacenter/folder/...,
+	// only known with GraphQL
+	Folder string `json:"-"`
+}
+
+// Hypervisors of ListAllVM
+const (
+	HypervisorVMware  = "vmware"
+	HypervisorNutanix = "nutanix"
+	HypervisorHyperV  = "hyperv"
+)
+
+// pathNode - An entry of a GraphQL logicalPath, the vCenter first
+type pathNode struct {
+	Name       string `json:"name"`
+	ObjectType string `json:"objectType"`
+}
+
+// folderPath - The names of the logical path joined with /
+func folderPath(path []pathNode) string {
+	names := make([]string, len(path))
+	for i, p := range path {
+		names[i] = p.Name
+	}
+	return strings.Join(names, "/")
 }
 
 type VirtualMachineList struct {
@@ -29,125 +59,161 @@ type VirtualMachineList struct {
 
 // ListAllVM retrieves a list of all Virtual Machine ID and Name
 // for All kinds of hypervisors (vmware, nutanix, hyperv)
-func (r Rubrik) ListAllVM() []VirtualMachine {
+// A hypervisor that fails doesn't hide the VMs of the others, the errors are
+// returned together with the partial list.
+func (r Rubrik) ListAllVM(ctx context.Context) ([]VirtualMachine, error) {
 	var list []VirtualMachine
-	list = append(list, r.ListVmwareVM()...)
-	list = append(list, r.ListNutanixVM()...)
-	list = append(list, r.ListHypervVM()...)
+	var errs []error
+	for _, h := range []struct {
+		hypervisor string
+		list       func(context.Context) ([]VirtualMachine, error)
+	}{
+		{HypervisorVMware, r.ListVmwareVM},
+		{HypervisorNutanix, r.ListNutanixVM},
+		{HypervisorHyperV, r.ListHypervVM},
+	} {
+		vms, err := h.list(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, vm := range vms {
+			vm.Hypervisor = h.hypervisor
+			list = append(list, vm)
+		}
+	}
 
-	return list
+	return list, errors.Join(errs...)
 }
 
 // ListVmwareVM retrieve a List of all known VMware VM's
-func (r Rubrik) ListVmwareVM() []VirtualMachine {
+func (r Rubrik) ListVmwareVM(ctx context.Context) ([]VirtualMachine, error) {
+	if r.securityCloud {
+		return r.rscListVM(ctx, RSCVsphereVMsQuery, "vSphereVmNewConnection")
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
+	if r.useGraphQL(ctx) {
 		var response VMwareVMsResponse
-		err := r.graphqlClient.ExecuteQuery(context.Background(), VMwareVMsQuery, nil, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, VMwareVMsQuery, nil, &response)
 		if err == nil {
 			// Convert GraphQL response to VirtualMachine structs
 			vms := make([]VirtualMachine, len(response.VmwareVms.Edges))
 			for i, edge := range response.VmwareVms.Edges {
-				slaID := ""
+				slaID, slaName := "", ""
 				if edge.Node.EffectiveSlaDomain != nil {
-					slaID = edge.Node.EffectiveSlaDomain.ID
+					slaID, slaName = edge.Node.EffectiveSlaDomain.ID, edge.Node.EffectiveSlaDomain.Name
 				}
 				vms[i] = VirtualMachine{
-					ID:                   edge.Node.ID,
-					Name:                 edge.Node.Name,
-					EffectiveSLADomainID: slaID,
+					ID:                     edge.Node.ID,
+					Name:                   edge.Node.Name,
+					EffectiveSLADomainID:   slaID,
+					EffectiveSLADomainName: slaName,
+					Folder:                 folderPath(edge.Node.LogicalPath),
 				}
 			}
-			return vms
+			return vms, nil
 		}
-		log.Printf("GraphQL ListVmwareVM failed, falling back to REST: %v", err)
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "ListVmwareVM", "err", err)
 	}
 
 	// Fallback to REST API
-	resp, err := r.makeRequest("GET", "/api/v1/vmware/vm", RequestParams{})
-	if err != nil || resp == nil {
-		return []VirtualMachine{}
+	resp, err := r.makeRequest(ctx, "GET", "/api/v1/vmware/vm", RequestParams{})
+	if err != nil {
+		return []VirtualMachine{}, err
 	}
 	defer resp.Body.Close()
 
 	data := json.NewDecoder(resp.Body)
 	var s VirtualMachineList
-	data.Decode(&s)
-	return s.Data
+	if err := data.Decode(&s); err != nil {
+		return []VirtualMachine{}, err
+	}
+	return s.Data, nil
 }
 
 // ListNutanixVM retrieve a List of all known Nutanix VM's
-func (r Rubrik) ListNutanixVM() []VirtualMachine {
+func (r Rubrik) ListNutanixVM(ctx context.Context) ([]VirtualMachine, error) {
+	if r.securityCloud {
+		return r.rscListVM(ctx, RSCNutanixVMsQuery, "nutanixVms")
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
+	if r.useGraphQL(ctx) {
 		var response NutanixVMsResponse
-		err := r.graphqlClient.ExecuteQuery(context.Background(), NutanixVMsQuery, nil, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, NutanixVMsQuery, nil, &response)
 		if err == nil {
 			// Convert GraphQL response to VirtualMachine structs
 			vms := make([]VirtualMachine, len(response.NutanixVms.Edges))
 			for i, edge := range response.NutanixVms.Edges {
-				slaID := ""
+				slaID, slaName := "", ""
 				if edge.Node.EffectiveSlaDomain != nil {
-					slaID = edge.Node.EffectiveSlaDomain.ID
+					slaID, slaName = edge.Node.EffectiveSlaDomain.ID, edge.Node.EffectiveSlaDomain.Name
 				}
 				vms[i] = VirtualMachine{
-					ID:                   edge.Node.ID,
-					Name:                 edge.Node.Name,
-					EffectiveSLADomainID: slaID,
+					ID:                     edge.Node.ID,
+					Name:                   edge.Node.Name,
+					EffectiveSLADomainID:   slaID,
+					EffectiveSLADomainName: slaName,
 				}
 			}
-			return vms
+			return vms, nil
 		}
-		log.Printf("GraphQL ListNutanixVM failed, falling back to REST: %v", err)
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "ListNutanixVM", "err", err)
 	}
 
 	// Fallback to REST API
-	resp, err := r.makeRequest("GET", "/api/internal/nutanix/vm", RequestParams{})
-	if err != nil || resp == nil {
-		return []VirtualMachine{}
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/nutanix/vm", RequestParams{})
+	if err != nil {
+		return []VirtualMachine{}, err
 	}
 	defer resp.Body.Close()
 
 	data := json.NewDecoder(resp.Body)
 	var s VirtualMachineList
-	data.Decode(&s)
-	return s.Data
+	if err := data.Decode(&s); err != nil {
+		return []VirtualMachine{}, err
+	}
+	return s.Data, nil
 }
 
 // ListHypervVM retrieve a List of all known Hyper-V VM's
-func (r Rubrik) ListHypervVM() []VirtualMachine {
+func (r Rubrik) ListHypervVM(ctx context.Context) ([]VirtualMachine, error) {
+	if r.securityCloud {
+		return r.rscListVM(ctx, RSCHypervVMsQuery, "hypervVirtualMachines")
+	}
 	// Try GraphQL first
-	if r.graphqlClient != nil {
+	if r.useGraphQL(ctx) {
 		var response HypervVMsResponse
-		err := r.graphqlClient.ExecuteQuery(context.Background(), HypervVMsQuery, nil, &response)
+		err := r.graphqlClient.ExecuteQuery(ctx, HypervVMsQuery, nil, &response)
 		if err == nil {
 			// Convert GraphQL response to VirtualMachine structs
 			vms := make([]VirtualMachine, len(response.HypervVms.Edges))
 			for i, edge := range response.HypervVms.Edges {
-				slaID := ""
+				slaID, slaName := "", ""
 				if edge.Node.EffectiveSlaDomain != nil {
-					slaID = edge.Node.EffectiveSlaDomain.ID
+					slaID, slaName = edge.Node.EffectiveSlaDomain.ID, edge.Node.EffectiveSlaDomain.Name
 				}
 				vms[i] = VirtualMachine{
-					ID:                   edge.Node.ID,
-					Name:                 edge.Node.Name,
-					EffectiveSLADomainID: slaID,
+					ID:                     edge.Node.ID,
+					Name:                   edge.Node.Name,
+					EffectiveSLADomainID:   slaID,
+					EffectiveSLADomainName: slaName,
 				}
 			}
-			return vms
+			return vms, nil
 		}
-		log.Printf("GraphQL ListHypervVM failed, falling back to REST: %v", err)
+		slog.DebugContext(ctx, "GraphQL failed, falling back to REST", "call", "ListHypervVM", "err", err)
 	}
 
 	// Fallback to REST API
-	resp, err := r.makeRequest("GET", "/api/internal/hyperv/vm", RequestParams{})
-	if err != nil || resp == nil {
-		return []VirtualMachine{}
+	resp, err := r.makeRequest(ctx, "GET", "/api/internal/hyperv/vm", RequestParams{})
+	if err != nil {
+		return []VirtualMachine{}, err
 	}
 	defer resp.Body.Close()
 
 	data := json.NewDecoder(resp.Body)
 	var s VirtualMachineList
-	data.Decode(&s)
-	return s.Data
+	if err := data.Decode(&s); err != nil {
+		return []VirtualMachine{}, err
+	}
+	return s.Data, nil
 }
@@ -0,0 +1,543 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+// Package rubriktest provides a fake Rubrik API for tests. It serves recorded
+// responses for every REST endpoint and GraphQL query the exporter uses, for
+// CDM clusters as well as Rubrik Security Cloud, and can inject failures.
+package rubriktest
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed fixtures
+var fixtureFS embed.FS
+
+// Credentials accepted by the server
+const (
+	Username     = "prometheus@local"
+	Password     = "secret"
+	ClientID     = "client|exporter"
+	ClientSecret = "client-secret"
+)
+
+// Fault - A failure injected into the answer of an endpoint
+type Fault int
+
+// Faults
+const (
+	// NoFault answers with the fixture
+	NoFault Fault = iota
+	// Unauthorized answers with HTTP 401
+	Unauthorized
+	// NotFound answers with HTTP 404, like a cluster without the endpoint
+	NotFound
+	// ServerError answers with HTTP 503
+	ServerError
+	// Timeout doesn't answer until the client gives up
+	Timeout
+	// Malformed answers with a truncated JSON document
+	Malformed
+	// GraphQLError answers a GraphQL query with an errors array
+	GraphQLError
+	// Forbidden answers with HTTP 403, like a role without the permission
+	Forbidden
+)
+
+// Fixture keys of the authentication endpoints. REST endpoints use
+// rest/<name>, GraphQL queries graphql/<operation name>. The prefixes rest and
+// graphql address all endpoints of their kind.
+const (
+	KeyLogin  = "login"
+	KeyLogout = "logout"
+)
+
+// restRoutes maps the REST endpoints to their fixture
+var restRoutes = map[string]string{
+	"GET /api/v1/cluster/me":                                 "rest/cluster_me",
+	"GET /api/v1/vmware/vm":                                  "rest/vmware_vm",
+	"GET /api/internal/nutanix/vm":                           "rest/nutanix_vm",
+	"GET /api/internal/hyperv/vm":                            "rest/hyperv_vm",
+	"GET /api/internal/node":                                 "rest/node",
+	"GET /api/internal/node/{id}/stats":                      "rest/node_stats",
+	"GET /api/internal/managed_volume":                       "rest/managed_volume",
+	"GET /api/internal/archive/location":                     "rest/archive_location",
+	"GET /api/internal/report":                               "rest/report",
+	"GET /api/internal/report/{id}/chart":                    "rest/report_chart",
+	"GET /api/internal/stats/system_storage":                 "rest/system_storage",
+	"GET /api/internal/stats/per_vm_storage":                 "rest/per_vm_storage",
+	"GET /api/internal/stats/streams/count":                  "rest/streams_count",
+	"GET /api/internal/stats/data_location/usage":            "rest/data_location_usage",
+	"GET /api/internal/stats/physical_ingest/time_series":    "rest/physical_ingest",
+	"GET /api/internal/stats/archival/bandwidth/time_series": "rest/archival_bandwidth",
+	"GET /api/internal/stats/runway_remaining":               "rest/runway_remaining",
+	"GET /api/internal/stats/average_storage_growth_per_day": "rest/average_storage_growth",
+}
+
+var operationName = regexp.MustCompile(`(?:query|mutation)\s+(\w+)`)
+
+// clusterFilter matches the CLUSTER_ID filter of a Security Cloud query, the
+// argument name, whether the value is a list and its variable
+var clusterFilter = regexp.MustCompile(`field:\s*CLUSTER_ID,\s*(\w+):\s*(\[)?\s*\$(\w+)`)
+
+// Server - A fake Rubrik API. Every request except the login needs the
+// session token handed out by the last login.
+type Server struct {
+	*httptest.Server
+
+	// PageSize caps the nodes or edges of a connection per page, 0 serves
+	// as many as the client asks for
+	PageSize int
+
+	mu       sync.Mutex
+	token    string
+	logins   int
+	fixtures map[string][]byte
+	faults   map[string]fault
+	requests map[string]int
+
+	// hold answers with HTTP 401 are held back until released is closed
+	hold     int
+	released chan struct{}
+}
+
+type fault struct {
+	fault Fault
+	once  bool
+}
+
+// NewServer - Start a fake Rubrik API serving the recorded fixtures
+func NewServer() *Server {
+	s := &Server{
+		fixtures: make(map[string][]byte),
+		faults:   make(map[string]fault),
+		requests: make(map[string]int),
+	}
+
+	entries, err := fixtureFS.ReadDir("fixtures")
+	if err != nil {
+		panic(err)
+	}
+	for _, dir := range entries {
+		files, err := fixtureFS.ReadDir(path.Join("fixtures", dir.Name()))
+		if err != nil {
+			panic(err)
+		}
+		for _, f := range files {
+			data, err := fixtureFS.ReadFile(path.Join("fixtures", dir.Name(), f.Name()))
+			if err != nil {
+				panic(err)
+			}
+			s.fixtures[dir.Name()+"/"+strings.TrimSuffix(f.Name(), ".json")] = data
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/session", s.handleLogin)
+	mux.HandleFunc("POST /api/client_token", s.handleLogin)
+	mux.HandleFunc("DELETE /api/v1/session", s.handleLogout)
+	mux.HandleFunc("DELETE /api/session", s.handleLogout)
+	mux.HandleFunc("POST /api/graphql", s.handleGraphQL)
+	for pattern, key := range restRoutes {
+		mux.HandleFunc(pattern, s.handleREST(key))
+	}
+
+	s.Server = httptest.NewTLSServer(mux)
+	return s
+}
+
+// SetFixture - Replace the recorded response of a fixture key
+func (s *Server) SetFixture(key string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixtures[key] = body
+}
+
+// Fixture - The response served for a fixture key, nil if there is none
+func (s *Server) Fixture(key string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fixtures[key]
+}
+
+// Inject - Answer every request for key with the fault until Clear is called
+func (s *Server) Inject(key string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[key] = fault{fault: f}
+}
+
+// InjectOnce - Answer the next request for key with the fault
+func (s *Server) InjectOnce(key string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[key] = fault{fault: f, once: true}
+}
+
+// Clear - Remove all injected faults
+func (s *Server) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = make(map[string]fault)
+}
+
+// ExpireSession - Invalidate the current session token, the next request is
+// answered with 401 until the client logs in again
+func (s *Server) ExpireSession() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
+
+// HoldUnauthorized - Hold back the next n answers with HTTP 401 until all n
+// are due, so concurrent clients see the expired session at the same time
+func (s *Server) HoldUnauthorized(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hold = n
+	s.released = make(chan struct{})
+}
+
+// unauthorized - Answer with HTTP 401 once the held back answers are due
+func (s *Server) unauthorized(w http.ResponseWriter, body string) {
+	s.mu.Lock()
+	released := s.released
+	if released != nil {
+		if s.hold--; s.hold == 0 {
+			close(s.released)
+			s.released = nil
+		}
+	}
+	s.mu.Unlock()
+	if released != nil {
+		<-released
+	}
+	http.Error(w, body, http.StatusUnauthorized)
+}
+
+// Logins - Number of successful logins
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// Requests - Number of requests received for key
+func (s *Server) Requests(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[key]
+}
+
+// begin - Count the request and return the fault to answer it with
+func (s *Server) begin(key string) Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[key]++
+
+	prefix, _, _ := strings.Cut(key, "/")
+	for _, k := range []string{key, prefix} {
+		if f, ok := s.faults[k]; ok {
+			if f.once {
+				delete(s.faults, k)
+			}
+			return f.fault
+		}
+	}
+	return NoFault
+}
+
+// authorized ...
+func (s *Server) authorized(r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token != "" && r.Header.Get("Authorization") == "Bearer "+s.token
+}
+
+// handleLogin - Basic auth session login, OAuth2 client credentials as form
+// (CDM) or JSON (Security Cloud)
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if s.fail(w, r, s.begin(KeyLogin)) {
+		return
+	}
+
+	var ok, oauth bool
+	if user, password, basic := r.BasicAuth(); basic {
+		ok = (user == Username && password == Password) || (user == ClientID && password == ClientSecret)
+	} else if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			ClientID     string `json:"client_id"`
+			ClientSecret string `json:"client_secret"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		ok = body.ClientID == ClientID && body.ClientSecret == ClientSecret
+		oauth = true
+	} else {
+		r.ParseForm()
+		ok = r.PostForm.Get("grant_type") == "client_credentials" &&
+			r.PostForm.Get("client_id") == ClientID && r.PostForm.Get("client_secret") == ClientSecret
+		oauth = true
+	}
+	if !ok {
+		http.Error(w, `{"message":"Incorrect username/password"}`, http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	s.logins++
+	s.token = "token-" + strconv.Itoa(s.logins)
+	token, login := s.token, s.logins
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if oauth {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": token, "token_type": "Bearer", "expires_in": 3600,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"id": "session-" + strconv.Itoa(login), "organizationId": "org", "token": token, "userId": "user",
+	})
+}
+
+// handleLogout ...
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if s.fail(w, r, s.begin(KeyLogout)) {
+		return
+	}
+	if !s.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.ExpireSession()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleREST ...
+func (s *Server) handleREST(key string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.fail(w, r, s.begin(key)) {
+			return
+		}
+		if !s.authorized(r) {
+			s.unauthorized(w, `{"message":"Unauthorized"}`)
+			return
+		}
+		s.writeFixture(w, key)
+	}
+}
+
+// handleGraphQL - Answer a query with the fixture of its operation name.
+// Connections queried with first/after are paginated.
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m := operationName.FindStringSubmatch(req.Query)
+	if m == nil {
+		http.Error(w, "anonymous operations are not supported", http.StatusBadRequest)
+		return
+	}
+	key := "graphql/" + m[1]
+
+	f := s.begin(key)
+	if f == GraphQLError {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"data":null,"errors":[{"message":"Cannot query field on type Query","path":[%q]}]}`, m[1])
+		return
+	}
+	if s.fail(w, r, f) {
+		return
+	}
+	if !s.authorized(r) {
+		s.unauthorized(w, `{"errors":[{"message":"UNAUTHENTICATED"}]}`)
+		return
+	}
+
+	s.mu.Lock()
+	body, ok := s.fixtures[key]
+	pageSize := s.PageSize
+	s.mu.Unlock()
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"data":null,"errors":[{"message":"no fixture for %s"}]}`, m[1])
+		return
+	}
+
+	var clusterID string
+	if m := clusterFilter.FindStringSubmatch(req.Query); m != nil {
+		// Like Security Cloud, only a list of texts filters by cluster
+		if m[1] != "texts" || m[2] == "" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"data":null,"errors":[{"message":"CLUSTER_ID filter needs texts, got %s"}]}`, m[1])
+			return
+		}
+		clusterID, _ = req.Variables[m[3]].(string)
+	}
+	body, err := filterCluster(body, clusterID)
+	if err == nil {
+		body, err = paginate(body, req.Variables, pageSize)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// fail - Answer with the fault, returns false for NoFault
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, f Fault) bool {
+	switch f {
+	case Unauthorized:
+		http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+	case Forbidden:
+		http.Error(w, `{"message":"Forbidden"}`, http.StatusForbidden)
+	case NotFound:
+		http.Error(w, `{"message":"Not found"}`, http.StatusNotFound)
+	case ServerError:
+		http.Error(w, `{"message":"Service unavailable"}`, http.StatusServiceUnavailable)
+	case Timeout:
+		<-r.Context().Done()
+	case Malformed, GraphQLError:
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"data": [{"id": "trunc`)
+	default:
+		return false
+	}
+	return true
+}
+
+// writeFixture ...
+func (s *Server) writeFixture(w http.ResponseWriter, key string) {
+	s.mu.Lock()
+	body, ok := s.fixtures[key]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, `{"message":"no fixture for `+key+`"}`, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// filterCluster - Drop the nodes of the connections in a GraphQL response
+// that belong to another cluster than clusterID. Nodes without a cluster and
+// an empty clusterID are kept.
+func filterCluster(body []byte, clusterID string) ([]byte, error) {
+	if clusterID == "" {
+		return body, nil
+	}
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	for root, raw := range resp.Data {
+		var conn map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &conn); err != nil || conn["nodes"] == nil {
+			continue
+		}
+		var nodes []json.RawMessage
+		if err := json.Unmarshal(conn["nodes"], &nodes); err != nil {
+			continue
+		}
+		kept := []json.RawMessage{}
+		for _, n := range nodes {
+			var node struct {
+				Cluster *struct {
+					ID string `json:"id"`
+				} `json:"cluster"`
+			}
+			if err := json.Unmarshal(n, &node); err != nil || node.Cluster == nil || node.Cluster.ID == clusterID {
+				kept = append(kept, n)
+			}
+		}
+		var err error
+		if conn["nodes"], err = json.Marshal(kept); err != nil {
+			return nil, err
+		}
+		if resp.Data[root], err = json.Marshal(conn); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(resp)
+}
+
+// paginate - Cut the nodes or edges of a connection in a GraphQL response
+// down to the page requested with first/after
+func paginate(body []byte, variables map[string]interface{}, pageSize int) ([]byte, error) {
+	first, ok := variables["first"].(float64)
+	if !ok {
+		return body, nil
+	}
+	limit := int(first)
+	if pageSize > 0 && pageSize < limit {
+		limit = pageSize
+	}
+	offset := 0
+	if after, ok := variables["after"].(string); ok && after != "" {
+		offset, _ = strconv.Atoi(after)
+	}
+
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	for root, raw := range resp.Data {
+		// Security Cloud lists nodes, CDM edges
+		var conn map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &conn); err != nil {
+			continue
+		}
+		field := "nodes"
+		if _, ok := conn[field]; !ok {
+			field = "edges"
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(conn[field], &items); err != nil || items == nil {
+			continue
+		}
+
+		end := min(offset+limit, len(items))
+		start := min(offset, end)
+		page, err := json.Marshal(map[string]interface{}{
+			field: items[start:end],
+			"pageInfo": map[string]interface{}{
+				"hasNextPage": end < len(items),
+				"endCursor":   strconv.Itoa(end),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		resp.Data[root] = page
+	}
+	return json.Marshal(resp)
+}
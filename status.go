@@ -0,0 +1,232 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/logging"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+)
+
+// collectorStatus - The last run of a collector, shown on the status page
+type collectorStatus struct {
+	Name          string        `json:"name"`
+	LastRun       time.Time     `json:"last_run,omitzero"`
+	Duration      time.Duration `json:"duration_ns"`
+	Success       bool          `json:"success"`
+	LastSuccess   time.Time     `json:"last_success,omitzero"`
+	LastError     string        `json:"last_error,omitempty"`
+	LastErrorTime time.Time     `json:"last_error_time,omitzero"`
+}
+
+// collectorRuns - The last run of every collector, updated by
+// scrapeCollector
+var collectorRuns = struct {
+	mu sync.Mutex
+	m  map[string]*collectorStatus
+}{m: make(map[string]*collectorStatus)}
+
+// recordRun - Remember a run of the collector name that ended now
+func recordRun(name string, duration time.Duration, err error) {
+	collectorRuns.mu.Lock()
+	defer collectorRuns.mu.Unlock()
+
+	s, ok := collectorRuns.m[name]
+	if !ok {
+		s = &collectorStatus{Name: name}
+		collectorRuns.m[name] = s
+	}
+	s.LastRun = time.Now()
+	s.Duration = duration
+	s.Success = err == nil
+	if err == nil {
+		s.LastSuccess = s.LastRun
+		return
+	}
+	s.LastError = logging.Redact(err.Error())
+	s.LastErrorTime = s.LastRun
+}
+
+// collectorStatuses - The status of every collector by name, also of those
+// that never ran
+func collectorStatuses(collectors map[string]Collector) []collectorStatus {
+	collectorRuns.mu.Lock()
+	list := make([]collectorStatus, 0, len(collectors))
+	for name := range collectors {
+		s := collectorStatus{Name: name}
+		if run, ok := collectorRuns.m[name]; ok {
+			s = *run
+		}
+		list = append(list, s)
+	}
+	collectorRuns.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// exporterStatus - Everything shown by /status
+type exporterStatus struct {
+	Started    time.Time               `json:"started"`
+	Session    rubrik.SessionStatus    `json:"session"`
+	Collectors []collectorStatus       `json:"collectors"`
+	Endpoints  []rubrik.EndpointStatus `json:"endpoints"`
+}
+
+// sessionReady - A login succeeded and its token hasn't expired
+func sessionReady(s rubrik.SessionStatus) bool {
+	return s.LoggedIn && (s.ExpiresAt.IsZero() || time.Now().Before(s.ExpiresAt))
+}
+
+// healthyHandler - The process is up and serving
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "Healthy\n")
+}
+
+// readyHandler - Ready once logged in to Rubrik and every enabled collector
+// succeeded at least once, so a scrape returns all metrics
+func readyHandler(api *rubrik.Rubrik, collectors map[string]Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := api.Status()
+		if !sessionReady(s) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, "Not ready, not logged in to Rubrik\n")
+			if s.LastError != "" {
+				io.WriteString(w, s.LastError+"\n")
+			}
+			return
+		}
+
+		var pending []string
+		for _, c := range collectorStatuses(collectors) {
+			if c.LastSuccess.IsZero() {
+				pending = append(pending, c.Name)
+			}
+		}
+		if len(pending) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, "Not ready, no successful collection yet: "+strings.Join(pending, ", ")+"\n")
+			return
+		}
+		io.WriteString(w, "Ready\n")
+	})
+}
+
+// statusHandler - The login, the collectors and the API endpoints as HTML,
+// or as JSON with ?format=json or an Accept header asking for it
+func statusHandler(api *rubrik.Rubrik, collectors map[string]Collector, started time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := exporterStatus{
+			Started:    started,
+			Session:    api.Status(),
+			Collectors: collectorStatuses(collectors),
+			Endpoints:  rubrik.Endpoints(),
+		}
+
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(status)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		statusTemplate.Execute(w, status)
+	})
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"ready": sessionReady,
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format(time.RFC3339) + " (" + time.Since(t).Round(time.Second).String() + " ago)"
+	},
+	"expiry": func(t time.Time) string {
+		if t.IsZero() {
+			return "unknown"
+		}
+		return t.Format(time.RFC3339) + " (in " + time.Until(t).Round(time.Second).String() + ")"
+	},
+	"duration": func(d time.Duration) string {
+		return d.Round(time.Millisecond).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>Rubrik Exporter Status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.ok { color: #080; }
+.failed { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Rubrik Exporter Status</h1>
+<p><a href="/">Home</a> - <a href="?format=json">JSON</a></p>
+
+<h2>Session</h2>
+<table>
+<tr><th>URL</th><td>{{ .Session.URL }}{{ if .Session.SecurityCloud }} (Rubrik Security Cloud){{ end }}</td></tr>
+<tr><th>Started</th><td>{{ time .Started }}</td></tr>
+<tr><th>Logged in</th><td>{{ if ready .Session }}<span class="ok">yes</span>{{ else }}<span class="failed">no</span>{{ end }}</td></tr>
+<tr><th>Login</th><td>{{ time .Session.LoginTime }}</td></tr>
+<tr><th>Token expiry</th><td>{{ expiry .Session.ExpiresAt }}</td></tr>
+{{- if .Session.LastError }}
+<tr><th>Last login error</th><td class="failed">{{ time .Session.LastErrorTime }}: {{ .Session.LastError }}</td></tr>
+{{- end }}
+</table>
+
+<h2>Collectors</h2>
+<table>
+<tr><th>Collector</th><th>Last run</th><th>Duration</th><th>Result</th><th>Last success</th><th>Last error</th></tr>
+{{- range .Collectors }}
+<tr>
+<td>{{ .Name }}</td>
+<td>{{ time .LastRun }}</td>
+<td>{{ if not .LastRun.IsZero }}{{ duration .Duration }}{{ end }}</td>
+<td>{{ if .LastRun.IsZero }}-{{ else if .Success }}<span class="ok">success</span>{{ else }}<span class="failed">failed</span>{{ end }}</td>
+<td>{{ time .LastSuccess }}</td>
+<td>{{ if .LastError }}{{ time .LastErrorTime }}: {{ .LastError }}{{ end }}</td>
+</tr>
+{{- end }}
+</table>
+
+<h2>API endpoints</h2>
+<table>
+<tr><th>Backend</th><th>Endpoint</th><th>Calls</th><th>Errors</th><th>Last call</th><th>Duration</th><th>Last error</th></tr>
+{{- range .Endpoints }}
+<tr>
+<td>{{ .Backend }}</td>
+<td>{{ .Endpoint }}</td>
+<td>{{ .Calls }}</td>
+<td>{{ .Errors }}</td>
+<td>{{ time .Time }}</td>
+<td>{{ duration .Duration }}</td>
+<td{{ if .LastError }} class="failed"{{ end }}>{{ .LastError }}</td>
+</tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,122 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubrik"
+	"github.com/Gattancha-Computer-Services/rubrik-exporter/rubriktest"
+)
+
+// resetCollectorRuns - Forget the collector runs of earlier tests
+func resetCollectorRuns() {
+	collectorRuns.mu.Lock()
+	collectorRuns.m = make(map[string]*collectorStatus)
+	collectorRuns.mu.Unlock()
+}
+
+func TestStatus(t *testing.T) {
+	resetCollectorRuns()
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	srv.Inject("graphql", rubriktest.NotFound)
+	srv.Inject("rest/managed_volume", rubriktest.ServerError)
+
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+	collectors := newCollectors(collectorConfig{})
+	gatherCollectors(context.Background(), collectors, newCoalescer(0))
+
+	get := func(h http.Handler, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	if w := get(http.HandlerFunc(healthyHandler), "/-/healthy"); w.Code != http.StatusOK {
+		t.Errorf("/-/healthy: status %d", w.Code)
+	}
+	if w := get(readyHandler(rubrikAPI, collectors), "/-/ready"); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "managed_volume") {
+		t.Errorf("/-/ready with a failing collector: status %d %s", w.Code, w.Body)
+	}
+
+	w := get(statusHandler(rubrikAPI, collectors, time.Now()), "/status?format=json")
+	var status exporterStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("/status?format=json: %v\n%s", err, w.Body)
+	}
+	if !status.Session.LoggedIn || status.Session.URL != srv.URL {
+		t.Errorf("session %+v", status.Session)
+	}
+	if len(status.Collectors) != len(collectors) {
+		t.Errorf("%d collectors, want %d", len(status.Collectors), len(collectors))
+	}
+	for _, c := range status.Collectors {
+		failed := c.Name == "managed_volume"
+		if c.LastRun.IsZero() || c.Success == failed || (failed && !strings.Contains(c.LastError, "managed_volume")) {
+			t.Errorf("collector %+v", c)
+		}
+	}
+	var rest, graphql bool
+	for _, e := range status.Endpoints {
+		switch {
+		case e.Backend == rubrik.BackendREST && e.Endpoint == "/api/internal/managed_volume":
+			rest = e.Errors > 0 && strings.Contains(e.LastError, "503")
+		case e.Backend == rubrik.BackendGraphQL:
+			graphql = graphql || e.Errors > 0
+		}
+	}
+	if !rest || !graphql {
+		t.Errorf("endpoints don't show the failed REST and GraphQL calls: %+v", status.Endpoints)
+	}
+
+	w = get(statusHandler(rubrikAPI, collectors, time.Now()), "/status")
+	if body := w.Body.String(); !strings.Contains(w.Header().Get("Content-Type"), "text/html") || !strings.Contains(body, "managed_volume") || !strings.Contains(body, "/api/internal/managed_volume") {
+		t.Errorf("/status HTML:\n%s", body)
+	}
+
+	srv.Clear()
+	srv.Inject("graphql", rubriktest.NotFound)
+	gatherCollectors(context.Background(), collectors, newCoalescer(0))
+	if w := get(readyHandler(rubrikAPI, collectors), "/-/ready"); w.Code != http.StatusOK {
+		t.Errorf("/-/ready after every collector succeeded: status %d %s", w.Code, w.Body)
+	}
+
+	rubrikAPI.Logout(context.Background())
+	if w := get(readyHandler(rubrikAPI, collectors), "/-/ready"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("/-/ready after logout: status %d", w.Code)
+	}
+}
+
+func TestReadyBeforeCollection(t *testing.T) {
+	srv := rubriktest.NewServer()
+	defer srv.Close()
+	rubrikAPI = rubrik.NewRubrik(context.Background(), srv.URL, rubriktest.Username, rubrik.StaticSecret(rubriktest.Password), "", rubrik.StaticSecret(""), rubrik.Options{})
+	collectors := newCollectors(collectorConfig{})
+	// Log in with a collection, then forget the runs as after a restart
+	gatherCollectors(context.Background(), collectors, newCoalescer(0))
+	resetCollectorRuns()
+
+	w := httptest.NewRecorder()
+	readyHandler(rubrikAPI, collectors).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("/-/ready before a collection: status %d", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "archive, managed_volume, rubrik, vm") {
+		t.Errorf("/-/ready doesn't list the collectors: %s", body)
+	}
+}
@@ -0,0 +1,178 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+// Package tracing records spans of scrapes and Rubrik API calls with the
+// OpenTelemetry SDK, which hands them to an exporter in batches. Without a
+// tracer set by SetTracer, Start returns a nil *Span whose methods do
+// nothing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Scope - The instrumentation scope of the spans
+const Scope = "github.com/Gattancha-Computer-Services/rubrik-exporter"
+
+// SpanKind ...
+type SpanKind = trace.SpanKind
+
+// Span kinds
+const (
+	KindInternal = trace.SpanKindInternal
+	KindServer   = trace.SpanKindServer
+	KindClient   = trace.SpanKindClient
+)
+
+// Attribute - A key with a string, int64, float64 or bool value
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// keyValue - Other types than string, bool, integers and floats are
+// formatted as string
+func (a Attribute) keyValue() attribute.KeyValue {
+	switch v := a.Value.(type) {
+	case string:
+		return attribute.String(a.Key, v)
+	case bool:
+		return attribute.Bool(a.Key, v)
+	case int:
+		return attribute.Int(a.Key, v)
+	case int64:
+		return attribute.Int64(a.Key, v)
+	case float64:
+		return attribute.Float64(a.Key, v)
+	}
+	return attribute.String(a.Key, fmt.Sprint(a.Value))
+}
+
+// Span - One timed operation of a trace
+type Span struct {
+	span trace.Span
+}
+
+// SetAttribute ...
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(Attribute{key, value}.keyValue())
+}
+
+// RecordError - Mark the span as failed, nil errors are ignored
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// Finish - End the span, it is exported if it is sampled
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
+
+// Sampled - Reports whether the span is exported
+func (s *Span) Sampled() bool {
+	return s != nil && s.span.SpanContext().IsSampled()
+}
+
+// TraceID - Lower case hex, as in traceparent and exemplars
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.span.SpanContext().TraceID().String()
+}
+
+// Tracer - Samples new traces and exports the sampled spans in batches
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// NewTracer - Sample ratio of the new traces, 0 to 1. Traces continued
+// from a traceparent follow its sampling decision.
+func NewTracer(ratio float64, exporter sdktrace.SpanExporter) *Tracer {
+	return newTracer(ratio, sdktrace.WithBatcher(exporter))
+}
+
+func newTracer(ratio float64, processor sdktrace.TracerProviderOption) *Tracer {
+	provider := sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "rubrik-exporter"))),
+	)
+	return &Tracer{provider: provider, tracer: provider.Tracer(Scope)}
+}
+
+// Shutdown - Export the remaining spans and stop
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+var global atomic.Pointer[Tracer]
+
+// SetTracer - Start recording spans with t
+func SetTracer(t *Tracer) {
+	global.Store(t)
+}
+
+// Start - Begin a span as child of the span in ctx. Returns ctx unchanged
+// and a nil span if no tracer is set.
+func Start(ctx context.Context, name string, kind SpanKind, attrs ...Attribute) (context.Context, *Span) {
+	t := global.Load()
+	if t == nil {
+		return ctx, nil
+	}
+
+	kvs := make([]attribute.KeyValue, len(attrs))
+	for i, a := range attrs {
+		kvs[i] = a.keyValue()
+	}
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(kvs...))
+	return ctx, &Span{span}
+}
+
+// SpanFromContext - Returns the current span, nil if there is none or it
+// isn't recorded, like the parent taken from a traceparent header
+func SpanFromContext(ctx context.Context) *Span {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return nil
+	}
+	return &Span{span}
+}
+
+// Extract - Continue the trace of a W3C traceparent header
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return propagation.TraceContext{}.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject - Set the traceparent header for the span in ctx
+func Inject(ctx context.Context, header http.Header) {
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,103 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStartWithoutTracer(t *testing.T) {
+	SetTracer(nil)
+
+	ctx := context.Background()
+	got, span := Start(ctx, "scrape", KindServer)
+	if got != ctx || span != nil {
+		t.Fatal("Start() without a tracer returned a span")
+	}
+	// Methods of the nil span do nothing
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.New("failed"))
+	span.Finish()
+}
+
+func TestSampling(t *testing.T) {
+	defer SetTracer(nil)
+
+	for _, ratio := range []float64{0, 1} {
+		exporter := tracetest.NewInMemoryExporter()
+		SetTracer(newTracer(ratio, sdktrace.WithSyncer(exporter)))
+		_, span := Start(context.Background(), "scrape", KindServer)
+		span.Finish()
+		if span.Sampled() != (ratio == 1) || len(exporter.GetSpans()) != int(ratio) {
+			t.Errorf("ratio %g: Sampled() = %t, %d spans exported", ratio, span.Sampled(), len(exporter.GetSpans()))
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	SetTracer(newTracer(0, sdktrace.WithSyncer(exporter)))
+	defer SetTracer(nil)
+
+	// The caller sampled the trace, the ratio doesn't matter
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, scrape := Start(Extract(context.Background(), header), "scrape", KindServer)
+	if !scrape.Sampled() || scrape.TraceID() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("span doesn't continue the traceparent: trace %s sampled %t", scrape.TraceID(), scrape.Sampled())
+	}
+	if SpanFromContext(ctx).TraceID() != scrape.TraceID() {
+		t.Error("SpanFromContext() doesn't return the started span")
+	}
+
+	_, call := Start(ctx, "GET /api/v1/cluster/me", KindClient, Attribute{"rubrik.backend", "rest"})
+	call.SetAttribute("http.response.status_code", int64(500))
+	call.RecordError(errors.New("HTTP 500"))
+	call.Finish()
+	call.Finish()
+	scrape.Finish()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans exported, want 2", len(spans))
+	}
+	got, parent := spans[0], spans[1]
+	if got.Parent.SpanID() != parent.SpanContext.SpanID() || parent.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("span tree %s < %s < %s", got.SpanContext.SpanID(), got.Parent.SpanID(), parent.Parent.SpanID())
+	}
+	want := []attribute.KeyValue{attribute.String("rubrik.backend", "rest"), attribute.Int64("http.response.status_code", 500)}
+	if len(got.Attributes) != 2 || got.Attributes[0] != want[0] || got.Attributes[1] != want[1] {
+		t.Errorf("call span has attributes %v, want %v", got.Attributes, want)
+	}
+	if got.SpanKind != KindClient || got.Status.Code != codes.Error || got.Status.Description != "HTTP 500" {
+		t.Errorf("call span kind %s, status %v", got.SpanKind, got.Status)
+	}
+
+	out := http.Header{}
+	Inject(ctx, out)
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + parent.SpanContext.SpanID().String() + "-01"; out.Get("traceparent") != want {
+		t.Errorf("traceparent = %q, want %q", out.Get("traceparent"), want)
+	}
+
+	// Malformed headers start a new trace
+	header.Set("traceparent", "00-xyz-00f067aa0ba902b7-01")
+	if _, s := Start(Extract(context.Background(), header), "scrape", KindServer); s.Sampled() {
+		t.Error("malformed traceparent was followed")
+	}
+}
@@ -0,0 +1,176 @@
+//
+// rubrik-exporter
+//
+// Exports metrics from rubrik backup for prometheus
+//
+// License: Apache License Version 2.0,
+// Organization: Claranet GmbH
+// Author: Martin Weber <martin.weber@de.clara.net>
+//
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// selfSigned - PEM certificate and key for localhost
+func selfSigned(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func writeWebConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "web.yml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func indent(s string) string {
+	return "    " + strings.ReplaceAll(strings.TrimSpace(s), "\n", "\n    ")
+}
+
+func TestWebServer(t *testing.T) {
+	cert, key := selfSigned(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("scrape"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := writeWebConfig(t, "tls_server_config:\n  cert: |\n"+indent(cert)+"\n  key: |\n"+indent(key)+`
+http_server_config:
+  headers:
+    X-Frame-Options: deny
+basic_auth_users:
+  prometheus: `+string(hash)+"\n")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "rubrik_up 1\n")
+	})
+
+	listeners := make([]net.Listener, 2)
+	for i := range listeners {
+		if listeners[i], err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// One server per listener like main, web.Serve wraps its handler
+	flags := &web.FlagConfig{WebConfigFile: &configFile}
+	for _, l := range listeners {
+		server := &http.Server{Handler: mux}
+		go web.Serve(l, server, flags, slog.New(slog.DiscardHandler))
+		defer server.Close()
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM([]byte(cert))
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"}}}
+
+	get := func(address, path, user, password string) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, "https://"+address+path, nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	for _, l := range listeners {
+		address := l.Addr().String()
+		for _, tc := range []struct {
+			user, password string
+			code           int
+		}{
+			{"", "", http.StatusUnauthorized},
+			{"prometheus", "wrong", http.StatusUnauthorized},
+			{"nobody", "scrape", http.StatusUnauthorized},
+			{"prometheus", "scrape", http.StatusOK},
+			{"prometheus", "scrape", http.StatusOK},
+		} {
+			resp, body := get(address, "/metrics", tc.user, tc.password)
+			if resp.StatusCode != tc.code {
+				t.Errorf("%s as %q: status %d, want %d", address, tc.user, resp.StatusCode, tc.code)
+			}
+			if resp.Header.Get("X-Frame-Options") != "deny" {
+				t.Errorf("%s: configured header missing", address)
+			}
+			if tc.code == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Errorf("%s: 401 without WWW-Authenticate", address)
+			}
+			if tc.code == http.StatusOK && body != "rubrik_up 1\n" {
+				t.Errorf("%s: body %q", address, body)
+			}
+		}
+	}
+
+	if resp, _ := get(listeners[0].Addr().String(), "/other", "prometheus", "scrape"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown path: status %d", resp.StatusCode)
+	}
+}
+
+func TestWebConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		config string
+		err    string
+	}{
+		{"basic_auth_user:\n  prometheus: x\n", "field basic_auth_user not found"},
+		{"http_server_config:\n  headers:\n    Server: rubrik\n", "HTTP header \"Server\" can not be configured"},
+		{"tls_server_config:\n  cert_file: missing.crt\n  key_file: missing.key\n", "missing.crt"},
+		{"tls_server_config:\n  min_version: TLS99\n", "unknown TLS version"},
+	} {
+		err := web.Validate(writeWebConfig(t, tc.config))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: error %v, want %q", tc.config, err, tc.err)
+		}
+	}
+}